@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Percentages are only judged once this many tracks were tried, so the
+// first failure of a run doesn't count as 100%.
+const alertMinTracks = 5
+
+// failureAlert raises an alarm once per run when the failed tracks exceed a
+// count or a percentage, so unattended jobs that go wrong are noticed early.
+// The server starts a new run for each queue item.
+type failureAlert struct {
+	count   int64
+	percent float64
+	webhook string
+
+	mu            sync.Mutex
+	sent          bool
+	baseFailed    int64 // metrics when the run started
+	baseCompleted int64
+}
+
+var alert = &failureAlert{}
+
+// parseThreshold sets the threshold from "10" or "20%".
+func (a *failureAlert) parseThreshold(s string) error {
+	if p, ok := strings.CutSuffix(s, "%"); ok {
+		n, err := strconv.ParseFloat(p, 64)
+		if err != nil || n <= 0 || n > 100 {
+			return fmt.Errorf("invalid percentage: %s", s)
+		}
+		a.percent = n
+		return nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 1 {
+		return fmt.Errorf("invalid count: %s", s)
+	}
+	a.count = n
+	return nil
+}
+
+// recordFailure counts a failed track, raises the alert when the threshold
+// is crossed and stops the run at --max-failures.
+func recordFailure() {
+	metrics.failed.Add(1)
+	alert.check()
+	policy.trackFailed()
+}
+
+// reset starts a new run: the tracks of earlier ones don't count and the
+// alert can be raised again.
+func (a *failureAlert) reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sent = false
+	a.baseFailed = metrics.failed.Load()
+	a.baseCompleted = metrics.completed.Load()
+}
+
+// counts returns the failed and completed tracks of the run.
+func (a *failureAlert) counts() (int64, int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return metrics.failed.Load() - a.baseFailed, metrics.completed.Load() - a.baseCompleted
+}
+
+func (a *failureAlert) check() {
+	if a.count == 0 && a.percent == 0 {
+		return
+	}
+	failed, completed := a.counts()
+	tried := failed + completed
+
+	exceeded := a.count > 0 && failed >= a.count
+	if a.percent > 0 && tried >= alertMinTracks && float64(failed)*100/float64(tried) >= a.percent {
+		exceeded = true
+	}
+	if !exceeded {
+		return
+	}
+
+	a.mu.Lock()
+	sent := a.sent
+	a.sent = true
+	a.mu.Unlock()
+	if !sent {
+		message := fmt.Sprintf("%d of %d tracks failed so far", failed, tried)
+		logf("%s\n", colorize("failed", "Alert: "+message))
+		a.send(message)
+	}
+}
+
+// send shows the alert on the desktop, posts it to --alert-webhook and
+// passes it on to the --notify notifiers.
+func (a *failureAlert) send(message string) {
+	failed, completed := a.counts()
+	ev := progressEvent{
+		Event:      "failure_threshold",
+		Time:       time.Now(),
+		Error:      message,
+		Successful: int(completed),
+		Failed:     int(failed),
+	}
+
+	var channels []Notifier
+	if !torMode {
+		channels = append(channels, desktopNotifier{})
+	}
+	if a.webhook != "" {
+		channels = append(channels, webhookNotifier{url: a.webhook})
+	}
+	// Sent aside like the queued notifiers, the download goes on meanwhile
+	pendingNotifications.Add(1)
+	go func() {
+		defer pendingNotifications.Done()
+		for _, n := range channels {
+			if err := n.Notify(ev); err != nil {
+				logf("Error sending the failure alert: %v\n", err)
+			}
+		}
+	}()
+	emitProgress(ev)
+}
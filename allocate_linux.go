@@ -0,0 +1,14 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// allocateFile reserves size bytes of disk space for f without changing
+// its size, so a resumed download still starts at the end of what was
+// written.
+func allocateFile(f *os.File, size int64) error {
+	const keepSize = 0x01 // FALLOC_FL_KEEP_SIZE
+	return syscall.Fallocate(int(f.Fd()), keepSize, 0, size)
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// allocateFile extends f to size, which makes Windows and SMB shares
+// reserve the space up front. The file is cut back to what was written if
+// the download doesn't finish.
+func allocateFile(f *os.File, size int64) error {
+	return f.Truncate(size)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// createArchive packs dir into a single zip or tar.gz file next to it and
+// returns the path of the archive.
+func createArchive(dir, format string) (string, error) {
+	var ext string
+	switch format {
+	case "zip":
+		ext = ".zip"
+	case "tar.gz", "tgz":
+		ext = ".tar.gz"
+	default:
+		return "", fmt.Errorf("unknown archive format: %s", format)
+	}
+
+	archivePath := filepath.Clean(dir) + ext
+	tmpPath := archivePath + ".tmp"
+
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return "", err
+	}
+
+	if ext == ".zip" {
+		err = writeZip(out, dir)
+	} else {
+		err = writeTarGz(out, dir)
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+
+	if err := os.Rename(tmpPath, archivePath); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	return archivePath, nil
+}
+
+// walkArchiveFiles calls fn for every regular file in dir with its path
+// inside the archive, which keeps the album folder as the top level entry.
+func walkArchiveFiles(dir string, fn func(path, name string, info fs.FileInfo) error) error {
+	base := filepath.Dir(filepath.Clean(dir))
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() || d.Name() == lockFileName || d.Name() == extractedFileName || isPartialFile(d.Name()) {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		return fn(path, filepath.ToSlash(rel), info)
+	})
+}
+
+// isPartialFile tells the leftovers of unfinished downloads and
+// extractions, which a new run resumes and the archive leaves out.
+func isPartialFile(name string) bool {
+	return strings.HasSuffix(name, ".tmp") || strings.HasSuffix(name, ".part.zip") || strings.HasSuffix(name, ".part.rar")
+}
+
+func writeZip(w io.Writer, dir string) error {
+	zw := zip.NewWriter(w)
+	err := walkArchiveFiles(dir, func(path, name string, info fs.FileInfo) error {
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		// Audio and images are already compressed
+		header.Method = zip.Store
+
+		entry, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		return copyFileTo(entry, path)
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeTarGz(w io.Writer, dir string) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	err := walkArchiveFiles(dir, func(path, name string, info fs.FileInfo) error {
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = name
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		return copyFileTo(tw, path)
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func copyFileTo(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"archive/zip"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// scansArchive is what --art-archive bundles the album images into.
+const scansArchive = "Scans.zip"
+
+// archiveArt bundles the album images into Scans.zip in the album folder,
+// the way collectors keep booklet scans. The front cover stays loose as
+// folder.jpg for players and file managers; with --flat the images are
+// next to the tracks and cover.jpg stays as it is. The bundled images are
+// removed once the archive is complete.
+func archiveArt(downloadDir, frontPath string, opts *Options) error {
+	var files []string
+	if opts.Flat {
+		entries, err := os.ReadDir(downloadDir)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			path := filepath.Join(downloadDir, e.Name())
+			if e.Type().IsRegular() && contains(imageExtensions, strings.ToLower(filepath.Ext(e.Name()))) && path != frontPath {
+				files = append(files, path)
+			}
+		}
+	} else {
+		artDir := filepath.Join(downloadDir, opts.ArtDir)
+		err := filepath.WalkDir(artDir, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && d.Type().IsRegular() {
+				files = append(files, path)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		if frontPath != "" {
+			folder := filepath.Join(downloadDir, "folder"+strings.ToLower(filepath.Ext(frontPath)))
+			if err := copyFile(frontPath, folder); err != nil {
+				return err
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	slices.Sort(files)
+
+	archivePath := filepath.Join(downloadDir, scansArchive)
+	tmpPath := archivePath + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	err = writeScansZip(out, files, downloadDir, opts)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, archivePath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	for _, path := range files {
+		os.Remove(path)
+	}
+	if !opts.Flat {
+		removeEmptyDirs(filepath.Join(downloadDir, opts.ArtDir))
+	}
+	logf("Bundled %d image(s) into %s\n", len(files), scansArchive)
+	return nil
+}
+
+// writeScansZip stores the images by their path in the art folder.
+func writeScansZip(out *os.File, files []string, downloadDir string, opts *Options) error {
+	base := downloadDir
+	if !opts.Flat {
+		base = filepath.Join(downloadDir, opts.ArtDir)
+	}
+	zw := zip.NewWriter(out)
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		// Images are already compressed
+		header.Method = zip.Store
+
+		entry, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if err := copyFileTo(entry, path); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// removeEmptyDirs removes dir and the folders in it that are empty.
+func removeEmptyDirs(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			removeEmptyDirs(filepath.Join(dir, e.Name()))
+		}
+	}
+	os.Remove(dir) // fails unless empty
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	bitratePattern    = regexp.MustCompile(`(?i)(\d+)\s*kbps`)
+	sampleRatePattern = regexp.MustCompile(`(?i)(\d+(?:\.\d+)?)\s*(khz|hz)\b`)
+	channelsPattern   = regexp.MustCompile(`(?i)\b(mono|stereo|(\d)\s*channels?)\b`)
+)
+
+// parseSongProperties reads the audio properties a song page lists next to
+// the download links, e.g. "320 kbps, 44.1 kHz, Stereo". The bitrate from
+// the song table is kept when the page has none.
+func parseSongProperties(song *Song, text string) {
+	if m := bitratePattern.FindStringSubmatch(text); m != nil {
+		song.Bitrate, _ = strconv.Atoi(m[1])
+	}
+	if m := sampleRatePattern.FindStringSubmatch(text); m != nil {
+		rate, _ := strconv.ParseFloat(m[1], 64)
+		if strings.EqualFold(m[2], "khz") {
+			rate *= 1000
+		}
+		song.SampleRate = int(rate)
+	}
+	if m := channelsPattern.FindStringSubmatch(text); m != nil {
+		switch strings.ToLower(m[1]) {
+		case "mono":
+			song.Channels = 1
+		case "stereo":
+			song.Channels = 2
+		default:
+			song.Channels, _ = strconv.Atoi(m[2])
+		}
+	}
+}
+
+// songProperties formats what is known of a song's audio properties, or ""
+func songProperties(song *Song) string {
+	var parts []string
+	if song.Bitrate > 0 {
+		parts = append(parts, fmt.Sprintf("%d kbps", song.Bitrate))
+	}
+	if song.SampleRate > 0 {
+		parts = append(parts, strconv.FormatFloat(float64(song.SampleRate)/1000, 'f', -1, 64)+" kHz")
+	}
+	switch song.Channels {
+	case 0:
+	case 1:
+		parts = append(parts, "mono")
+	case 2:
+		parts = append(parts, "stereo")
+	default:
+		parts = append(parts, fmt.Sprintf("%d channels", song.Channels))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// bitrateTooLow reports whether a lossy-only album falls below --min-bitrate.
+// Albums with a lossless format always pass; without a bitrate in the song
+// table the first song page is checked.
+func bitrateTooLow(album *Album, minBitrate int) (string, bool) {
+	if minBitrate == 0 || len(album.Songs) == 0 {
+		return "", false
+	}
+	for _, format := range album.Formats {
+		if isLossless(format) {
+			return "", false
+		}
+	}
+
+	bitrate := 0
+	for _, song := range album.Songs {
+		bitrate = max(bitrate, song.Bitrate)
+	}
+	if bitrate == 0 {
+		first := album.Songs[0]
+		if len(first.DownloadLinks) == 0 {
+			if err := ParseDownloadLinks(first); err != nil {
+				return "", false
+			}
+		}
+		for format := range first.DownloadLinks {
+			if isLossless(format) {
+				return "", false
+			}
+		}
+		bitrate = first.Bitrate
+	}
+
+	switch {
+	case bitrate == 0:
+		return "", false
+	case bitrate < minBitrate:
+		return fmt.Sprintf("lossy at %d kbps, below %d", bitrate, minBitrate), true
+	}
+	return "", false
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// serverAuth holds the credentials that give full access to the server,
+// next to the tokens of the configured users.
+type serverAuth struct {
+	tokens    []string
+	basicUser string
+	basicPass string
+}
+
+func (a *serverAuth) parseBasic(s string) error {
+	user, pass, ok := strings.Cut(s, ":")
+	if !ok || user == "" || pass == "" {
+		return fmt.Errorf("invalid --basic-auth, expected user:password")
+	}
+	pass, err := resolveSecret(pass)
+	if err != nil {
+		return err
+	}
+	a.basicUser, a.basicPass = user, pass
+	return nil
+}
+
+func secretEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// requireAuth lets a request through when it carries a server token or a
+// user's token (as "Authorization: Bearer <token>" or a token query
+// parameter) or the basic auth credentials. Requests made with a user's
+// token only see that user's albums. Without any credentials configured
+// every request is let through.
+func (s *server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		users := s.users
+		s.mu.Unlock()
+
+		if len(s.auth.tokens) == 0 && s.auth.basicUser == "" && len(users) == 0 {
+			next(w, r)
+			return
+		}
+
+		if name, pass, ok := r.BasicAuth(); ok && s.auth.basicUser != "" {
+			if secretEqual(name, s.auth.basicUser) && secretEqual(pass, s.auth.basicPass) {
+				next(w, r)
+				return
+			}
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			token = r.URL.Query().Get("token")
+		}
+		if token != "" {
+			for _, t := range s.auth.tokens {
+				if secretEqual(token, t) {
+					next(w, r)
+					return
+				}
+			}
+			for i := range users {
+				if user := &users[i]; secretEqual(token, user.Token) {
+					next(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+					return
+				}
+			}
+		}
+
+		if s.auth.basicUser != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+programName+`"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or wrong credentials"})
+	}
+}
+
+// requireAdmin is requireAuth for the endpoints that affect or expose the
+// whole server: a user's token isn't enough, only the server's own
+// credentials are.
+func (s *server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return s.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		if requestUser(r) != nil {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "needs the server's --token or --basic-auth credentials"})
+			return
+		}
+		next(w, r)
+	})
+}
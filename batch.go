@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// maxHostConnections caps the requests in flight to any one host, shared by
+// all albums downloading at the same time.
+var maxHostConnections = 4
+
+var (
+	hostSlotsMu sync.Mutex
+	hostSlots   = make(map[string]chan struct{})
+)
+
+// acquireHost blocks until a connection slot for the URL's host is free and
+// returns the function releasing it.
+func acquireHost(rawURL string) func() {
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		host = u.Host
+	}
+
+	hostSlotsMu.Lock()
+	slots, ok := hostSlots[host]
+	if !ok {
+		slots = make(chan struct{}, max(maxHostConnections, 1))
+		hostSlots[host] = slots
+	}
+	hostSlotsMu.Unlock()
+
+	slots <- struct{}{}
+	return func() { <-slots }
+}
+
+// downloadBatch runs download for each URL and its index in urls, up to
+// opts.AlbumConcurrency at a time, and returns how many of them failed. Once
+// the failure policy stopped the run, the remaining URLs aren't started.
+func downloadBatch(urls []string, opts *Options, download func(int, string) error) int {
+	workers := opts.AlbumConcurrency
+	if workers < 1 || politeMode {
+		workers = 1
+	}
+
+	var (
+		mu     sync.Mutex
+		failed int
+		wg     sync.WaitGroup
+	)
+	queue := make(chan int) // index into urls
+	for range min(workers, len(urls)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			first := true
+			for i := range queue {
+				albumURL := urls[i]
+				caps.check()
+				if policy.isStopped() {
+					continue
+				}
+				if !first {
+					albumCooldown(opts)
+				}
+				first = false
+				err := func() error {
+					defer recoverCrash(albumURL)
+					return download(i, albumURL)
+				}()
+				if err != nil {
+					mu.Lock()
+					failed++
+					mu.Unlock()
+				}
+				policy.albumFinished(err != nil)
+			}
+		}()
+	}
+	for i := range urls {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+	return failed
+}
+
+// albumCooldown waits --album-delay and a random part of --album-jitter
+// between albums, so a long crawl doesn't hit the site in one steady stream.
+func albumCooldown(opts *Options) {
+	wait := opts.AlbumDelay
+	if opts.AlbumJitter > 0 {
+		wait += rand.N(opts.AlbumJitter)
+	}
+	if wait <= 0 {
+		return
+	}
+	logf("\nWaiting %v before the next album...\n", wait.Round(time.Second))
+	time.Sleep(wait)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// splitBatchLine separates a line of a batch file into the album URL and
+// the album's own options after a |, e.g.
+//
+//	https://downloads.khinsider.com/game-soundtracks/album/... | format=mp3 tracks=1-10 dir=Extras
+func splitBatchLine(line string) (string, string) {
+	albumURL, overrides, _ := strings.Cut(line, "|")
+	return strings.TrimSpace(albumURL), strings.TrimSpace(overrides)
+}
+
+// withAlbumOverrides returns a copy of opts with the per-album options of a
+// batch line applied. Only options that concern a single album can be set
+// this way; the ones for the whole run (connections, caps, notifiers) stay
+// on the command line.
+func withAlbumOverrides(opts *Options, overrides string) (*Options, error) {
+	o := *opts
+	fields, err := splitQuoted(overrides)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range fields {
+		key, value, hasValue := strings.Cut(field, "=")
+		key = strings.TrimPrefix(strings.ToLower(key), "--")
+		needValue := func() error {
+			if !hasValue || value == "" {
+				return fmt.Errorf("%s needs a value, e.g. %s=...", key, key)
+			}
+			return nil
+		}
+		flag := func(dst *bool, on bool) error {
+			switch {
+			case !hasValue:
+				*dst = on
+			case value == "true":
+				*dst = on
+			case value == "false":
+				*dst = !on
+			default:
+				return fmt.Errorf("%s takes true or false, not %s", key, value)
+			}
+			return nil
+		}
+
+		switch key {
+		case "format":
+			err = needValue()
+			o.Format = strings.ToLower(value)
+			if err == nil && !contains(audioFormats, strings.ToUpper(value)) {
+				err = fmt.Errorf("unknown format: %s", value)
+			}
+		case "tracks":
+			if err = needValue(); err == nil {
+				_, err = parseTrackList(value)
+				o.Tracks = value
+			}
+		case "dir":
+			if err = needValue(); err == nil && !filepath.IsAbs(value) {
+				value = filepath.Join(opts.OutputDir, value)
+			}
+			o.OutputDir = value
+		case "prefer":
+			err = needValue()
+			o.Prefer = strings.ToLower(value)
+			if o.Prefer != "smallest" && o.Prefer != "largest" {
+				err = fmt.Errorf("unknown prefer: %s", value)
+			}
+		case "on-missing-format":
+			err = needValue()
+			o.OnMissingFormat = strings.ToLower(value)
+			switch o.OnMissingFormat {
+			case missingFallback, missingSkip:
+			case missingAsk:
+				if !isTerminal(os.Stdin) {
+					err = fmt.Errorf("on-missing-format=ask needs a terminal to ask on, use fallback or skip")
+				}
+			default:
+				err = fmt.Errorf("unknown on-missing-format: %s", value)
+			}
+		case "track-name":
+			if err = needValue(); err == nil {
+				err = checkTemplate(value)
+				o.TrackName = value
+			}
+		case "art-dir":
+			err = needValue()
+			o.ArtDir = sanitizeFilename(value)
+		case "min-duration", "max-duration":
+			if err = needValue(); err == nil {
+				var seconds int
+				seconds, err = parseTrackDuration(value)
+				if key == "min-duration" {
+					o.MinDuration = seconds
+				} else {
+					o.MaxDuration = seconds
+				}
+			}
+		case "images":
+			err = flag(&o.Images, true)
+		case "no-images":
+			err = flag(&o.Images, false)
+		case "flat":
+			err = flag(&o.Flat, true)
+		case "art-archive":
+			err = flag(&o.ArtArchive, true)
+		case "tags":
+			err = flag(&o.Tags, true)
+		case "write-metadata":
+			err = flag(&o.WriteMetadata, true)
+		case "update":
+			err = flag(&o.Update, true)
+		default:
+			err = fmt.Errorf("unknown album option %s, expected format, tracks, dir, prefer, on-missing-format, track-name, art-dir, min-duration, max-duration, images, no-images, flat, art-archive, tags, write-metadata or update", key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &o, nil
+}
+
+// splitQuoted splits at spaces outside of double quotes, so values can
+// contain spaces: dir="Bonus Discs".
+func splitQuoted(s string) ([]string, error) {
+	var fields []string
+	var b strings.Builder
+	quoted, inField := false, false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inField = true
+		case r == ' ' || r == '\t':
+			if quoted {
+				b.WriteRune(r)
+			} else if inField {
+				fields = append(fields, b.String())
+				b.Reset()
+				inField = false
+			}
+		default:
+			b.WriteRune(r)
+			inField = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unclosed quote in %s", s)
+	}
+	if inField {
+		fields = append(fields, b.String())
+	}
+	return fields, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitBatchLine(t *testing.T) {
+	tests := []struct {
+		line, url, overrides string
+	}{
+		{"https://example.com/album/a", "https://example.com/album/a", ""},
+		{"  https://example.com/album/a  |  format=mp3 tracks=1-3 ", "https://example.com/album/a", "format=mp3 tracks=1-3"},
+		{"https://example.com/album/a|dir=\"A | B\"", "https://example.com/album/a", "dir=\"A | B\""},
+	}
+	for _, tt := range tests {
+		url, overrides := splitBatchLine(tt.line)
+		if url != tt.url || overrides != tt.overrides {
+			t.Errorf("splitBatchLine(%q) = %q, %q, want %q, %q", tt.line, url, overrides, tt.url, tt.overrides)
+		}
+	}
+}
+
+func TestWithAlbumOverrides(t *testing.T) {
+	base := Options{Format: "flac", OutputDir: "out", Images: true, OnMissingFormat: missingFallback}
+	tests := []struct {
+		overrides string
+		check     func(*Options) bool
+		err       string // part of the error, "" for none
+	}{
+		{"", func(o *Options) bool { return *o == base }, ""},
+		{"format=MP3", func(o *Options) bool { return o.Format == "mp3" }, ""},
+		{"--format=ogg", func(o *Options) bool { return o.Format == "ogg" }, ""},
+		{"format=mp4", nil, "unknown format: mp4"},
+		{"format=", nil, "format needs a value"},
+		{"tracks=1-3,5", func(o *Options) bool { return o.Tracks == "1-3,5" }, ""},
+		{"tracks=x", nil, "x"},
+		{"dir=Extras", func(o *Options) bool { return o.OutputDir == filepath.Join("out", "Extras") }, ""},
+		{`dir="Bonus Discs"`, func(o *Options) bool { return o.OutputDir == filepath.Join("out", "Bonus Discs") }, ""},
+		{"dir=/music/x", func(o *Options) bool { return o.OutputDir == "/music/x" }, ""},
+		{"prefer=largest", func(o *Options) bool { return o.Prefer == "largest" }, ""},
+		{"prefer=biggest", nil, "unknown prefer"},
+		{"on-missing-format=skip", func(o *Options) bool { return o.OnMissingFormat == missingSkip }, ""},
+		{"on-missing-format=never", nil, "unknown on-missing-format"},
+		{"on-missing-format=ask", nil, "needs a terminal"},
+		{"min-duration=0:10 max-duration=30m", func(o *Options) bool { return o.MinDuration == 10 && o.MaxDuration == 1800 }, ""},
+		{"no-images", func(o *Options) bool { return !o.Images }, ""},
+		{"images=false", func(o *Options) bool { return !o.Images }, ""},
+		{"flat tags=true update", func(o *Options) bool { return o.Flat && o.Tags && o.Update }, ""},
+		{"tags=yes", nil, "tags takes true or false"},
+		{"art-dir=Scans/x", func(o *Options) bool { return o.ArtDir == sanitizeFilename("Scans/x") }, ""},
+		{"connections=4", nil, "unknown album option connections"},
+		{`dir="Bonus`, nil, "unclosed quote"},
+	}
+
+	// ask needs a terminal, which a pipe isn't
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+
+	for _, tt := range tests {
+		opts := base
+		got, err := withAlbumOverrides(&opts, tt.overrides)
+		switch {
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("withAlbumOverrides(%q) error = %v, want one with %q", tt.overrides, err, tt.err)
+		case tt.err == "" && err != nil:
+			t.Errorf("withAlbumOverrides(%q) error = %v", tt.overrides, err)
+		case tt.err == "" && !tt.check(got):
+			t.Errorf("withAlbumOverrides(%q) = %+v", tt.overrides, *got)
+		}
+		if opts != base {
+			t.Errorf("withAlbumOverrides(%q) changed the options it was given", tt.overrides)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// importBrowserCookies copies the cookies a browser has for the supported
+// sites into the cookie jar, e.g. the clearance cookie of an anti-bot
+// challenge solved in the browser. The spec is "firefox" for the most
+// recently used profile or "firefox:<profile folder>".
+//
+// Firefox keeps its cookies in SQLite, which is read with the sqlite3 tool.
+// Chrome and its relatives encrypt theirs; export them to cookies.txt with
+// a browser extension instead.
+func importBrowserCookies(spec string) error {
+	browser, profile, _ := strings.Cut(spec, ":")
+	if !strings.EqualFold(browser, "firefox") {
+		return fmt.Errorf("can't read cookies from %s, only from firefox; export them to a cookies.txt file for --cookies instead", browser)
+	}
+	if profile == "" {
+		var err error
+		if profile, err = firefoxProfile(); err != nil {
+			return err
+		}
+	}
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		return fmt.Errorf("reading Firefox cookies needs the sqlite3 tool")
+	}
+
+	// Firefox keeps the database locked while it runs
+	data, err := os.ReadFile(filepath.Join(profile, "cookies.sqlite"))
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp("", "cookies-*.sqlite")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	var hosts []string
+	for _, p := range providers {
+		for _, domain := range p.Hosts() {
+			hosts = append(hosts, fmt.Sprintf("host = '%[1]s' OR host LIKE '%%.%[1]s'", domain))
+		}
+	}
+	query := "SELECT host, path, isSecure, expiry, name, value FROM moz_cookies WHERE " + strings.Join(hosts, " OR ")
+	out, err := exec.Command("sqlite3", "-separator", "\t", tmp.Name(), query).Output()
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", filepath.Join(profile, "cookies.sqlite"), err)
+	}
+
+	jar := cookieJar()
+	count := 0
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 6)
+		if len(fields) < 6 {
+			continue
+		}
+		host, path, secure, expiry, name, value := fields[0], fields[1], fields[2], fields[3], fields[4], fields[5]
+		c := &http.Cookie{Name: name, Value: value, Path: path, Secure: secure == "1"}
+		if seconds, err := strconv.ParseInt(expiry, 10, 64); err == nil && seconds > 0 {
+			// Newer versions store milliseconds
+			if seconds > 1e11 {
+				seconds /= 1000
+			}
+			c.Expires = time.Unix(seconds, 0)
+		}
+		// A leading dot marks a cookie for the subdomains too
+		if strings.HasPrefix(host, ".") {
+			c.Domain = host
+		}
+		jar.SetCookies(&url.URL{Scheme: "https", Host: strings.TrimPrefix(host, ".")}, []*http.Cookie{c})
+		count++
+	}
+	logf("Imported %d cookie(s) from Firefox\n", count)
+	return nil
+}
+
+// firefoxProfile returns the profile folder whose cookies changed last.
+func firefoxProfile() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	var roots []string
+	switch runtime.GOOS {
+	case "windows":
+		roots = []string{filepath.Join(os.Getenv("APPDATA"), "Mozilla", "Firefox", "Profiles")}
+	case "darwin":
+		roots = []string{filepath.Join(home, "Library", "Application Support", "Firefox", "Profiles")}
+	default:
+		roots = []string{
+			filepath.Join(home, ".mozilla", "firefox"),
+			filepath.Join(home, "snap", "firefox", "common", ".mozilla", "firefox"),
+			filepath.Join(home, ".var", "app", "org.mozilla.firefox", ".mozilla", "firefox"),
+		}
+	}
+
+	var newest string
+	var newestTime time.Time
+	for _, root := range roots {
+		matches, _ := filepath.Glob(filepath.Join(root, "*", "cookies.sqlite"))
+		for _, m := range matches {
+			if info, err := os.Stat(m); err == nil && info.ModTime().After(newestTime) {
+				newest, newestTime = filepath.Dir(m), info.ModTime()
+			}
+		}
+	}
+	if newest == "" {
+		return "", fmt.Errorf("no Firefox profile found, give its folder as firefox:<folder>")
+	}
+	return newest, nil
+}
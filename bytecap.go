@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// byteCaps stops the run once --max-bytes were downloaded in it, or the
+// --monthly-bytes budget is used up, for metered connections. The month's
+// usage is kept in usage.json in the state directory, so it adds up over
+// runs. The file being downloaded when a cap is reached still finishes, and
+// a later run resumes the album where this one stopped. The server counts
+// --max-bytes per queue item.
+type byteCaps struct {
+	run     int64 // --max-bytes, 0 for no cap
+	monthly int64 // --monthly-bytes
+
+	mu      sync.Mutex
+	runUsed int64
+}
+
+var caps = &byteCaps{}
+
+// monthlyUsage is the content of usage.json.
+type monthlyUsage struct {
+	Month string `json:"month"` // e.g. 2026-10
+	Bytes int64  `json:"bytes"`
+}
+
+func usagePath() string {
+	return statePath("usage.json", "")
+}
+
+func currentMonth() string {
+	return time.Now().Format("2006-01")
+}
+
+func loadUsage(path string) monthlyUsage {
+	usage := monthlyUsage{Month: currentMonth()}
+	var saved monthlyUsage
+	if data, err := os.ReadFile(path); err == nil && json.Unmarshal(data, &saved) == nil && saved.Month == usage.Month {
+		usage = saved
+	}
+	return usage
+}
+
+// add counts downloaded bytes against the caps.
+func (c *byteCaps) add(n int64) {
+	if n <= 0 || (c.run == 0 && c.monthly == 0) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.runUsed += n
+
+	if c.monthly > 0 {
+		// Read again, another run may be downloading at the same time
+		path := usagePath()
+		usage := loadUsage(path)
+		usage.Bytes += n
+		if err := saveUsage(path, usage); err != nil {
+			logf("Error updating %s: %v\n", path, err)
+		}
+		if usage.Bytes >= c.monthly {
+			policy.stop(fmt.Sprintf("using up the monthly budget of %s", formatBytes(c.monthly)), true)
+		}
+	}
+	if c.run > 0 && c.runUsed >= c.run {
+		policy.stop(fmt.Sprintf("downloading the --max-bytes cap of %s", formatBytes(c.run)), true)
+	}
+}
+
+// reset starts counting --max-bytes anew, for the next queue item.
+func (c *byteCaps) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.runUsed = 0
+}
+
+// check stops the run before it starts another album when the month's
+// budget was used up already, e.g. by an earlier run.
+func (c *byteCaps) check() {
+	if c.monthly == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if usage := loadUsage(usagePath()); usage.Bytes >= c.monthly {
+		policy.stop(fmt.Sprintf("using up the monthly budget of %s (%s downloaded in %s)", formatBytes(c.monthly), formatBytes(usage.Bytes), usage.Month), true)
+	}
+}
+
+func saveUsage(path string, usage monthlyUsage) error {
+	data, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
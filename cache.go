@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// pageCache stores fetched album and song pages on disk so repeated runs
+// don't have to load them again. Entries older than ttl are revalidated
+// with the server using ETag/Last-Modified.
+type pageCache struct {
+	dir     string
+	ttl     time.Duration
+	enabled bool
+}
+
+type cacheEntry struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Fetched      time.Time `json:"fetched"`
+}
+
+// htmlCache is set up once the options are parsed, so it is in --cache-dir;
+// until then nothing is cached.
+var htmlCache = &pageCache{}
+
+// newPageCache returns the cache in the cache directory, disabled without
+// one.
+func newPageCache(ttl time.Duration, enabled bool) *pageCache {
+	c := &pageCache{ttl: ttl, enabled: enabled}
+	if dir := cacheDir(); dir != "" {
+		c.dir = filepath.Join(dir, "http")
+	} else {
+		c.enabled = false
+	}
+	return c
+}
+
+// Only album and song pages are cached, anything else (login, favorites,
+// search) has to be fresh.
+func isCacheablePage(pageURL string) bool {
+	return strings.Contains(pageURL, "/game-soundtracks/album/")
+}
+
+func (c *pageCache) paths(pageURL string) (string, string) {
+	sum := sha256.Sum256([]byte(pageURL))
+	key := hex.EncodeToString(sum[:])
+	return filepath.Join(c.dir, key+".json"), filepath.Join(c.dir, key+".html")
+}
+
+func (c *pageCache) load(pageURL string) (*cacheEntry, []byte) {
+	if !c.enabled || !isCacheablePage(pageURL) {
+		return nil, nil
+	}
+
+	metaPath, bodyPath := c.paths(pageURL)
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		return nil, nil
+	}
+
+	var entry cacheEntry
+	if json.Unmarshal(data, &entry) != nil || entry.URL != pageURL {
+		return nil, nil
+	}
+
+	body, err := os.ReadFile(bodyPath)
+	if err != nil {
+		return nil, nil
+	}
+	return &entry, body
+}
+
+func (e *cacheEntry) fresh(ttl time.Duration) bool {
+	return time.Since(e.Fetched) < ttl
+}
+
+// addValidators makes the request conditional on the cached copy.
+func (e *cacheEntry) addValidators(req *http.Request) {
+	if e.ETag != "" {
+		req.Header.Set("If-None-Match", e.ETag)
+	}
+	if e.LastModified != "" {
+		req.Header.Set("If-Modified-Since", e.LastModified)
+	}
+}
+
+func (c *pageCache) store(pageURL string, header http.Header, body []byte) {
+	if !c.enabled || !isCacheablePage(pageURL) {
+		return
+	}
+
+	entry := cacheEntry{
+		URL:          pageURL,
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+		Fetched:      time.Now(),
+	}
+	c.write(&entry, body)
+}
+
+// touch marks a cached page as fresh again after a 304 response.
+func (c *pageCache) touch(entry *cacheEntry) {
+	entry.Fetched = time.Now()
+	c.write(entry, nil)
+}
+
+func (c *pageCache) write(entry *cacheEntry, body []byte) {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return
+	}
+
+	metaPath, bodyPath := c.paths(entry.URL)
+	if body != nil {
+		if err := os.WriteFile(bodyPath, body, 0644); err != nil {
+			return
+		}
+	}
+	if data, err := json.Marshal(entry); err == nil {
+		os.WriteFile(metaPath, data, 0644)
+	}
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const catalogStartURL = "https://downloads.khinsider.com/game-soundtracks/browse/A"
+
+// catalogAlbum is an album from khinsider's alphabetical index.
+type catalogAlbum struct {
+	Name      string   `json:"name"`
+	Slug      string   `json:"slug"`
+	Platforms []string `json:"platforms,omitempty"`
+	Type      string   `json:"type,omitempty"`
+	Year      string   `json:"year,omitempty"`
+}
+
+func (a catalogAlbum) URL() string {
+	return albumURLPrefix + a.Slug
+}
+
+// catalog is the local copy of the album index, so albums can be looked up
+// without a request to the site.
+type catalog struct {
+	Built  time.Time      `json:"built"`
+	Albums []catalogAlbum `json:"albums"`
+}
+
+func catalogPath() string {
+	dir := cacheDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "catalog.json")
+}
+
+func loadCatalog() (*catalog, error) {
+	path := catalogPath()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no album index yet, run 'khinsider_downloader index build' first")
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c catalog
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	return &c, nil
+}
+
+func (c *catalog) save() error {
+	path := catalogPath()
+	if path == "" {
+		return fmt.Errorf("no cache directory for the album index")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// buildCatalog walks the index pages of every letter, following the links
+// to the other letters and pages it finds.
+func buildCatalog() (*catalog, error) {
+	c := &catalog{}
+	seen := make(map[string]bool)
+	queued := map[string]bool{catalogStartURL: true}
+	pending := []string{catalogStartURL}
+
+	for len(pending) > 0 {
+		pageURL := pending[0]
+		pending = pending[1:]
+
+		doc, err := fetchHTML(pageURL)
+		if err != nil {
+			return nil, fmt.Errorf("loading %s: %v", pageURL, err)
+		}
+		albums, links := parseBrowsePage(doc, pageURL)
+
+		added := 0
+		for _, album := range albums {
+			if !seen[album.Slug] {
+				seen[album.Slug] = true
+				c.Albums = append(c.Albums, album)
+				added++
+			}
+		}
+		logf("  %s: %d albums\n", pageURL, added)
+
+		for _, link := range links {
+			if !queued[link] {
+				queued[link] = true
+				pending = append(pending, link)
+			}
+		}
+	}
+
+	sort.Slice(c.Albums, func(i, j int) bool {
+		return strings.ToLower(c.Albums[i].Name) < strings.ToLower(c.Albums[j].Name)
+	})
+	c.Built = time.Now()
+	return c, nil
+}
+
+// parseBrowsePage returns the albums in an index page's table and the links
+// to other index pages.
+func parseBrowsePage(doc *document, pageURL string) ([]catalogAlbum, []string) {
+	base, _ := url.Parse(pageURL)
+	resolve := func(href string) *url.URL {
+		ref, err := url.Parse(href)
+		if err != nil {
+			return nil
+		}
+		return base.ResolveReference(ref)
+	}
+
+	// Columns are found by their header, the table has changed before
+	columns := map[string]int{}
+	doc.Find(selectors().BrowseRows).First().Find("th").Each(func(i int, th *selection) {
+		columns[strings.ToLower(strings.TrimSpace(th.Text()))] = i
+	})
+	cell := func(cells *selection, name string) *selection {
+		if i, ok := columns[name]; ok {
+			return cells.Eq(i)
+		}
+		return cells.Slice(0, 0)
+	}
+
+	var albums []catalogAlbum
+	doc.Find(selectors().BrowseRows).Each(func(i int, row *selection) {
+		link := row.Find("a[href*='/game-soundtracks/album/']").First()
+		href, ok := link.Attr("href")
+		if !ok {
+			return
+		}
+		u := resolve(href)
+		if u == nil {
+			return
+		}
+		slug := strings.TrimPrefix(u.Path, "/game-soundtracks/album/")
+		name := strings.TrimSpace(link.Text())
+		if slug == "" || strings.Contains(slug, "/") || name == "" {
+			return
+		}
+
+		album := catalogAlbum{Name: name, Slug: slug}
+		cells := row.Find("td")
+		cell(cells, "platform").Find("a").Each(func(i int, a *selection) {
+			if p := strings.TrimSpace(a.Text()); p != "" {
+				album.Platforms = append(album.Platforms, p)
+			}
+		})
+		album.Type = strings.TrimSpace(cell(cells, "type").Text())
+		album.Year = strings.TrimSpace(cell(cells, "year").Text())
+		albums = append(albums, album)
+	})
+
+	var links []string
+	doc.Find(selectors().BrowseLinks).Each(func(i int, a *selection) {
+		href, _ := a.Attr("href")
+		if u := resolve(href); u != nil && u.Host == base.Host {
+			u.Fragment = ""
+			links = append(links, u.String())
+		}
+	})
+	return albums, links
+}
+
+// normalizeForSearch lowercases a name and strips accents and punctuation,
+// so "Pokémon: Red" matches "pokemon red".
+func normalizeForSearch(s string) string {
+	s = strings.ToLower(toASCII(s))
+	return strings.Join(strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+// matchScore rates how well a name matches the query words, from 0 (a word
+// didn't match) up to 1 per word. Whole words score highest, then word
+// prefixes, substrings, abbreviations like "oot" for "Ocarina of Time" and
+// finally words that are only similar, to forgive typos.
+func matchScore(name string, query []string) float64 {
+	words := strings.Fields(name)
+	initials := ""
+	for _, w := range words {
+		initials += w[:1]
+	}
+
+	score := 0.0
+	for _, q := range query {
+		best := 0.0
+		for _, w := range words {
+			switch {
+			case w == q:
+				best = max(best, 1)
+			case strings.HasPrefix(w, q):
+				best = max(best, 0.8)
+			case strings.Contains(w, q):
+				best = max(best, 0.6)
+			case len(q) >= 4 && editDistance(w, q) <= 1+len(q)/8:
+				best = max(best, 0.4)
+			default:
+				if sim := trigramSimilarity(w, q); sim >= 0.4 {
+					best = max(best, sim*0.5)
+				}
+			}
+		}
+		if best < 0.5 && len(q) > 1 && strings.Contains(initials, q) {
+			best = 0.5
+		}
+		if best == 0 {
+			return 0
+		}
+		score += best
+	}
+	// Among equal matches, names without much else in them come first
+	return score - float64(len(words))*0.01
+}
+
+// trigramSimilarity is the share of three-letter sequences two words have
+// in common, padded so the word boundaries count.
+func trigramSimilarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+	common := 0
+	for t := range ta {
+		if tb[t] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(ta)+len(tb)-common)
+}
+
+func trigrams(word string) map[string]bool {
+	padded := "  " + word + " "
+	set := make(map[string]bool)
+	for i := 0; i+3 <= len(padded); i++ {
+		set[padded[i:i+3]] = true
+	}
+	return set
+}
+
+// editDistance counts the insertions, deletions, substitutions and swaps of
+// neighbouring letters that turn a into b.
+func editDistance(a, b string) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+// catalogFilter narrows results down to a platform and a range of years.
+type catalogFilter struct {
+	platform         string
+	fromYear, toYear int
+}
+
+// parseYears reads a year ("1998") or a range ("1990-1999").
+func (f *catalogFilter) parseYears(s string) error {
+	from, to, isRange := strings.Cut(s, "-")
+	var err error
+	if f.fromYear, err = strconv.Atoi(strings.TrimSpace(from)); err != nil {
+		return fmt.Errorf("invalid year: %s", s)
+	}
+	f.toYear = f.fromYear
+	if isRange {
+		if f.toYear, err = strconv.Atoi(strings.TrimSpace(to)); err != nil || f.toYear < f.fromYear {
+			return fmt.Errorf("invalid year range: %s", s)
+		}
+	}
+	return nil
+}
+
+func (f catalogFilter) active() bool {
+	return f.platform != "" || f.fromYear != 0
+}
+
+func (f catalogFilter) match(a catalogAlbum) bool {
+	if f.platform != "" {
+		want := normalizeForSearch(f.platform)
+		found := false
+		for _, p := range a.Platforms {
+			if strings.Contains(normalizeForSearch(p), want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.fromYear != 0 {
+		year, err := strconv.Atoi(a.Year)
+		if err != nil || year < f.fromYear || year > f.toYear {
+			return false
+		}
+	}
+	return true
+}
+
+// search returns the albums matching the query and filter, best first.
+func (c *catalog) search(query string, filter catalogFilter) []catalogAlbum {
+	words := strings.Fields(normalizeForSearch(query))
+	if len(words) == 0 {
+		return nil
+	}
+
+	type match struct {
+		album catalogAlbum
+		score float64
+	}
+	var matches []match
+	for _, album := range c.Albums {
+		if !filter.match(album) {
+			continue
+		}
+		if score := matchScore(normalizeForSearch(album.Name), words); score > 0 {
+			matches = append(matches, match{album, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+
+	results := make([]catalogAlbum, len(matches))
+	for i, m := range matches {
+		results[i] = m.album
+	}
+	return results
+}
+
+// stale reports whether the index is too old to be trusted to have new
+// albums.
+func (c *catalog) stale() bool {
+	return time.Since(c.Built) > catalogMaxAge
+}
+
+const catalogMaxAge = 30 * 24 * time.Hour
+
+func printCatalogAlbums(albums []catalogAlbum) {
+	for _, a := range albums {
+		details := a.Year
+		if len(a.Platforms) > 0 {
+			details = strings.TrimSpace(strings.Join(a.Platforms, ", ") + " " + details)
+		}
+		if details != "" {
+			details = " (" + details + ")"
+		}
+		fmt.Printf("%s%s\n  %s\n", a.Name, details, a.URL())
+	}
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func runIndex(args []string) error {
+	usage := fmt.Errorf("usage: khinsider_downloader index build | index search [--platform <name>] [--year <year[-year]>] [--json] <query>")
+	if len(args) == 0 {
+		return usage
+	}
+
+	switch args[0] {
+	case "build":
+		// For the request options like --polite and --user-agent
+		if _, _, err := parseOptions(args[1:]); err != nil {
+			return err
+		}
+		logf("Building the album index from %s\n", catalogStartURL)
+		c, err := buildCatalog()
+		if err != nil {
+			return err
+		}
+		if err := c.save(); err != nil {
+			return err
+		}
+		logf("Indexed %d albums in %s\n", len(c.Albums), catalogPath())
+		return nil
+
+	case "search":
+		search, err := parseSearchArgs(args[1:])
+		if err != nil {
+			return err
+		}
+		c, err := loadCatalog()
+		if err != nil {
+			return err
+		}
+		return search.printOffline(c)
+	}
+	return usage
+}
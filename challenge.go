@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Markers of the anti-bot pages of Cloudflare and DDoS-Guard, which answer
+// with 403 or 503 until a browser solved a JavaScript challenge.
+var challengeMarkers = [][]byte{
+	[]byte("challenge-platform"),
+	[]byte("cf-chl-"),
+	[]byte("<title>Just a moment...</title>"),
+	[]byte("Attention Required! | Cloudflare"),
+	[]byte("ddos-guard"),
+}
+
+type challengeError struct {
+	Host       string
+	StatusCode int
+}
+
+func (e *challengeError) Error() string {
+	return fmt.Sprintf("%s answered with an anti-bot challenge (status code %d) instead of the page. "+
+		"Open the site in a browser, then import its cookies with --cookies-from-browser firefox "+
+		"(or --cookies with an exported cookies.txt) and send the browser's --user-agent", e.Host, e.StatusCode)
+}
+
+func (e *challengeError) Is(target error) bool { return target == errChallenge }
+
+// checkChallenge returns a challengeError if a failed response is an
+// anti-bot challenge, so it isn't reported as just a status code.
+func checkChallenge(resp *http.Response) error {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusServiceUnavailable {
+		return nil
+	}
+	challenge := &challengeError{Host: resp.Request.URL.Host, StatusCode: resp.StatusCode}
+	if resp.Header.Get("Cf-Mitigated") == "challenge" {
+		return challenge
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	for _, marker := range challengeMarkers {
+		if bytes.Contains(body, marker) {
+			return challenge
+		}
+	}
+	return nil
+}
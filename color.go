@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// Status colors as SGR codes, overridable with
+// KHINSIDER_COLORS="done=32:fallback=33:failed=31:skipped=36".
+var colorTheme = map[string]string{
+	"done":     "32",
+	"fallback": "33",
+	"failed":   "31",
+	"skipped":  "36",
+}
+
+var noColor = false
+
+func init() {
+	for _, entry := range strings.Split(os.Getenv("KHINSIDER_COLORS"), ":") {
+		if name, code, ok := strings.Cut(entry, "="); ok {
+			colorTheme[strings.TrimSpace(name)] = strings.TrimSpace(code)
+		}
+	}
+}
+
+// useColor reports whether output should be colored: not when disabled,
+// not with NO_COLOR set and not when the output isn't a terminal.
+func useColor() bool {
+	if noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	f, ok := logOutput.(*os.File)
+	return ok && isTerminal(f)
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorize wraps s in the theme color for the given status.
+func colorize(status, s string) string {
+	code := colorTheme[status]
+	if code == "" || !useColor() {
+		return s
+	}
+	return "\033[" + code + "m" + s + "\033[0m"
+}
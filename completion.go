@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const programName = "khinsider_downloader"
+
+func runCompletion(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s completion bash|zsh|fish|powershell", programName)
+	}
+
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion())
+	case "zsh":
+		fmt.Print(zshCompletion())
+	case "fish":
+		fmt.Print(fishCompletion())
+	case "powershell":
+		fmt.Print(powershellCompletion())
+	default:
+		return fmt.Errorf("unsupported shell: %s", args[0])
+	}
+	return nil
+}
+
+// completionOptions returns the flags accepted after the given subcommand
+// ("" for album downloads).
+func completionOptions(command string) []optionHelp {
+	for _, cmd := range commands {
+		if cmd.Name != command {
+			continue
+		}
+		switch command {
+		case "serve", "favorites", "info", "random":
+			return append(append([]optionHelp{}, cmd.Options...), downloadOptions...)
+		}
+		return cmd.Options
+	}
+	return downloadOptions
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for _, cmd := range commands {
+		names = append(names, cmd.Name)
+	}
+	return names
+}
+
+func flagNames(options []optionHelp) string {
+	names := make([]string, 0, len(options))
+	for _, o := range options {
+		names = append(names, o.Flag)
+	}
+	return strings.Join(names, " ")
+}
+
+func bashCompletion() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# bash completion for %s\n", programName)
+	fmt.Fprintf(&b, "_%s() {\n", programName)
+	b.WriteString("    local cur prev cmd\n")
+	b.WriteString("    cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	b.WriteString("    prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	b.WriteString("    cmd=\"${COMP_WORDS[1]}\"\n\n")
+
+	// Flag arguments
+	b.WriteString("    case \"$prev\" in\n")
+	seen := make(map[string]bool)
+	for _, cmd := range append([]string{""}, commandNames()...) {
+		for _, o := range completionOptions(cmd) {
+			if o.Arg == "" || seen[o.Flag] {
+				continue
+			}
+			seen[o.Flag] = true
+			switch {
+			case len(o.Values) > 0:
+				fmt.Fprintf(&b, "        %s) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")); return ;;\n", o.Flag, strings.Join(o.Values, " "))
+			case o.File:
+				fmt.Fprintf(&b, "        %s) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", o.Flag)
+			default:
+				fmt.Fprintf(&b, "        %s) return ;;\n", o.Flag)
+			}
+		}
+	}
+	b.WriteString("    esac\n\n")
+
+	b.WriteString("    if [ \"$COMP_CWORD\" -eq 1 ] && [[ \"$cur\" != -* ]]; then\n")
+	fmt.Fprintf(&b, "        COMPREPLY=($(compgen -W \"%s\" -- \"$cur\"))\n", strings.Join(commandNames(), " "))
+	b.WriteString("        return\n")
+	b.WriteString("    fi\n\n")
+
+	b.WriteString("    case \"$cmd\" in\n")
+	for _, cmd := range commandNames() {
+		words := flagNames(completionOptions(cmd))
+		switch cmd {
+		case "completion":
+			words = "bash zsh fish powershell"
+		case "favorites":
+			words = "sync " + words
+		}
+		fmt.Fprintf(&b, "        %s) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")) ;;\n", cmd, words)
+	}
+	fmt.Fprintf(&b, "        *) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")) ;;\n", flagNames(downloadOptions))
+	b.WriteString("    esac\n")
+	b.WriteString("}\n")
+	fmt.Fprintf(&b, "complete -F _%s %s\n", programName, programName)
+	return b.String()
+}
+
+func zshCompletion() string {
+	spec := func(o optionHelp) string {
+		help := strings.NewReplacer("'", "'\\''", "[", "\\[", "]", "\\]").Replace(o.Help)
+		s := fmt.Sprintf("'%s[%s]", o.Flag, help)
+		switch {
+		case len(o.Values) > 0:
+			s += fmt.Sprintf(":%s:(%s)", strings.TrimPrefix(o.Flag, "--"), strings.Join(o.Values, " "))
+		case o.File:
+			s += ":file:_files"
+		case o.Arg != "":
+			s += fmt.Sprintf(":%s: ", strings.ReplaceAll(strings.Trim(o.Arg, "<>'"), ":", ""))
+		}
+		return s + "'"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#compdef %s\n\n", programName)
+	fmt.Fprintf(&b, "_%s() {\n", programName)
+	b.WriteString("    if (( CURRENT == 2 )) && [[ $words[2] != -* ]]; then\n")
+	b.WriteString("        local -a commands\n")
+	b.WriteString("        commands=(\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(&b, "            '%s:%s'\n", cmd.Name, strings.ReplaceAll(cmd.Help, "'", "'\\''"))
+	}
+	b.WriteString("        )\n")
+	b.WriteString("        _describe 'command' commands\n")
+	b.WriteString("        _urls\n")
+	b.WriteString("        return\n")
+	b.WriteString("    fi\n\n")
+
+	b.WriteString("    case $words[2] in\n")
+	for _, cmd := range commandNames() {
+		fmt.Fprintf(&b, "        %s)\n", cmd)
+		switch cmd {
+		case "completion":
+			b.WriteString("            _arguments '2:shell:(bash zsh fish powershell)'\n")
+		default:
+			b.WriteString("            _arguments \\\n")
+			if cmd == "favorites" {
+				b.WriteString("                '2:action:(sync)' \\\n")
+			}
+			for _, o := range completionOptions(cmd) {
+				fmt.Fprintf(&b, "                %s \\\n", spec(o))
+			}
+			b.WriteString("                && return\n")
+		}
+		b.WriteString("            ;;\n")
+	}
+	b.WriteString("        *)\n")
+	b.WriteString("            _arguments \\\n")
+	for _, o := range downloadOptions {
+		fmt.Fprintf(&b, "                %s \\\n", spec(o))
+	}
+	b.WriteString("                '*:album url:_urls'\n")
+	b.WriteString("            ;;\n")
+	b.WriteString("    esac\n")
+	b.WriteString("}\n\n")
+	fmt.Fprintf(&b, "compdef _%s %s\n", programName, programName)
+	return b.String()
+}
+
+func fishCompletion() string {
+	quote := func(s string) string {
+		return "'" + strings.NewReplacer("\\", "\\\\", "'", "\\'").Replace(s) + "'"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# fish completion for %s\n", programName)
+	fmt.Fprintf(&b, "complete -c %s -f\n", programName)
+	for _, cmd := range commands {
+		fmt.Fprintf(&b, "complete -c %s -n '__fish_use_subcommand' -a %s -d %s\n", programName, cmd.Name, quote(cmd.Help))
+	}
+	fmt.Fprintf(&b, "complete -c %s -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish powershell'\n", programName)
+	fmt.Fprintf(&b, "complete -c %s -n '__fish_seen_subcommand_from favorites' -a sync\n", programName)
+
+	write := func(condition string, o optionHelp) {
+		fmt.Fprintf(&b, "complete -c %s -n %s -l %s -d %s", programName, quote(condition), strings.TrimPrefix(o.Flag, "--"), quote(o.Help))
+		switch {
+		case len(o.Values) > 0:
+			fmt.Fprintf(&b, " -x -a %s", quote(strings.Join(o.Values, " ")))
+		case o.File:
+			b.WriteString(" -r -F")
+		case o.Arg != "":
+			b.WriteString(" -x")
+		}
+		b.WriteString("\n")
+	}
+
+	downloadCondition := "not __fish_seen_subcommand_from login completion"
+	for _, o := range downloadOptions {
+		write(downloadCondition, o)
+	}
+	for _, cmd := range commands {
+		for _, o := range cmd.Options {
+			write("__fish_seen_subcommand_from "+cmd.Name, o)
+		}
+	}
+	return b.String()
+}
+
+func powershellCompletion() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# PowerShell completion for %s\n", programName)
+	fmt.Fprintf(&b, "Register-ArgumentCompleter -Native -CommandName '%s', '%s.exe' -ScriptBlock {\n", programName, programName)
+	b.WriteString("    param($wordToComplete, $commandAst, $cursorPosition)\n\n")
+	b.WriteString("    $words = @($commandAst.CommandElements | ForEach-Object { $_.ToString() })\n")
+	b.WriteString("    if ($wordToComplete -ne '') { $words = @($words | Select-Object -SkipLast 1) }\n")
+	b.WriteString("    $command = if ($words.Count -gt 1) { $words[1] } else { '' }\n")
+	b.WriteString("    $prev = if ($words.Count -gt 0) { $words[-1] } else { '' }\n\n")
+
+	b.WriteString("    $values = @{\n")
+	for _, o := range downloadOptions {
+		if len(o.Values) > 0 {
+			fmt.Fprintf(&b, "        '%s' = @('%s')\n", o.Flag, strings.Join(o.Values, "', '"))
+		}
+	}
+	b.WriteString("    }\n")
+	b.WriteString("    $flags = @{\n")
+	fmt.Fprintf(&b, "        '' = @('%s')\n", strings.ReplaceAll(flagNames(downloadOptions), " ", "', '"))
+	for _, cmd := range commandNames() {
+		if len(completionOptions(cmd)) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "        '%s' = @('%s')\n", cmd, strings.ReplaceAll(flagNames(completionOptions(cmd)), " ", "', '"))
+	}
+	b.WriteString("    }\n\n")
+
+	b.WriteString("    $candidates = if ($values.ContainsKey($prev)) {\n")
+	b.WriteString("        $values[$prev]\n")
+	b.WriteString("    } elseif ($words.Count -eq 1) {\n")
+	fmt.Fprintf(&b, "        @('%s') + $flags['']\n", strings.Join(commandNames(), "', '"))
+	b.WriteString("    } elseif ($command -eq 'completion') {\n")
+	b.WriteString("        @('bash', 'zsh', 'fish', 'powershell')\n")
+	b.WriteString("    } elseif ($flags.ContainsKey($command)) {\n")
+	b.WriteString("        $flags[$command]\n")
+	b.WriteString("    } else {\n")
+	b.WriteString("        $flags['']\n")
+	b.WriteString("    }\n\n")
+	b.WriteString("    $candidates | Where-Object { $_ -like \"$wordToComplete*\" } | ForEach-Object {\n")
+	b.WriteString("        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)\n")
+	b.WriteString("    }\n")
+	b.WriteString("}\n")
+	return b.String()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Config holds settings that don't fit on the command line, read from
+// config.json in the user's config directory or the file given with
+// --config.
+type Config struct {
+	SMTP  smtpConfig   `json:"smtp"`
+	Users []serverUser `json:"users"` // server mode users
+
+	Selectors json.RawMessage    `json:"selectors,omitempty"` // overrides of selectors.json
+	Profiles  map[string]profile `json:"profiles,omitempty"`  // for --profile
+}
+
+type smtpConfig struct {
+	Host     string   `json:"host"`
+	Port     int      `json:"port"` // 587 (STARTTLS) by default, 465 for TLS
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+// configFile is set by --config.
+var configFile string
+
+var (
+	configMu     sync.Mutex
+	loadedConfig *Config
+	configErr    error
+)
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, appDirName, "config.json")
+}
+
+// appConfig returns the configuration, an empty one if there is no file.
+func appConfig() (*Config, error) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	if loadedConfig == nil {
+		loadedConfig, configErr = readConfig()
+	}
+	return loadedConfig, configErr
+}
+
+// reloadConfig reads the config file again, for the server on SIGHUP. The
+// old configuration stays in use if the file has errors.
+func reloadConfig() (*Config, error) {
+	config, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	configMu.Lock()
+	loadedConfig, configErr = config, nil
+	configMu.Unlock()
+	return config, nil
+}
+
+func readConfig() (*Config, error) {
+	config := &Config{}
+	if err := config.load(); err != nil {
+		return config, err
+	}
+	if err := config.applyEnv(); err != nil {
+		return config, err
+	}
+	return config, config.resolveSecrets()
+}
+
+// load reads the config file, if there is one.
+func (c *Config) load() error {
+	path := configFile
+	if path == "" {
+		path = defaultConfigPath()
+	}
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && configFile == "" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("parsing %s: %v", path, err)
+	}
+	return nil
+}
+
+// resolveSecrets reads the passwords and tokens given as keyring:<name>
+// from the keyring.
+func (c *Config) resolveSecrets() error {
+	var err error
+	if c.SMTP.Password, err = resolveSecret(c.SMTP.Password); err != nil {
+		return err
+	}
+	for i := range c.Users {
+		if c.Users[i].Token, err = resolveSecret(c.Users[i].Token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
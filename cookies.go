@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type storedCookie struct {
+	Name     string    `json:"name"`
+	Value    string    `json:"value"`
+	Domain   string    `json:"domain"`
+	Path     string    `json:"path"`
+	Expires  time.Time `json:"expires,omitempty"`
+	Secure   bool      `json:"secure,omitempty"`
+	HostOnly bool      `json:"host_only,omitempty"`
+}
+
+// persistentJar is a cookie jar that is saved to disk whenever it changes,
+// so a login survives between runs.
+type persistentJar struct {
+	mu       sync.Mutex
+	path     string
+	netscape bool // the file is a cookies.txt
+	cookies  []*storedCookie
+}
+
+var (
+	sharedJar     *persistentJar
+	sharedJarOnce sync.Once
+)
+
+// cookieJar returns the on-disk jar used by all requests.
+func cookieJar() *persistentJar {
+	sharedJarOnce.Do(func() {
+		sharedJar = &persistentJar{}
+		if cookiesFile != "" {
+			sharedJar.path, sharedJar.netscape = cookiesFile, true
+			sharedJar.load()
+			return
+		}
+		oldPath := ""
+		if dir, err := os.UserConfigDir(); err == nil {
+			oldPath = filepath.Join(dir, appDirName, "cookies.json")
+		}
+		sharedJar.path = statePath("cookies.json", oldPath)
+		if sharedJar.path != "" {
+			sharedJar.load()
+		}
+	})
+	return sharedJar
+}
+
+func (j *persistentJar) load() {
+	data, err := os.ReadFile(j.path)
+	if err != nil {
+		return
+	}
+	if j.netscape {
+		cookies, err := parseNetscapeCookies(data)
+		if err != nil {
+			logf("Error reading %s: %v\n", j.path, err)
+			return
+		}
+		j.cookies = cookies
+		return
+	}
+	json.Unmarshal(data, &j.cookies)
+}
+
+func (j *persistentJar) save() error {
+	if j.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(j.path), 0700); err != nil {
+		return err
+	}
+
+	if j.netscape {
+		return os.WriteFile(j.path, formatNetscapeCookies(j.cookies), 0600)
+	}
+	data, err := json.MarshalIndent(j.cookies, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(j.path, data, 0600)
+}
+
+func (j *persistentJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	for _, c := range cookies {
+		sc := &storedCookie{
+			Name:   c.Name,
+			Value:  c.Value,
+			Domain: strings.TrimPrefix(strings.ToLower(c.Domain), "."),
+			Path:   c.Path,
+			Secure: c.Secure,
+		}
+		if sc.Domain == "" {
+			sc.Domain = strings.ToLower(u.Hostname())
+			sc.HostOnly = true
+		}
+		if sc.Path == "" || !strings.HasPrefix(sc.Path, "/") {
+			sc.Path = "/"
+		}
+
+		switch {
+		case c.MaxAge < 0:
+			sc.Expires = now.Add(-time.Second)
+		case c.MaxAge > 0:
+			sc.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		case !c.Expires.IsZero():
+			sc.Expires = c.Expires
+		}
+
+		j.remove(sc.Domain, sc.Path, sc.Name)
+		if sc.Expires.IsZero() || sc.Expires.After(now) {
+			j.cookies = append(j.cookies, sc)
+		}
+	}
+	j.save()
+}
+
+func (j *persistentJar) remove(domain, path, name string) {
+	kept := j.cookies[:0]
+	for _, c := range j.cookies {
+		if c.Domain != domain || c.Path != path || c.Name != name {
+			kept = append(kept, c)
+		}
+	}
+	j.cookies = kept
+}
+
+func (j *persistentJar) Cookies(u *url.URL) []*http.Cookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	host := strings.ToLower(u.Hostname())
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+
+	var cookies []*http.Cookie
+	now := time.Now()
+	for _, c := range j.cookies {
+		if !c.Expires.IsZero() && c.Expires.Before(now) {
+			continue
+		}
+		if c.Secure && u.Scheme != "https" {
+			continue
+		}
+		if host != c.Domain && (c.HostOnly || !strings.HasSuffix(host, "."+c.Domain)) {
+			continue
+		}
+		if !strings.HasPrefix(path, c.Path) {
+			continue
+		}
+		cookies = append(cookies, &http.Cookie{Name: c.Name, Value: c.Value})
+	}
+	return cookies
+}
+
+// Clear removes all cookies, e.g. to log out.
+func (j *persistentJar) Clear() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.cookies = nil
+	return j.save()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cookiesFile is the cookies.txt given with --cookies, used instead of the
+// jar in the state directory.
+var cookiesFile string
+
+const netscapeHeader = "# Netscape HTTP Cookie File\n# Written by " + programName + "\n\n"
+
+// parseNetscapeCookies reads the cookies.txt format of browser exporters,
+// curl and other downloaders: tab-separated domain, subdomains flag, path,
+// secure flag, expiry, name and value.
+func parseNetscapeCookies(data []byte) ([]*storedCookie, error) {
+	var cookies []*storedCookie
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		// HttpOnly cookies are written as comments with this prefix
+		line, _ = strings.CutPrefix(line, "#HttpOnly_")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) < 7 {
+			return nil, fmt.Errorf("line %d: expected 7 tab-separated fields", n)
+		}
+		c := &storedCookie{
+			Domain:   strings.TrimPrefix(strings.ToLower(fields[0]), "."),
+			HostOnly: !strings.EqualFold(fields[1], "TRUE"),
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			Name:     fields[5],
+			Value:    strings.Join(fields[6:], "\t"),
+		}
+		if expiry, err := strconv.ParseInt(fields[4], 10, 64); err == nil && expiry > 0 {
+			c.Expires = time.Unix(expiry, 0)
+		}
+		if c.Path == "" {
+			c.Path = "/"
+		}
+		cookies = append(cookies, c)
+	}
+	return cookies, scanner.Err()
+}
+
+// formatNetscapeCookies writes cookies in the cookies.txt format.
+func formatNetscapeCookies(cookies []*storedCookie) []byte {
+	var b strings.Builder
+	b.WriteString(netscapeHeader)
+	flag := func(v bool) string {
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	}
+	for _, c := range cookies {
+		domain := c.Domain
+		if !c.HostOnly {
+			domain = "." + domain
+		}
+		var expiry int64
+		if !c.Expires.IsZero() {
+			expiry = c.Expires.Unix()
+		}
+		fmt.Fprintf(&b, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", domain, flag(!c.HostOnly), c.Path, flag(c.Secure), expiry, c.Name, c.Value)
+	}
+	return []byte(b.String())
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recentLog keeps the last lines of output for the crash report.
+var recentLog = &logRing{max: 200}
+
+type logRing struct {
+	mu      sync.Mutex
+	max     int
+	lines   []string
+	partial string
+}
+
+func (r *logRing) add(text string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	text = r.partial + text
+	lines := strings.Split(text, "\n")
+	r.partial = lines[len(lines)-1]
+	r.lines = append(r.lines, lines[:len(lines)-1]...)
+	if len(r.lines) > r.max {
+		r.lines = append([]string(nil), r.lines[len(r.lines)-r.max:]...)
+	}
+}
+
+func (r *logRing) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return strings.Join(append(append([]string(nil), r.lines...), r.partial), "\n")
+}
+
+// recoverCrash turns a panic into a crash report with what is needed for a
+// bug report, then exits. It has to be deferred directly, albumURL is what
+// was being downloaded, if known.
+func recoverCrash(albumURL string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	stack := debug.Stack()
+	fmt.Fprintf(os.Stderr, "\n%s crashed: %v\n", programName, r)
+	path, err := writeCrashReport(r, stack, albumURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\nError saving the crash report: %v\n", stack, err)
+	} else {
+		fmt.Fprintf(os.Stderr, "A report for a bug report was saved to %s\n", path)
+	}
+	os.Exit(1)
+}
+
+func writeCrashReport(panicValue any, stack []byte, albumURL string) (string, error) {
+	var b strings.Builder
+	info := currentVersion()
+	fmt.Fprintf(&b, "%s %s", programName, info.Version)
+	if info.Commit != "" {
+		fmt.Fprintf(&b, " (commit %s, built %s)", info.Commit, info.BuildDate)
+	}
+	fmt.Fprintf(&b, ", %s, %s\n", info.GoVersion, info.Platform)
+	fmt.Fprintf(&b, "Time: %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&b, "Command line: %s\n", strings.Join(redactArgs(os.Args[1:]), " "))
+	if albumURL != "" {
+		fmt.Fprintf(&b, "URL: %s\n", albumURL)
+	}
+	fmt.Fprintf(&b, "\nPanic: %v\n\n%s\n", panicValue, stack)
+
+	b.WriteString("Config (secrets removed):\n")
+	if config, err := appConfig(); err != nil {
+		fmt.Fprintf(&b, "error: %v\n", err)
+	} else {
+		data, _ := json.MarshalIndent(config.redacted(), "", "  ")
+		b.Write(append(data, '\n'))
+	}
+
+	b.WriteString("\nLast output:\n")
+	b.WriteString(recentLog.String())
+	b.WriteString("\n")
+
+	name := fmt.Sprintf("%s-crash-%s.txt", programName, time.Now().Format("20060102-150405"))
+	path := filepath.Join(os.TempDir(), name)
+	return path, os.WriteFile(path, []byte(b.String()), 0600)
+}
+
+// secretFlags take values that mustn't end up in a bug report.
+var secretFlags = []string{"token", "password", "auth", "cookie", "webhook", "secret"}
+
+// redactArgs replaces the values of secret flags and URLs with
+// credentials in them.
+func redactArgs(args []string) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		out[i] = arg
+		if i > 0 && strings.HasPrefix(args[i-1], "--") {
+			for _, word := range secretFlags {
+				if strings.Contains(args[i-1], word) {
+					out[i] = "<redacted>"
+				}
+			}
+		}
+		if kind, _, ok := strings.Cut(arg, ":"); ok && (kind == "webhook" || kind == "discord") {
+			out[i] = kind + ":<redacted>"
+		}
+	}
+	return out
+}
+
+// redacted returns a copy of the config without passwords and tokens.
+func (c *Config) redacted() Config {
+	copied := *c
+	if copied.SMTP.Password != "" {
+		copied.SMTP.Password = "<redacted>"
+	}
+	copied.Users = append([]serverUser(nil), c.Users...)
+	for i := range copied.Users {
+		copied.Users[i].Token = "<redacted>"
+	}
+	return copied
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// sdNotify tells systemd about the server's state when it runs as a
+// Type=notify service, and does nothing otherwise.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:] // abstract socket
+	}
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte(state))
+}
+
+// serve runs the HTTP server until it fails or a signal stops it. SIGHUP
+// reloads the config file, SIGINT and SIGTERM let the current album finish
+// before exiting; a second one exits right away.
+func (s *server) serve(srv *http.Server, tlsCert, tlsKey string) error {
+	listener, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	s.started = time.Now()
+
+	errc := make(chan error, 1)
+	go func() {
+		if tlsCert != "" {
+			errc <- srv.ServeTLS(listener, tlsCert, tlsKey)
+		} else {
+			errc <- srv.Serve(listener)
+		}
+	}()
+	scheme := "http"
+	if tlsCert != "" {
+		scheme = "https"
+	}
+	logf("Listening on %s (%s)\n", srv.Addr, strings.ToUpper(scheme))
+	sdNotify("READY=1\nSTATUS=Listening on " + srv.Addr)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for {
+		select {
+		case err := <-errc:
+			return err
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				s.reload()
+				continue
+			}
+			return s.shutdown(srv, signals)
+		}
+	}
+}
+
+func (s *server) reload() {
+	sdNotify("RELOADING=1")
+	defer sdNotify("READY=1")
+
+	config, err := reloadConfig()
+	if err == nil {
+		err = s.setUsers(config.Users)
+	}
+	if err != nil {
+		logf("Error reloading the config, keeping the old one: %v\n", err)
+		return
+	}
+	logf("Config reloaded\n")
+}
+
+// shutdown stops taking requests and waits for the album being downloaded.
+func (s *server) shutdown(srv *http.Server, signals chan os.Signal) error {
+	sdNotify("STOPPING=1")
+	s.mu.Lock()
+	s.stopping = true
+	busy := false
+	for _, item := range s.items {
+		busy = busy || item.Status == "downloading"
+	}
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(ctx)
+
+	if !busy {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		s.active.Wait()
+		close(done)
+	}()
+	logf("Stopping once the current album has finished, signal again to stop right away\n")
+	select {
+	case <-done:
+		return nil
+	case <-signals:
+		logf("Stopped, the partial file will be resumed on the next start\n")
+		return nil
+	}
+}
+
+type serverStatus struct {
+	Version     string         `json:"version"`
+	Started     time.Time      `json:"started"`
+	Paused      bool           `json:"paused"`
+	Stopping    bool           `json:"stopping"`
+	Downloading *queueItem     `json:"downloading,omitempty"`
+	Counts      map[string]int `json:"counts"`
+}
+
+func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := serverStatus{
+		Version:  currentVersion().Version,
+		Started:  s.started,
+		Paused:   queuePause.isPaused(),
+		Stopping: s.stopping,
+		Counts:   make(map[string]int),
+	}
+	for _, item := range s.items {
+		if !item.visibleTo(user) {
+			continue
+		}
+		status.Counts[item.Status]++
+		if item.Status == "downloading" {
+			status.Downloading = item
+		}
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// runStatus asks a running server for its status.
+func runStatus(args []string) error {
+	args, err := withEnvArgs("status", args)
+	if err != nil {
+		return err
+	}
+	conn, rest, err := parseServerArgs(args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("usage: khinsider_downloader status [--server <url>] [--token <token> | --basic-auth <user:password>]")
+	}
+
+	var status serverStatus
+	if err := conn.do("GET", "/api/status", nil, &status); err != nil {
+		return err
+	}
+
+	state := "running"
+	switch {
+	case status.Stopping:
+		state = "stopping"
+	case status.Paused:
+		state = "paused"
+	}
+	fmt.Printf("Server %s at %s: %s, up %s\n", status.Version, conn.url, state, time.Since(status.Started).Round(time.Second))
+	if status.Downloading != nil {
+		fmt.Printf("Downloading: #%d %s\n", status.Downloading.ID, status.Downloading.URL)
+	}
+	for _, name := range []string{"queued", "downloading", "paused", "completed", "skipped", "failed"} {
+		if n := status.Counts[name]; n > 0 {
+			fmt.Printf("  %-12s %d\n", name+":", n)
+		}
+	}
+	return nil
+}
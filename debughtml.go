@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// debugHTMLDir is set by --debug-html, to debug-html in the output folder:
+// pages that yield no tracks or no
+// download links are saved there, with a note on which selectors found
+// nothing.
+var debugHTMLDir string
+
+// The selectors each page is read with, from the outside in, so the first
+// one matching nothing shows where the page changed.
+func albumPageSelectors() []string {
+	sel := selectors()
+	return []string{sel.AlbumText, sel.AlbumTitle, sel.SongTable, sel.SongTable + " " + sel.SongHeader, sel.SongTable + " " + sel.SongRows, sel.SongLink}
+}
+
+func songPageSelectors() []string {
+	return []string{selectors().DownloadLinks}
+}
+
+// dumpDebugHTML saves a page that couldn't be read and logs the selectors
+// that matched nothing.
+func dumpDebugHTML(pageURL string, body []byte, selectors []string) {
+	if debugHTMLDir == "" {
+		return
+	}
+
+	var empty []string
+	if doc, err := newDocument(bytes.NewReader(body)); err == nil {
+		for _, sel := range selectors {
+			if doc.Find(sel).Length() == 0 {
+				empty = append(empty, sel)
+			}
+		}
+	}
+
+	name := sanitizeFilename(strings.TrimSuffix(filepath.Base(pageURL), ".html"))
+	path := filepath.Join(debugHTMLDir, fmt.Sprintf("%s-%s.html", time.Now().Format("20060102-150405"), name))
+	if err := os.MkdirAll(debugHTMLDir, 0755); err != nil {
+		logf("Error saving the page for debugging: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(path, body, 0644); err != nil {
+		logf("Error saving the page for debugging: %v\n", err)
+		return
+	}
+
+	logf("Saved the page of %s to %s\n", pageURL, path)
+	if len(empty) == 0 {
+		logf("  All selectors matched: %s\n", strings.Join(selectors, ", "))
+	}
+	for _, sel := range empty {
+		logf("  Matched nothing: %s\n", sel)
+	}
+}
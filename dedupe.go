@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Compilation albums share tracks with the albums they collect. With
+// --dedupe, a track that is already in the output folder (or library) under
+// the same file name and with the same size is linked or copied from there
+// instead of being downloaded again.
+
+var dedupeModes = []string{"hardlink", "symlink", "copy", "skip"}
+
+// trackIndex lists the audio files under a folder by lowercased file name.
+type trackIndex struct {
+	mu     sync.Mutex
+	byName map[string][]string
+}
+
+var (
+	trackIndexesMu sync.Mutex
+	trackIndexes   = make(map[string]*trackIndex)
+)
+
+// trackIndexFor returns the index of root, walked once per run and kept up
+// to date with the tracks downloaded since.
+func trackIndexFor(root string) *trackIndex {
+	trackIndexesMu.Lock()
+	defer trackIndexesMu.Unlock()
+	if index, ok := trackIndexes[root]; ok {
+		return index
+	}
+
+	index := &trackIndex{byName: make(map[string][]string)}
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && isAudioFile(path) {
+			index.add(path)
+		}
+		return nil
+	})
+	trackIndexes[root] = index
+	return index
+}
+
+func isAudioFile(path string) bool {
+	return contains(audioFormats, strings.ToUpper(strings.TrimPrefix(filepath.Ext(path), ".")))
+}
+
+func (x *trackIndex) add(path string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	name := strings.ToLower(filepath.Base(path))
+	if !contains(x.byName[name], path) {
+		x.byName[name] = append(x.byName[name], path)
+	}
+}
+
+// duplicate returns a file outside of albumDir that is the same track: the
+// same file name, and the exact size from the preflight or else the size in
+// the track list. Tracks of unknown size never match.
+func (x *trackIndex) duplicate(song *Song, filename, albumDir string) string {
+	x.mu.Lock()
+	candidates := append([]string(nil), x.byName[strings.ToLower(filename)]...)
+	x.mu.Unlock()
+
+	listed := int64(song.Sizes[strings.ToUpper(strings.TrimPrefix(filepath.Ext(filename), "."))]) * 1024
+	for _, path := range candidates {
+		if filepath.Dir(path) == albumDir {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		switch {
+		case song.ContentLength > 0:
+			if info.Size() == song.ContentLength {
+				return path
+			}
+		case listed > 0:
+			// The listed sizes are rounded to hundredths of a megabyte
+			diff := info.Size() - listed
+			if diff < 0 {
+				diff = -diff
+			}
+			if diff <= max(listed/200, 12*1024) {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// placeDuplicate puts the track found at src in place of dst the --dedupe
+// way and says what was done. A hard link falls back to a copy across
+// filesystems.
+func placeDuplicate(mode, src, dst string) (string, error) {
+	switch mode {
+	case "skip":
+		return "same track already at " + src + ", not downloaded", nil
+	case "symlink":
+		abs, err := filepath.Abs(src)
+		if err != nil {
+			return "", err
+		}
+		if err := os.Symlink(abs, dst); err != nil {
+			return "", err
+		}
+		return "linked to " + src, nil
+	case "hardlink":
+		if err := os.Link(src, dst); err == nil {
+			return "hard-linked to " + src, nil
+		}
+		if err := copyFile(src, dst); err != nil {
+			return "", err
+		}
+		return "copied from " + src + " (can't hard-link across filesystems)", nil
+	case "copy":
+		if err := copyFile(src, dst); err != nil {
+			return "", err
+		}
+		return "copied from " + src, nil
+	}
+	return "", fmt.Errorf("unknown --dedupe mode: %s", mode)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst + ".tmp")
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst + ".tmp")
+		return err
+	}
+	return os.Rename(dst+".tmp", dst)
+}
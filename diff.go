@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Files the downloader itself puts next to the tracks, which diff doesn't
+// count as extra.
+var ownFiles = map[string]bool{metadataFilename: true, "album.html": true, lockFileName: true, extractedFileName: true}
+
+// runDiff compares a downloaded album folder with the album on the site
+// without changing anything.
+func runDiff(args []string) error {
+	// For the request options like --polite and --user-agent
+	_, positional, err := parseOptions(args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return fmt.Errorf("usage: khinsider_downloader diff <album_url> <dir>")
+	}
+	dir := positional[1]
+
+	albumURL, err := resolveAlbumURL(positional[0])
+	if err != nil {
+		return err
+	}
+	album, err := ParseAlbumPage(albumURL)
+	if err != nil {
+		return err
+	}
+	diff, err := compareAlbumDir(album, dir)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s\n%s <-> %s\n", album.Name, albumURL, dir)
+	if len(diff.missing)+len(diff.changed)+len(diff.extra) == 0 {
+		fmt.Printf("\nUp to date, all %d tracks are there\n", len(album.Songs))
+		return nil
+	}
+	for _, section := range []struct {
+		title string
+		lines []string
+	}{
+		{"Missing locally", diff.missing},
+		{"Size differs from the track list", diff.changed},
+		{"Not on the site", diff.extra},
+	} {
+		if len(section.lines) == 0 {
+			continue
+		}
+		fmt.Printf("\n%s (%d):\n", section.title, len(section.lines))
+		for _, line := range section.lines {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+	return nil
+}
+
+// albumDiff lists the differences between an album and its folder.
+type albumDiff struct {
+	missing []string // tracks without a file
+	changed []string // files whose size doesn't match the track list
+	extra   []string // files that aren't tracks of the album
+}
+
+func compareAlbumDir(album *Album, dir string) (*albumDiff, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tracks are matched with local files by the name in the song link,
+	// in any format
+	byStem := make(map[string][]string)
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			stem := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+			byStem[stem] = append(byStem[stem], e.Name())
+		}
+	}
+
+	diff := &albumDiff{}
+	matched := make(map[string]bool)
+	for i, song := range album.Songs {
+		base := filepath.Base(song.SongLink)
+		if unescaped, err := url.PathUnescape(base); err == nil {
+			base = unescaped
+		}
+		files := byStem[strings.TrimSuffix(base, filepath.Ext(base))]
+		if len(files) == 0 {
+			diff.missing = append(diff.missing, fmt.Sprintf("%3d. %s", i+1, song.Name))
+			continue
+		}
+		for _, name := range files {
+			matched[name] = true
+			path := filepath.Join(dir, name)
+			if !sizeMatchesList(song, path) {
+				info, _ := os.Stat(path)
+				format := strings.ToUpper(strings.TrimPrefix(filepath.Ext(name), "."))
+				diff.changed = append(diff.changed, fmt.Sprintf("%3d. %s: %s locally, %s on the site", i+1, name, formatBytes(info.Size()), formatBytes(int64(song.Sizes[format])*1024)))
+			}
+		}
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		switch {
+		case !e.Type().IsRegular(), matched[name], ownFiles[name], isImageFile(name):
+		case strings.HasSuffix(name, ".tmp"):
+			diff.extra = append(diff.extra, name+" (unfinished download)")
+		default:
+			diff.extra = append(diff.extra, name)
+		}
+	}
+	sort.Strings(diff.extra)
+
+	return diff, nil
+}
+
+func isImageFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp", ".bmp":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+const appDirName = "khinsider_downloader"
+
+// Set by --cache-dir and --state-dir.
+var cacheDirOverride, stateDirOverride string
+
+// cacheDir is where data that can be fetched again is kept, like the page
+// cache: $XDG_CACHE_HOME/khinsider_downloader (~/.cache) on Linux,
+// ~/Library/Caches on macOS and %LocalAppData% on Windows.
+func cacheDir() string {
+	if dir := dirOverride(cacheDirOverride, "--cache-dir"); dir != "" {
+		return dir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, appDirName)
+}
+
+// stateDir is where data the program keeps between runs lives, like the
+// login cookies and the favorites archive: $XDG_STATE_HOME/khinsider_downloader
+// (~/.local/state) on Linux, ~/Library/Application Support on macOS and
+// %LocalAppData% on Windows.
+func stateDir() string {
+	if dir := dirOverride(stateDirOverride, "--state-dir"); dir != "" {
+		return dir
+	}
+
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("LocalAppData"); dir != "" {
+			return filepath.Join(dir, appDirName)
+		}
+	case "darwin", "ios":
+		if dir, err := os.UserConfigDir(); err == nil {
+			return filepath.Join(dir, appDirName)
+		}
+	default:
+		if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+			return filepath.Join(dir, appDirName)
+		}
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, ".local", "state", appDirName)
+		}
+	}
+	return ""
+}
+
+func dirOverride(flagValue, flag string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	return os.Getenv(envName(flag))
+}
+
+// statePath returns the path of a state file, moving it over from where
+// older versions kept it.
+func statePath(name, oldPath string) string {
+	dir := stateDir()
+	if dir == "" {
+		return oldPath
+	}
+	path := filepath.Join(dir, name)
+	if oldPath == "" || oldPath == path {
+		return path
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		return path
+	}
+	if _, err := os.Stat(oldPath); err != nil {
+		return path
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return oldPath
+	}
+	if err := os.Rename(oldPath, path); err != nil {
+		return oldPath
+	}
+	logf("Moved %s to %s\n", oldPath, path)
+	return path
+}
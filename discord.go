@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type discordMessage struct {
+	Username string         `json:"username,omitempty"`
+	Embeds   []discordEmbed `json:"embeds"`
+}
+
+type discordEmbed struct {
+	Title     string              `json:"title"`
+	URL       string              `json:"url,omitempty"`
+	Color     int                 `json:"color"`
+	Thumbnail *discordImage       `json:"thumbnail,omitempty"`
+	Fields    []discordEmbedField `json:"fields"`
+	Timestamp time.Time           `json:"timestamp"`
+}
+
+type discordImage struct {
+	URL string `json:"url"`
+}
+
+type discordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+// discordNotifier posts finished albums to a Discord webhook.
+type discordNotifier struct {
+	url string
+}
+
+func (n discordNotifier) Notify(ev progressEvent) error {
+	if ev.Event != "album_completed" || ev.album == nil {
+		return nil
+	}
+	stats := ev.stats
+	if stats == nil {
+		stats = &albumStats{}
+	}
+	return postDiscordAlbum(n.url, ev.album, stats, ev.Successful, ev.Failed)
+}
+
+// postDiscordAlbum posts an embed summing up a finished album.
+func postDiscordAlbum(webhookURL string, album *Album, stats *albumStats, successful, failed int) error {
+	length := 0
+	for _, song := range album.Songs {
+		length += song.LengthSeconds
+	}
+
+	embed := discordEmbed{
+		Title:     album.Name,
+		URL:       album.AlbumLink,
+		Color:     0x2ecc71,
+		Timestamp: time.Now(),
+		Fields: []discordEmbedField{
+			{Name: "Tracks", Value: fmt.Sprintf("%d", successful), Inline: true},
+			{Name: "Size", Value: formatBytes(stats.Bytes), Inline: true},
+			{Name: "Duration", Value: (time.Duration(length) * time.Second).String(), Inline: true},
+		},
+	}
+	if len(album.Platforms) > 0 {
+		embed.Fields = append(embed.Fields, discordEmbedField{Name: "Platforms", Value: strings.Join(album.Platforms, ", "), Inline: true})
+	}
+	if album.Year != "" {
+		embed.Fields = append(embed.Fields, discordEmbedField{Name: "Year", Value: album.Year, Inline: true})
+	}
+	if failed > 0 {
+		embed.Color = 0xe74c3c
+		embed.Fields = append(embed.Fields, discordEmbedField{Name: "Failed", Value: fmt.Sprintf("%d", failed), Inline: true})
+	}
+	if cover := album.frontCover(); cover != "" {
+		if !strings.HasPrefix(cover, "http") {
+			cover = "https://downloads.khinsider.com" + cover
+		}
+		embed.Thumbnail = &discordImage{URL: cover}
+	}
+
+	return postWebhook(webhookURL, discordMessage{Username: programName, Embeds: []discordEmbed{embed}})
+}
@@ -0,0 +1,27 @@
+//go:build !lite
+
+package main
+
+import (
+	"io"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+)
+
+// Pages are read with goquery, or with the smaller parser in dom_lite.go
+// when built with -tags lite.
+type (
+	document  = goquery.Document
+	selection = goquery.Selection
+)
+
+func newDocument(r io.Reader) (*document, error) {
+	return goquery.NewDocumentFromReader(r)
+}
+
+// checkSelector tells if goquery understands a selector.
+func checkSelector(selector string) error {
+	_, err := cascadia.Compile(selector)
+	return err
+}
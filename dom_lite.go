@@ -0,0 +1,282 @@
+//go:build lite
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// The lite build reads pages with x/net/html alone. It implements the part
+// of goquery's API this program uses, and the selectors it uses: tag names,
+// #id, .class and [attr], [attr=v], [attr*=v], [attr^=v] and [attr$=v],
+// combined with descendant combinators.
+
+type document struct {
+	*selection
+}
+
+type selection struct {
+	nodes []*html.Node
+}
+
+func newDocument(r io.Reader) (*document, error) {
+	root, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	return &document{&selection{nodes: []*html.Node{root}}}, nil
+}
+
+// checkSelector tells if Find understands a selector.
+func checkSelector(selector string) error {
+	_, err := parseSelector(selector)
+	return err
+}
+
+// Find returns the descendants of the selection matching selector, in
+// document order. A selector it doesn't understand matches nothing, like
+// in goquery.
+func (s *selection) Find(selector string) *selection {
+	found := &selection{}
+	sel, err := parseSelector(selector)
+	if err != nil {
+		return found
+	}
+	seen := make(map[*html.Node]bool)
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && !seen[c] && sel.match(c) {
+				seen[c] = true
+				found.nodes = append(found.nodes, c)
+			}
+			walk(c)
+		}
+	}
+	for _, n := range s.nodes {
+		walk(n)
+	}
+	return found
+}
+
+func (s *selection) Length() int {
+	return len(s.nodes)
+}
+
+func (s *selection) Eq(i int) *selection {
+	if i < 0 {
+		i += len(s.nodes)
+	}
+	if i < 0 || i >= len(s.nodes) {
+		return &selection{}
+	}
+	return &selection{nodes: []*html.Node{s.nodes[i]}}
+}
+
+func (s *selection) Slice(start, end int) *selection {
+	start, end = max(start, 0), min(end, len(s.nodes))
+	if start >= end {
+		return &selection{}
+	}
+	return &selection{nodes: s.nodes[start:end]}
+}
+
+func (s *selection) First() *selection {
+	return s.Eq(0)
+}
+
+func (s *selection) Each(f func(int, *selection)) *selection {
+	for i, n := range s.nodes {
+		f(i, &selection{nodes: []*html.Node{n}})
+	}
+	return s
+}
+
+func (s *selection) EachWithBreak(f func(int, *selection) bool) *selection {
+	for i, n := range s.nodes {
+		if !f(i, &selection{nodes: []*html.Node{n}}) {
+			break
+		}
+	}
+	return s
+}
+
+// Attr returns an attribute of the first element.
+func (s *selection) Attr(name string) (string, bool) {
+	if len(s.nodes) == 0 {
+		return "", false
+	}
+	for _, a := range s.nodes[0].Attr {
+		if a.Namespace == "" && a.Key == name {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+// Text returns the text of all elements, with their descendants.
+func (s *selection) Text() string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, n := range s.nodes {
+		walk(n)
+	}
+	return b.String()
+}
+
+// Html returns the inner HTML of the first element.
+func (s *selection) Html() (string, error) {
+	if len(s.nodes) == 0 {
+		return "", nil
+	}
+	var b strings.Builder
+	for c := s.nodes[0].FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(&b, c); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// selector is a list of compound selectors, each matching a descendant of
+// an element matching the one before.
+type selector []compoundSelector
+
+type compoundSelector struct {
+	tag     string
+	id      string
+	classes []string
+	attrs   []attrSelector
+}
+
+type attrSelector struct {
+	name, op, value string
+}
+
+func parseSelector(text string) (selector, error) {
+	var sel selector
+	for _, part := range strings.Fields(text) {
+		var c compoundSelector
+		for part != "" {
+			switch part[0] {
+			case '#', '.':
+				end := strings.IndexAny(part[1:], "#.[")
+				if end < 0 {
+					end = len(part) - 1
+				}
+				if part[0] == '#' {
+					c.id = part[1 : end+1]
+				} else {
+					c.classes = append(c.classes, part[1:end+1])
+				}
+				part = part[end+1:]
+			case '[':
+				end := strings.IndexByte(part, ']')
+				if end < 0 {
+					return nil, fmt.Errorf("bad selector: %s", text)
+				}
+				c.attrs = append(c.attrs, parseAttrSelector(part[1:end]))
+				part = part[end+1:]
+			default:
+				end := strings.IndexAny(part, "#.[")
+				if end < 0 {
+					end = len(part)
+				}
+				c.tag = strings.ToLower(part[:end])
+				part = part[end:]
+			}
+		}
+		sel = append(sel, c)
+	}
+	if len(sel) == 0 {
+		return nil, fmt.Errorf("empty selector")
+	}
+	return sel, nil
+}
+
+func parseAttrSelector(text string) attrSelector {
+	for _, op := range []string{"*=", "^=", "$=", "="} {
+		if name, value, ok := strings.Cut(text, op); ok {
+			return attrSelector{name: name, op: op, value: strings.Trim(value, `'"`)}
+		}
+	}
+	return attrSelector{name: text}
+}
+
+// match tells if n matches the last compound selector and has ancestors
+// matching the ones before it.
+func (sel selector) match(n *html.Node) bool {
+	if !sel[len(sel)-1].match(n) {
+		return false
+	}
+	rest := sel[:len(sel)-1]
+	for p := n.Parent; p != nil && len(rest) > 0; p = p.Parent {
+		if p.Type == html.ElementNode && rest[len(rest)-1].match(p) {
+			rest = rest[:len(rest)-1]
+		}
+	}
+	return len(rest) == 0
+}
+
+func (c compoundSelector) match(n *html.Node) bool {
+	if c.tag != "" && n.Data != c.tag {
+		return false
+	}
+	attr := func(name string) (string, bool) {
+		for _, a := range n.Attr {
+			if a.Namespace == "" && a.Key == name {
+				return a.Val, true
+			}
+		}
+		return "", false
+	}
+	if c.id != "" {
+		if id, _ := attr("id"); id != c.id {
+			return false
+		}
+	}
+	if len(c.classes) > 0 {
+		class, _ := attr("class")
+		for _, want := range c.classes {
+			found := false
+			for _, have := range strings.Fields(class) {
+				found = found || have == want
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	for _, a := range c.attrs {
+		val, ok := attr(a.name)
+		if !ok {
+			return false
+		}
+		switch a.op {
+		case "=":
+			ok = val == a.value
+		case "*=":
+			ok = a.value != "" && strings.Contains(val, a.value)
+		case "^=":
+			ok = a.value != "" && strings.HasPrefix(val, a.value)
+		case "$=":
+			ok = a.value != "" && strings.HasSuffix(val, a.value)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+//go:build lite
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		text string
+		want selector
+		err  bool
+	}{
+		{"td", selector{{tag: "td"}}, false},
+		{"TABLE#songlist", selector{{tag: "table", id: "songlist"}}, false},
+		{"td.clickable-row.wide", selector{{tag: "td", classes: []string{"clickable-row", "wide"}}}, false},
+		{"#pageContent p a", selector{{id: "pageContent"}, {tag: "p"}, {tag: "a"}}, false},
+		{"a[href*='/album/']", selector{{tag: "a", attrs: []attrSelector{{name: "href", op: "*=", value: "/album/"}}}}, false},
+		{`a[href^="https:"][download]`, selector{{tag: "a", attrs: []attrSelector{{name: "href", op: "^=", value: "https:"}, {name: "download"}}}}, false},
+		{"img[src$=.jpg]", selector{{tag: "img", attrs: []attrSelector{{name: "src", op: "$=", value: ".jpg"}}}}, false},
+		{"a[href", nil, true},
+		{"", nil, true},
+		{"   ", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSelector(tt.text)
+		if (err != nil) != tt.err {
+			t.Errorf("parseSelector(%q) error = %v, want error %v", tt.text, err, tt.err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSelector(%q) = %+v, want %+v", tt.text, got, tt.want)
+		}
+	}
+}
+
+// The built-in selectors must all be ones the lite build understands.
+func TestDefaultSelectorsParse(t *testing.T) {
+	var defaults map[string]string
+	if err := json.Unmarshal(defaultSelectorsJSON, &defaults); err != nil {
+		t.Fatal(err)
+	}
+	for name, text := range defaults {
+		if _, err := parseSelector(text); err != nil {
+			t.Errorf("%s: parseSelector(%q) error = %v", name, text, err)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	const page = `<div id="pageContent">
+<p><a href="/game-soundtracks/album/a">A</a> <a class="x" href="/other">B</a></p>
+<table id="songlist"><tr><td class="clickable-row wide"><a href="/game-soundtracks/album/a/01.mp3">01</a></td></tr></table>
+</div><a href="/game-soundtracks/album/outside">C</a>`
+	doc, err := newDocument(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		selector string
+		want     []string // the texts of the matches
+	}{
+		{"#pageContent a[href*='/game-soundtracks/album/']", []string{"A", "01"}},
+		{"a[href*='/game-soundtracks/album/']", []string{"A", "01", "C"}},
+		{"p a.x", []string{"B"}},
+		{"table#songlist td.clickable-row a", []string{"01"}},
+		{"td.clickable-row.narrow a", nil},
+		{"a[href^='/other']", []string{"B"}},
+		{"a[href$='.mp3']", []string{"01"}},
+		{"a[href", nil}, // doesn't parse, matches nothing instead of panicking
+	}
+	for _, tt := range tests {
+		var got []string
+		doc.Find(tt.selector).Each(func(_ int, s *selection) {
+			got = append(got, s.Text())
+		})
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Find(%q) = %q, want %q", tt.selector, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The download archive is a plain text file with the URL of every album
+// that has been downloaded completely, one per line.
+
+func archiveContains(path, albumURL string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	albumURL = normalizeAlbumURL(albumURL)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if normalizeAlbumURL(scanner.Text()) == albumURL {
+			return true
+		}
+	}
+	return false
+}
+
+func recordInArchive(path, albumURL string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintln(f, normalizeAlbumURL(albumURL))
+	return err
+}
+
+func normalizeAlbumURL(albumURL string) string {
+	albumURL = strings.TrimSpace(albumURL)
+	albumURL = strings.TrimSuffix(albumURL, "/")
+	return strings.Replace(albumURL, "http://", "https://", 1)
+}
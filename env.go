@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Every option can also be set with an environment variable, named after
+// the flag: KHINSIDER_ALBUM_CONCURRENCY=4 for --album-concurrency 4, or
+// KHINSIDER_TAGS=true for --tags. The command line takes precedence over
+// the environment, and the environment over the config file.
+const envPrefix = "KHINSIDER_"
+
+func envName(flag string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(strings.TrimPrefix(flag, "--"), "-", "_"))
+}
+
+// envArgs returns the options set in the environment as arguments, to be
+// put before the command line so a flag given there wins.
+func envArgs(options []optionHelp) ([]string, error) {
+	var args []string
+	for _, o := range options {
+		name := envName(o.Flag)
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		if o.Arg == "" {
+			on, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s=%s, expected true or false", name, value)
+			}
+			if on {
+				args = append(args, o.Flag)
+			}
+			continue
+		}
+		args = append(args, o.Flag, value)
+	}
+	return args, nil
+}
+
+// withEnvArgs puts the environment options of a subcommand before args.
+func withEnvArgs(command string, args []string) ([]string, error) {
+	for _, cmd := range commands {
+		if cmd.Name == command {
+			env, err := envArgs(cmd.Options)
+			if err != nil {
+				return nil, err
+			}
+			return append(env, args...), nil
+		}
+	}
+	return args, nil
+}
+
+// applyEnv overrides the config file's settings with KHINSIDER_SMTP_*
+// variables, so containers don't need a config file.
+func (c *Config) applyEnv() error {
+	setString := func(name string, dst *string) {
+		if v := os.Getenv(envPrefix + name); v != "" {
+			*dst = v
+		}
+	}
+	setString("SMTP_HOST", &c.SMTP.Host)
+	setString("SMTP_USERNAME", &c.SMTP.Username)
+	setString("SMTP_PASSWORD", &c.SMTP.Password)
+	setString("SMTP_FROM", &c.SMTP.From)
+	if v := os.Getenv(envPrefix + "SMTP_TO"); v != "" {
+		c.SMTP.To = strings.Split(v, ",")
+	}
+	if v := os.Getenv(envPrefix + "SMTP_PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid %sSMTP_PORT: %s", envPrefix, v)
+		}
+		c.SMTP.Port = port
+	}
+	return nil
+}
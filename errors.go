@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"syscall"
+)
+
+// Failure classes that wrapper scripts can tell apart, by the exit code or
+// the "code" of failed events in --progress json.
+var (
+	errInvalidInput = errors.New("invalid input")
+	errNotFound     = errors.New("not found")
+	errRateLimited  = errors.New("rate limited")
+	errNetwork      = errors.New("network error")
+	errParse        = errors.New("page not understood")
+	errDiskFull     = errors.New("disk full")
+	errUnauthorized = errors.New("not allowed")
+	errPartial      = errors.New("some tracks failed")
+	errChallenge    = errors.New("anti-bot challenge")
+	errAborted      = errors.New("stopped early")
+)
+
+var errorClasses = []struct {
+	err      error
+	code     string
+	exitCode int
+}{
+	{errInvalidInput, "invalid_input", 2},
+	{errChallenge, "challenge", 10},
+	{errNotFound, "not_found", 3},
+	{errRateLimited, "rate_limited", 4},
+	{errDiskFull, "disk_full", 7},
+	{errUnauthorized, "unauthorized", 8},
+	{errParse, "parse", 6},
+	{errNetwork, "network", 5},
+	{errPartial, "partial", 9},
+	{errAborted, "aborted", 11},
+}
+
+// classError is an error message belonging to one of the classes above.
+type classError struct {
+	class error
+	msg   string
+}
+
+func (e *classError) Error() string { return e.msg }
+func (e *classError) Unwrap() error { return e.class }
+
+func newClassError(class error, msg string) error {
+	return &classError{class: class, msg: msg}
+}
+
+// Is sorts HTTP errors into the classes.
+func (e *httpStatusError) Is(target error) bool {
+	switch e.StatusCode {
+	case 404, 410:
+		return target == errNotFound
+	case 429, 503:
+		return target == errRateLimited
+	case 401, 403:
+		return target == errUnauthorized
+	}
+	return false
+}
+
+// errorClass returns the code and exit code of err's class, "error" and 1
+// for anything else.
+func errorClass(err error) (string, int) {
+	if errors.Is(err, syscall.ENOSPC) {
+		err = errDiskFull
+	}
+	for _, c := range errorClasses {
+		if errors.Is(err, c.err) {
+			return c.code, c.exitCode
+		}
+	}
+	var urlErr *url.Error
+	var netErr net.Error
+	if errors.As(err, &urlErr) || errors.As(err, &netErr) {
+		return "network", 5
+	}
+	return "error", 1
+}
+
+// errorCode is the class of err for JSON output, empty for no error.
+func errorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	code, _ := errorClass(err)
+	return code
+}
+
+// exitCode is the process exit code for err.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	_, code := errorClass(err)
+	return code
+}
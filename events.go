@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// eventHub passes the download events on to the server's streaming
+// clients: /api/events and the gRPC WatchProgress. A client connected with a
+// user's token only gets the events of that user's queue items.
+type eventHub struct {
+	mu      sync.Mutex
+	clients map[chan progressEvent]*serverUser // nil for all events
+	owner   string                             // user of the item downloading
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{clients: make(map[chan progressEvent]*serverUser)}
+}
+
+// setOwner attributes the following events to a user's queue item, "" for
+// items without one.
+func (h *eventHub) setOwner(user string) {
+	h.mu.Lock()
+	h.owner = user
+	h.mu.Unlock()
+}
+
+// Notify never blocks the download: a client that can't keep up misses
+// events rather than slowing the transfer down.
+func (h *eventHub) Notify(ev progressEvent) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch, user := range h.clients {
+		if user != nil && user.Name != h.owner {
+			continue
+		}
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+	return nil
+}
+
+func (h *eventHub) subscribe(user *serverUser) chan progressEvent {
+	ch := make(chan progressEvent, 64)
+	h.mu.Lock()
+	h.clients[ch] = user
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan progressEvent) {
+	h.mu.Lock()
+	delete(h.clients, ch)
+	h.mu.Unlock()
+}
+
+// handleEvents streams the events as server-sent events, named after the
+// event type with the JSON object as data.
+func (h *eventHub) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "streaming not supported"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // keep nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	events := h.subscribe(requestUser(r))
+	defer h.unsubscribe(events)
+
+	// Comments keep proxies from closing an idle connection
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case ev := <-events:
+			data, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Event, data)
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// runExport writes an album's track list, or every downloaded album under
+// a folder (found by their album.json), as CSV or TSV for spreadsheets.
+func runExport(args []string) error {
+	format, output, fromFile := "csv", "", ""
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--format" && i+1 < len(args):
+			format = strings.ToLower(args[i+1])
+			i++
+		case args[i] == "--output" && i+1 < len(args):
+			output = args[i+1]
+			i++
+		case args[i] == "--from-file" && i+1 < len(args):
+			fromFile = args[i+1]
+			i++
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	if format != "csv" && format != "tsv" {
+		return fmt.Errorf("unknown export format %q, use csv or tsv", format)
+	}
+
+	// For the request options like --polite and --user-agent
+	_, positional, err := parseOptions(rest)
+	if err != nil {
+		return err
+	}
+
+	var rows [][]string
+	switch {
+	case fromFile != "" && len(positional) == 0:
+		album, err := parseAlbumFile(fromFile)
+		if err != nil {
+			return err
+		}
+		rows = albumRows(album)
+	case len(positional) == 1 && isDir(positional[0]):
+		if rows, err = libraryRows(positional[0]); err != nil {
+			return err
+		}
+	case len(positional) == 1:
+		albumURL, err := resolveAlbumURL(positional[0])
+		if err != nil {
+			return err
+		}
+		album, err := ParseAlbumPage(albumURL)
+		if err != nil {
+			return err
+		}
+		rows = albumRows(album)
+	default:
+		return fmt.Errorf("usage: khinsider_downloader export [--format csv|tsv] [--output <file>] <album_url | library_dir> | --from-file <page.html>")
+	}
+
+	var out io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	w := csv.NewWriter(out)
+	if format == "tsv" {
+		w.Comma = '\t'
+	}
+	if err := w.WriteAll(rows); err != nil {
+		return err
+	}
+	if output != "" {
+		logf("Wrote %d row(s) to %s\n", len(rows)-1, output)
+	}
+	return nil
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// albumRows is the track list with a size column for every format.
+func albumRows(album *Album) [][]string {
+	formats := album.Formats
+	if len(formats) == 0 {
+		seen := make(map[string]bool)
+		for _, song := range album.Songs {
+			for format := range song.Sizes {
+				if !seen[format] {
+					seen[format] = true
+					formats = append(formats, format)
+				}
+			}
+		}
+		sort.Strings(formats)
+	}
+
+	header := []string{"album", "track", "title", "length"}
+	for _, format := range formats {
+		header = append(header, strings.ToLower(format)+"_size_kb")
+	}
+	rows := [][]string{append(header, "url")}
+
+	for i, song := range album.Songs {
+		row := []string{album.Name, strconv.Itoa(i + 1), song.Name, formatTrackLength(song.LengthSeconds)}
+		for _, format := range formats {
+			size := ""
+			if kb, ok := song.Sizes[format]; ok {
+				size = strconv.Itoa(kb)
+			}
+			row = append(row, size)
+		}
+		rows = append(rows, append(row, song.SongLink))
+	}
+	return rows
+}
+
+// libraryRows has a row for every album.json under root, with the formats
+// and size of the files actually on disk.
+func libraryRows(root string) ([][]string, error) {
+	rows := [][]string{{"album", "platforms", "year", "album_type", "catalog_number", "tracks", "formats", "size_bytes", "path", "url"}}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name() != metadataFilename {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var meta albumMetadata
+		if err := json.Unmarshal(data, &meta); err != nil {
+			logf("Skipping %s: %v\n", path, err)
+			return nil
+		}
+
+		dir := filepath.Dir(path)
+		formats, size := folderAudio(dir)
+		rows = append(rows, []string{
+			meta.Album,
+			strings.Join(meta.Platforms, ", "),
+			meta.Year,
+			meta.AlbumType,
+			meta.CatalogNum,
+			strconv.Itoa(len(meta.Tracks)),
+			strings.Join(formats, ", "),
+			strconv.FormatInt(size, 10),
+			dir,
+			meta.SourceURL,
+		})
+		return nil
+	})
+	return rows, err
+}
+
+// folderAudio returns the audio formats in a folder and their total size.
+func folderAudio(dir string) ([]string, int64) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, 0
+	}
+	var formats []string
+	var size int64
+	for _, e := range entries {
+		format := strings.ToUpper(strings.TrimPrefix(filepath.Ext(e.Name()), "."))
+		if e.IsDir() || !contains(audioFormats, format) {
+			continue
+		}
+		if !contains(formats, format) {
+			formats = append(formats, format)
+		}
+		if info, err := e.Info(); err == nil {
+			size += info.Size()
+		}
+	}
+	sort.Strings(formats)
+	return formats, size
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var audioExtensions = map[string]bool{
+	".mp3":  true,
+	".flac": true,
+	".ogg":  true,
+	".m4a":  true,
+	".aac":  true,
+	".opus": true,
+	".wav":  true,
+}
+
+// archiveKind detects zip and rar files by their magic bytes, so archives
+// served under an audio extension are caught as well.
+func archiveKind(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	magic := make([]byte, 7)
+	n, _ := io.ReadFull(f, magic)
+	magic = magic[:n]
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		return "zip"
+	case bytes.HasPrefix(magic, []byte("Rar!\x1a\x07")):
+		return "rar"
+	}
+	return ""
+}
+
+// extractTrackArchive replaces a downloaded track that turned out to be an
+// archive by the audio files in it, named by claim. The archive is moved to
+// a .part name first, so a file in it named like the track can't overwrite
+// it or be removed with it. When nothing could be extracted the archive is
+// put back.
+func extractTrackArchive(path, kind string, claim func(string) string) ([]string, error) {
+	partPath := path + ".part." + kind
+	if err := os.Rename(path, partPath); err != nil {
+		return nil, err
+	}
+	extracted, err := extractAudio(partPath, kind, filepath.Dir(path), claim)
+	if err != nil || len(extracted) == 0 {
+		os.Rename(partPath, path)
+		return extracted, err
+	}
+	os.Remove(partPath)
+	return extracted, nil
+}
+
+// extractedFileName records the tracks that were archives, which are gone
+// once extracted, so a new run or --update doesn't download them again.
+const extractedFileName = ".khinsider.extracted.json"
+
+// extractedTracks maps a track's file name to the files extracted from it.
+type extractedTracks map[string][]string
+
+func readExtracted(dir string) extractedTracks {
+	extracted := make(extractedTracks)
+	if data, err := os.ReadFile(filepath.Join(dir, extractedFileName)); err == nil {
+		json.Unmarshal(data, &extracted)
+	}
+	return extracted
+}
+
+// recordExtracted adds a track's extracted files to the record of its folder.
+func recordExtracted(dir, name string, files []string) error {
+	extracted := readExtracted(dir)
+	extracted[name] = files
+	data, err := json.MarshalIndent(extracted, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, extractedFileName), data, 0644)
+}
+
+// present returns the files extracted from the track when all of them are
+// still there.
+func (e extractedTracks) present(dir, name string) ([]string, bool) {
+	files := e[name]
+	if len(files) == 0 {
+		return nil, false
+	}
+	for _, file := range files {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			return nil, false
+		}
+	}
+	return files, true
+}
+
+// extractAudio extracts the audio files of an archive into destDir and
+// returns the names of the extracted files, as picked by claim.
+func extractAudio(archivePath, kind, destDir string, claim func(string) string) ([]string, error) {
+	switch kind {
+	case "zip":
+		return extractZipAudio(archivePath, destDir, claim)
+	case "rar":
+		return extractRarAudio(archivePath, destDir, claim)
+	}
+	return nil, fmt.Errorf("unknown archive type: %s", kind)
+}
+
+func extractZipAudio(archivePath, destDir string, claim func(string) string) ([]string, error) {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var extracted []string
+	for _, f := range zr.File {
+		name := sanitizeFilename(filepath.Base(filepath.FromSlash(f.Name)))
+		if f.FileInfo().IsDir() || !audioExtensions[strings.ToLower(filepath.Ext(name))] {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return extracted, err
+		}
+		name = claim(name)
+		err = writeExtractedFile(rc, filepath.Join(destDir, name))
+		rc.Close()
+		if err != nil {
+			return extracted, err
+		}
+		extracted = append(extracted, name)
+	}
+	return extracted, nil
+}
+
+// There is no rar decoder in the standard library, so use whichever
+// extraction tool is installed.
+func extractRarAudio(archivePath, destDir string, claim func(string) string) ([]string, error) {
+	tmpDir, err := os.MkdirTemp(destDir, ".extract-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var cmd *exec.Cmd
+	if path, err := exec.LookPath("unrar"); err == nil {
+		cmd = exec.Command(path, "e", "-o+", "-inul", archivePath, tmpDir+string(filepath.Separator))
+	} else if path, err := exec.LookPath("7z"); err == nil {
+		cmd = exec.Command(path, "e", "-y", "-bd", "-o"+tmpDir, archivePath)
+	} else {
+		return nil, fmt.Errorf("extracting rar archives requires unrar or 7z")
+	}
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%s failed: %v: %s", filepath.Base(cmd.Path), err, strings.TrimSpace(string(output)))
+	}
+
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var extracted []string
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !audioExtensions[strings.ToLower(filepath.Ext(e.Name()))] {
+			continue
+		}
+		name := claim(sanitizeFilename(e.Name()))
+		if err := os.Rename(filepath.Join(tmpDir, e.Name()), filepath.Join(destDir, name)); err != nil {
+			return extracted, err
+		}
+		extracted = append(extracted, name)
+	}
+	return extracted, nil
+}
+
+func writeExtractedFile(r io.Reader, path string) error {
+	tmpPath := path + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, r)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
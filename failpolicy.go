@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// failurePolicy stops a run early instead of going on no matter what, for
+// when something is wrong with every download (e.g. each track 403s). The
+// byte caps stop the run through it as well.
+type failurePolicy struct {
+	maxFailures    int64 // --max-failures, 1 with --fail-fast
+	abortOnFailure bool  // no further albums after one with failures
+	failFast       bool  // also stop at the first failed album
+
+	baseFailed atomic.Int64 // metrics.failed when the run started
+	stopped    atomic.Bool  // no further albums
+	stopTracks atomic.Bool  // nor tracks of the albums in progress
+	mu         sync.Mutex
+	reason     string
+}
+
+var policy = &failurePolicy{}
+
+// trackFailed stops the run once --max-failures tracks have failed.
+func (p *failurePolicy) trackFailed() {
+	if failed := metrics.failed.Load() - p.baseFailed.Load(); p.maxFailures > 0 && failed >= p.maxFailures {
+		p.stop(fmt.Sprintf("%d failed track(s)", failed), true)
+	}
+}
+
+// albumFinished stops the run after an album that failed or had failed
+// tracks, with --abort-on-failure or --fail-fast. Albums downloading at the
+// same time still finish with --abort-on-failure.
+func (p *failurePolicy) albumFinished(failed bool) {
+	if failed && (p.abortOnFailure || p.failFast) {
+		p.stop("an album had failures", p.failFast)
+	}
+}
+
+func (p *failurePolicy) stop(reason string, tracks bool) {
+	if tracks {
+		p.stopTracks.Store(true)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.stopped.Load() {
+		p.reason = reason
+		p.stopped.Store(true)
+		logf("%s\n", colorize("failed", "Stopping the run after "+reason))
+	}
+}
+
+// reset starts a new run, for each queue item of the server, so neither
+// the failures nor a stop of earlier ones carry over.
+func (p *failurePolicy) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.baseFailed.Store(metrics.failed.Load())
+	p.reason = ""
+	p.stopped.Store(false)
+	p.stopTracks.Store(false)
+}
+
+// isStopped reports whether no further albums should be started.
+func (p *failurePolicy) isStopped() bool {
+	return p.stopped.Load()
+}
+
+// tracksStopped reports whether the albums in progress should stop too.
+func (p *failurePolicy) tracksStopped() bool {
+	return p.stopTracks.Load()
+}
+
+// err is the error the run ends with once stopped, or nil.
+func (p *failurePolicy) err() error {
+	if !p.isStopped() {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return newClassError(errAborted, "stopped early after "+p.reason)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const favoritesURL = "https://downloads.khinsider.com/favorites"
+
+func runFavorites(args []string) error {
+	if len(args) == 0 || args[0] != "sync" {
+		return fmt.Errorf("usage: khinsider_downloader favorites sync [--favorites-url <url>] [options]")
+	}
+	args, err := withEnvArgs("favorites", args[1:])
+	if err != nil {
+		return err
+	}
+
+	pageURL := favoritesURL
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--favorites-url" && i+1 < len(args) {
+			pageURL = args[i+1]
+			i++
+			continue
+		}
+		rest = append(rest, args[i])
+	}
+
+	opts, _, err := parseOptions(rest)
+	if err != nil {
+		return err
+	}
+	if opts.DownloadArchive == "" {
+		opts.DownloadArchive = filepath.Join(opts.OutputDir, "archive.txt")
+		// Older versions kept it in the output folder, which may be shared
+		// with --download-archive, so it isn't moved
+		if _, err := os.Stat(opts.DownloadArchive); err != nil {
+			if path := statePath("favorites-archive.txt", ""); path != "" {
+				opts.DownloadArchive = path
+			}
+		}
+	}
+
+	if !isLoggedIn() && loginFromKeyring() != nil {
+		return fmt.Errorf("not logged in, run 'khinsider_downloader login' first")
+	}
+
+	albums, err := ParseFavorites(pageURL)
+	if err != nil {
+		return fmt.Errorf("loading favorites: %v", err)
+	}
+
+	var pending []string
+	for _, albumURL := range albums {
+		if opts.Update || !archiveContains(opts.DownloadArchive, albumURL) {
+			pending = append(pending, albumURL)
+		}
+	}
+	if opts.Update {
+		logf("Favorites: %d, checking all for new and replaced tracks\n", len(albums))
+	} else {
+		logf("Favorites: %d, already downloaded: %d\n", len(albums), len(albums)-len(pending))
+	}
+
+	failed := downloadBatch(pending, opts, func(_ int, albumURL string) error {
+		logf("\n=== Favorite: %s ===\n", albumURL)
+		result, err := downloadAlbum(albumURL, opts)
+		report.add(albumURL, result, err)
+		if err == nil && result.Failed > 0 {
+			err = fmt.Errorf("%d track(s) failed", result.Failed)
+		}
+		return err
+	})
+
+	if opts.EmailReport {
+		if err := sendEmailReport(); err != nil {
+			logf("Error sending the email report: %v\n", err)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d album(s) did not download completely", failed)
+	}
+	return nil
+}
+
+// ParseFavorites returns the album URLs on the account's favorites page,
+// following the pagination.
+func ParseFavorites(pageURL string) ([]string, error) {
+	var albums []string
+	seen := make(map[string]bool)
+	visited := make(map[string]bool)
+
+	for pageURL != "" && !visited[pageURL] {
+		visited[pageURL] = true
+
+		doc, err := fetchHTML(pageURL)
+		if err != nil {
+			return nil, err
+		}
+		base, _ := url.Parse(pageURL)
+
+		doc.Find(selectors().AlbumLinks).Each(func(i int, s *selection) {
+			href, _ := s.Attr("href")
+			ref, err := url.Parse(href)
+			if err != nil {
+				return
+			}
+			albumURL := normalizeAlbumURL(base.ResolveReference(ref).String())
+			if !seen[albumURL] {
+				seen[albumURL] = true
+				albums = append(albums, albumURL)
+			}
+		})
+
+		pageURL = ""
+		doc.Find("#pageContent a").EachWithBreak(func(i int, s *selection) bool {
+			if !strings.HasPrefix(strings.TrimSpace(s.Text()), "Next") {
+				return true
+			}
+			if href, ok := s.Attr("href"); ok {
+				if ref, err := url.Parse(href); err == nil {
+					pageURL = base.ResolveReference(ref).String()
+				}
+			}
+			return false
+		})
+	}
+
+	return albums, nil
+}
+
+func isLoggedIn() bool {
+	siteURL := &url.URL{Scheme: "https", Host: "downloads." + siteDomain, Path: "/"}
+	for _, c := range cookieJar().Cookies(siteURL) {
+		if c.Name == "xf_user" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// feedSize is how many of the latest downloads the feed lists.
+const feedSize = 50
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	ID      string   `xml:"id"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Summary string   `xml:"summary"`
+}
+
+// recentDownloads returns the completed queue items the user can see,
+// newest first.
+func (s *server) recentDownloads(user *serverUser) []queueItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var items []queueItem
+	for _, item := range s.items {
+		if item.Status == "completed" && item.visibleTo(user) {
+			items = append(items, *item)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Finished.After(items[j].Finished) })
+	if len(items) > feedSize {
+		items = items[:feedSize]
+	}
+	return items
+}
+
+func (item *queueItem) feedSummary() string {
+	summary := fmt.Sprintf("%d track(s) downloaded", item.Successful)
+	if item.Failed > 0 {
+		summary += fmt.Sprintf(", %d failed", item.Failed)
+	}
+	if item.SavedTo != "" {
+		summary += " to " + item.SavedTo
+	}
+	return summary
+}
+
+// handleFeed serves the albums the server finished downloading as RSS
+// (/feed.rss) or Atom (/feed.atom), for feed readers and other services.
+// Readers that can't send headers can pass the token as ?token=.
+func (s *server) handleFeed(w http.ResponseWriter, r *http.Request) {
+	items := s.recentDownloads(requestUser(r))
+	title := programName + " downloads"
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	self := scheme + "://" + r.Host + r.URL.Path
+
+	var feed any
+	if strings.HasSuffix(r.URL.Path, ".atom") {
+		updated := s.started
+		if len(items) > 0 {
+			updated = items[0].Finished
+		}
+		atom := atomFeed{Title: title, ID: self, Updated: updated.Format(time.RFC3339), Link: atomLink{Href: self}}
+		for _, item := range items {
+			atom.Entries = append(atom.Entries, atomEntry{
+				Title:   item.Album,
+				ID:      fmt.Sprintf("%s#%d-%d", self, s.started.Unix(), item.ID),
+				Updated: item.Finished.Format(time.RFC3339),
+				Link:    atomLink{Href: item.URL},
+				Summary: item.feedSummary(),
+			})
+		}
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		feed = atom
+	} else {
+		rss := rssFeed{Version: "2.0", Channel: rssChannel{Title: title, Link: self, Description: "Albums downloaded by the server"}}
+		for _, item := range items {
+			rss.Channel.Items = append(rss.Channel.Items, rssItem{
+				Title:       item.Album,
+				Link:        item.URL,
+				Description: item.feedSummary(),
+				GUID:        rssGUID{Value: fmt.Sprintf("%s#%d-%d", self, s.started.Unix(), item.ID)},
+				PubDate:     item.Finished.Format(time.RFC1123Z),
+			})
+		}
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		feed = rss
+	}
+
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(feed)
+}
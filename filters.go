@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseTrackDuration accepts "1:30", "90s", "2m" or plain seconds.
+func parseTrackDuration(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, ":") {
+		if seconds := convertToSeconds(s); seconds > 0 {
+			return seconds, nil
+		}
+		return 0, fmt.Errorf("invalid duration: %s", s)
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+		return n, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration: %s", s)
+	}
+	return int(d.Seconds()), nil
+}
+
+func formatTrackLength(seconds int) string {
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// parseTrackList parses track numbers like "1-10,12,15-" into a check for
+// whether a track is in the list. An open range goes to the last track.
+func parseTrackList(spec string) (func(int) bool, error) {
+	type trackRange struct{ from, to int } // to 0 for open ranges
+	var ranges []trackRange
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		from, to, isRange := strings.Cut(part, "-")
+		var r trackRange
+		var err error
+		if r.from, err = strconv.Atoi(strings.TrimSpace(from)); err != nil || r.from < 1 {
+			return nil, fmt.Errorf("invalid track list: %s", spec)
+		}
+		r.to = r.from
+		if isRange {
+			r.to = 0
+			if to = strings.TrimSpace(to); to != "" {
+				if r.to, err = strconv.Atoi(to); err != nil || r.to < r.from {
+					return nil, fmt.Errorf("invalid track range: %s", part)
+				}
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return func(track int) bool {
+		for _, r := range ranges {
+			if track >= r.from && (r.to == 0 || track <= r.to) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// trackExcluded returns why the track is left out by the filters, or "" to
+// download it. Tracks without a known length are never filtered by it.
+func trackExcluded(song *Song, track int, opts *Options) string {
+	if opts.Tracks != "" {
+		if selected, err := parseTrackList(opts.Tracks); err == nil && !selected(track) {
+			return "not in --tracks"
+		}
+	}
+	length := song.LengthSeconds
+	switch {
+	case length == 0:
+		return ""
+	case opts.MinDuration > 0 && length < opts.MinDuration:
+		return fmt.Sprintf("shorter than %s", formatTrackLength(opts.MinDuration))
+	case opts.MaxDuration > 0 && length > opts.MaxDuration:
+		return fmt.Sprintf("longer than %s", formatTrackLength(opts.MaxDuration))
+	}
+	return ""
+}
+
+// filterTrack is what --filter-exec gets on stdin for each track: the
+// track's album.json fields with the album's next to them.
+type filterTrack struct {
+	trackMetadata
+	Album     string         `json:"album"`
+	AlbumURL  string         `json:"album_source_url"`
+	Year      string         `json:"year,omitempty"`
+	Platforms []string       `json:"platforms,omitempty"`
+	AlbumType string         `json:"albumtype,omitempty"`
+	Sizes     map[string]int `json:"sizes_kb,omitempty"` // format -> size in the track list
+	Bitrate   int            `json:"bitrate,omitempty"`
+}
+
+// runTrackFilter asks the --filter-exec command whether to download a
+// track. Exit status 0 accepts it and 1 rejects it, with the first line of
+// the output as the reason. Any other result is an error, and the track is
+// kept rather than lost to a broken script.
+func runTrackFilter(command string, album *Album, song *Song, track int) (string, error) {
+	data, err := json.Marshal(filterTrack{
+		trackMetadata: trackMetadata{
+			Track:  track,
+			Title:  song.Name,
+			Length: song.LengthSeconds,
+			URL:    song.SongLink,
+			SizeKB: listedSizeKB(song),
+		},
+		Album:     album.Name,
+		AlbumURL:  album.AlbumLink,
+		Year:      album.Year,
+		Platforms: album.Platforms,
+		AlbumType: album.AlbumType,
+		Sizes:     song.Sizes,
+		Bitrate:   song.Bitrate,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return "", nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		reason, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+		if reason == "" {
+			reason = "rejected by --filter-exec"
+		}
+		return reason, nil
+	}
+	return "", err
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// songFormat is the format a song was saved in, by its file's extension,
+// or "" when it wasn't downloaded.
+func songFormat(song *Song) string {
+	if song.Filename == "" {
+		return ""
+	}
+	return strings.ToUpper(strings.TrimPrefix(filepath.Ext(song.Filename), "."))
+}
+
+// formatCounts counts the album's downloaded tracks by format.
+func formatCounts(album *Album) map[string]int {
+	counts := make(map[string]int)
+	for _, song := range album.Songs {
+		if format := songFormat(song); format != "" {
+			counts[format]++
+		}
+	}
+	return counts
+}
+
+// allLossless reports whether every downloaded track is in a lossless
+// format.
+func allLossless(counts map[string]int) bool {
+	for format := range counts {
+		if !isLossless(format) {
+			return false
+		}
+	}
+	return len(counts) > 0
+}
+
+// printFormatReport shows how many tracks were saved in each format and
+// which ones fell back from the wanted format.
+func printFormatReport(album *Album) {
+	counts := formatCounts(album)
+	if len(counts) == 0 {
+		return
+	}
+	formats := make([]string, 0, len(counts))
+	for format := range counts {
+		formats = append(formats, format)
+	}
+	slices.SortFunc(formats, func(a, b string) int { return counts[b] - counts[a] })
+
+	var parts []string
+	for _, format := range formats {
+		parts = append(parts, fmt.Sprintf("%s %d", format, counts[format]))
+	}
+	line := "Formats: " + strings.Join(parts, ", ")
+	switch {
+	case allLossless(counts):
+		line += " (all lossless)"
+	case slices.ContainsFunc(formats, isLossless):
+		line += " (not all lossless)"
+	default:
+		line += " (lossy only)"
+	}
+	logln(line)
+
+	for _, song := range album.Songs {
+		if song.Fallback && song.Filename != "" {
+			logln(colorize("fallback", fmt.Sprintf("  Fell back to %s: %s", songFormat(song), song.Name)))
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+var losslessFormats = []string{"FLAC", "ALAC", "WAV", "APE"}
+
+func isLossless(format string) bool {
+	return contains(losslessFormats, strings.ToUpper(format))
+}
+
+// chooseDownloadURL applies --prefer: among the formats of the same kind as
+// the requested one (lossy or lossless), it takes the smallest or largest by
+// the sizes in the track list. Without a preference or sizes it falls back
+// to the fixed format order of selectDownloadURL.
+func chooseDownloadURL(song *Song, opts *Options) (string, string) {
+	if opts.Prefer == "" {
+		return selectDownloadURL(song, opts.Format)
+	}
+
+	lossless := isLossless(opts.Format)
+	best := ""
+	for format := range song.DownloadLinks {
+		size, known := song.Sizes[format]
+		if !known || !contains(audioFormats, format) || isLossless(format) != lossless {
+			continue
+		}
+		switch {
+		case best == "",
+			opts.Prefer == "smallest" && size < song.Sizes[best],
+			opts.Prefer == "largest" && size > song.Sizes[best]:
+			best = format
+		}
+	}
+	if best == "" {
+		return selectDownloadURL(song, opts.Format)
+	}
+
+	note := ""
+	if best != strings.ToUpper(opts.Format) {
+		note = fmt.Sprintf("Using %s, the %s format (%s)", best, opts.Prefer, formatBytes(int64(song.Sizes[best])*1024))
+	}
+	return song.DownloadLinks[best], note
+}
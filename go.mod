@@ -2,9 +2,8 @@ module github.com/nalsai/khinsider_downloader
 
 go 1.25.5
 
-require github.com/PuerkitoBio/goquery v1.11.0
-
 require (
-	github.com/andybalholm/cascadia v1.3.3 // indirect
-	golang.org/x/net v0.47.0 // indirect
+	github.com/PuerkitoBio/goquery v1.11.0
+	github.com/andybalholm/cascadia v1.3.3
+	golang.org/x/net v0.47.0
 )
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The gRPC API, the Downloader service of api/downloader.proto. It is
+// served by hand on the REST server's mux instead of with grpc-go: unary
+// and server streaming calls over HTTP/2, without compression, which is all
+// the service needs.
+
+// gRPC status codes
+const (
+	grpcInvalidArgument    = 3
+	grpcNotFound           = 5
+	grpcPermissionDenied   = 7
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+)
+
+// grpcMaxMessage limits the size of a request, like grpc-go's default.
+const grpcMaxMessage = 4 << 20
+
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e *grpcError) Error() string {
+	return e.message
+}
+
+func (s *server) handleGRPC(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		writeJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": "gRPC calls need HTTP/2 and application/grpc"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.WriteHeader(http.StatusOK)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	req, err := readGRPCMessage(r.Body)
+	if err != nil {
+		finishGRPC(w, err)
+		return
+	}
+	user := requestUser(r)
+
+	var resp protoMessage
+	switch method := r.PathValue("method"); method {
+	case "WatchProgress":
+		finishGRPC(w, s.grpcWatchProgress(w, r, user))
+		return
+	case "ListQueue":
+		resp = s.grpcListQueue(user)
+	case "AddToQueue":
+		resp, err = s.grpcAddToQueue(req, user)
+	case "PauseItem", "ResumeItem", "RemoveItem":
+		resp, err = s.grpcItemAction(method, req, user)
+	case "ListLibrary":
+		resp, err = s.grpcListLibrary(user)
+	default:
+		err = &grpcError{grpcUnimplemented, "unknown method " + method}
+	}
+	if err == nil {
+		err = writeGRPCMessage(w, resp)
+	}
+	finishGRPC(w, err)
+}
+
+func (s *server) grpcListQueue(user *serverUser) protoMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var resp protoMessage
+	for _, item := range s.queueFor(user) {
+		resp.message(1, queueItemProto(item))
+	}
+	return resp
+}
+
+func (s *server) grpcAddToQueue(req []byte, user *serverUser) (protoMessage, error) {
+	var albumURL, priorityName string
+	err := parseProto(req, func(field int, _ uint64, b []byte) {
+		switch field {
+		case 1:
+			albumURL = string(b)
+		case 2:
+			priorityName = string(b)
+		}
+	})
+	if err != nil {
+		return nil, &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	if albumURL == "" {
+		return nil, &grpcError{grpcInvalidArgument, "missing url"}
+	}
+	priority, err := parsePriority(priorityName)
+	if err != nil {
+		return nil, &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	over, err := s.overQuota(user)
+	if err != nil {
+		return nil, err
+	}
+	if over {
+		return nil, &grpcError{grpcResourceExhausted, user.quotaError().Error()}
+	}
+
+	item := s.add(albumURL, priority, 0, user)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return queueItemProto(item), nil
+}
+
+func (s *server) grpcItemAction(method string, req []byte, user *serverUser) (protoMessage, error) {
+	var id uint64
+	err := parseProto(req, func(field int, v uint64, _ []byte) {
+		if field == 1 {
+			id = v
+		}
+	})
+	if err != nil {
+		return nil, &grpcError{grpcInvalidArgument, err.Error()}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item := s.itemByID(int(id), user)
+	if item == nil {
+		return nil, &grpcError{grpcNotFound, "no such queue item"}
+	}
+	switch method {
+	case "PauseItem":
+		err = s.pauseItem(item)
+	case "ResumeItem":
+		s.resumeItem(item)
+	case "RemoveItem":
+		err = s.removeItem(item)
+	}
+	if err != nil {
+		return nil, &grpcError{grpcFailedPrecondition, err.Error()}
+	}
+	return queueItemProto(item), nil
+}
+
+// grpcWatchProgress streams the events the user may see, like /api/events.
+func (s *server) grpcWatchProgress(w http.ResponseWriter, r *http.Request, user *serverUser) error {
+	events := s.events.subscribe(user)
+	defer s.events.unsubscribe(events)
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case ev := <-events:
+			if err := writeGRPCMessage(w, progressEventProto(ev)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *server) grpcListLibrary(user *serverUser) (protoMessage, error) {
+	if user != nil {
+		return nil, &grpcError{grpcPermissionDenied, "needs the server's --token or --basic-auth credentials"}
+	}
+	if s.opts.Library == "" {
+		return nil, &grpcError{grpcFailedPrecondition, "the server has no --library"}
+	}
+	lib, err := openLibrary(s.opts.Library)
+	if err != nil {
+		return nil, err
+	}
+
+	lib.mu.Lock()
+	defer lib.mu.Unlock()
+	var resp protoMessage
+	for _, e := range lib.Entries {
+		var album protoMessage
+		album.string(1, e.URL)
+		album.string(2, e.Album)
+		album.string(3, lib.abs(&e))
+		album.string(4, grpcTime(e.Added))
+		album.bool(5, e.Imported)
+		if e.Archive != "" {
+			album.string(6, lib.absPath(e.Archive))
+		}
+		resp.message(1, album)
+	}
+	return resp, nil
+}
+
+func queueItemProto(item *queueItem) protoMessage {
+	var m protoMessage
+	m.int(1, int64(item.ID))
+	m.string(2, item.URL)
+	m.string(3, item.Status)
+	m.string(4, item.Album)
+	m.int(5, int64(item.Successful))
+	m.int(6, int64(item.Failed))
+	m.string(7, item.Error)
+	m.string(8, grpcTime(item.Added))
+	m.string(9, item.User)
+	m.string(10, grpcTime(item.Finished))
+	m.string(11, item.SavedTo)
+	m.string(12, item.Code)
+	m.string(13, item.Priority)
+	m.string(14, grpcTime(item.Pinned))
+	return m
+}
+
+func progressEventProto(ev progressEvent) protoMessage {
+	var m protoMessage
+	m.string(1, ev.Event)
+	m.string(2, grpcTime(ev.Time))
+	m.string(3, ev.Album)
+	m.string(4, ev.Track)
+	m.int(5, int64(ev.Index))
+	m.int(6, int64(ev.Total))
+	m.string(7, ev.File)
+	m.int(8, ev.Bytes)
+	m.int(9, ev.Size)
+	m.double(10, ev.Percent)
+	m.string(11, ev.Error)
+	m.int(12, int64(ev.Successful))
+	m.int(13, int64(ev.Failed))
+	m.string(14, ev.Code)
+	return m
+}
+
+func grpcTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// readGRPCMessage reads the request message: a compression flag and the
+// length, then the protobuf bytes.
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil // an empty request
+		}
+		return nil, &grpcError{grpcInvalidArgument, "reading the request: " + err.Error()}
+	}
+	if header[0] != 0 {
+		return nil, &grpcError{grpcUnimplemented, "compressed messages are not supported"}
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > grpcMaxMessage {
+		return nil, &grpcError{grpcResourceExhausted, fmt.Sprintf("request of %d bytes is too big", size)}
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, &grpcError{grpcInvalidArgument, "reading the request: " + err.Error()}
+	}
+	return msg, nil
+}
+
+func writeGRPCMessage(w http.ResponseWriter, msg protoMessage) error {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	if _, err := w.Write(append(frame, msg...)); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// finishGRPC ends the call with its status in the trailers.
+func finishGRPC(w http.ResponseWriter, err error) {
+	code, message := 0, ""
+	if err != nil {
+		var ge *grpcError
+		if !errors.As(err, &ge) {
+			ge = &grpcError{grpcInternal, err.Error()}
+		}
+		code, message = ge.code, ge.message
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", percentEncode(message))
+	}
+}
+
+// percentEncode escapes grpc-message the way gRPC wants it.
+func percentEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
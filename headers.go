@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36"
+	siteReferer      = "https://downloads.khinsider.com/"
+)
+
+// Headers sent with every request, set from --user-agent, --referer and
+// --header.
+var (
+	userAgent     = defaultUserAgent
+	refererHeader = ""
+	extraHeaders  = make(http.Header)
+)
+
+// setRequestHeaders applies the configured headers to req. The referer
+// given here is used unless one was set on the command line.
+func setRequestHeaders(req *http.Request, referer string) {
+	req.Header.Set("User-Agent", userAgent)
+
+	if refererHeader != "" {
+		referer = refererHeader
+	}
+	if referer != "" {
+		req.Header.Set("Referer", referer)
+	}
+
+	for name, values := range extraHeaders {
+		req.Header.Del(name)
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+}
+
+func addExtraHeader(header string) error {
+	name, value, ok := strings.Cut(header, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return fmt.Errorf("invalid header %q, expected 'Name: value'", header)
+	}
+	extraHeaders.Add(name, strings.TrimSpace(value))
+	return nil
+}
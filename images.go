@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// imageWorkers is how many album images download at once
+const imageWorkers = 4
+
+// Kinds of album scans, told apart by their caption or file name.
+const (
+	imageFront   = "front"
+	imageBack    = "back"
+	imageDisc    = "disc"
+	imageInlay   = "inlay"
+	imageBooklet = "booklet"
+	imageOther   = "other"
+)
+
+// imageTypeWords are checked in order, so "back cover" is a back and
+// "booklet cover" a booklet page.
+var imageTypeWords = []struct {
+	kind  string
+	words []string
+}{
+	{imageBack, []string{"back", "rear", "backcover"}},
+	{imageDisc, []string{"disc", "disk", "cd", "dvd", "label", "discs", "cds"}},
+	{imageInlay, []string{"inlay", "tray", "inside", "spine", "obi", "traycard"}},
+	{imageBooklet, []string{"booklet", "book", "page", "pages", "insert", "liner", "scan"}},
+	{imageFront, []string{"front", "cover", "folder", "jacket", "frontcover"}},
+}
+
+// classifyImage guesses the kind of scan from its caption and file name,
+// empty if nothing says.
+func classifyImage(imageURL, caption string) string {
+	name := imageURL
+	if u, err := url.Parse(imageURL); err == nil {
+		name = path.Base(u.Path)
+	}
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		name = unescaped
+	}
+	name = strings.TrimSuffix(name, path.Ext(name))
+
+	words := strings.FieldsFunc(strings.ToLower(caption+" "+name), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, t := range imageTypeWords {
+		for _, w := range words {
+			if contains(t.words, w) {
+				return t.kind
+			}
+		}
+	}
+	return ""
+}
+
+// classifyImages sets the kind of every album image. Without an image that
+// says it is the front cover, the first one is taken for it, as the site
+// lists the cover first.
+func classifyImages(album *Album, captions map[string]string) {
+	album.ImageTypes = make(map[string]string, len(album.AlbumImages))
+	front := false
+	for _, img := range album.AlbumImages {
+		kind := classifyImage(img, captions[img])
+		front = front || kind == imageFront
+		album.ImageTypes[img] = kind
+	}
+	for i, img := range album.AlbumImages {
+		switch {
+		case !front && i == 0:
+			album.ImageTypes[img] = imageFront
+		case album.ImageTypes[img] == "":
+			album.ImageTypes[img] = imageOther
+		}
+	}
+}
+
+// frontCover returns the front cover's URL, empty if the album has no
+// images.
+func (album *Album) frontCover() string {
+	for _, img := range album.AlbumImages {
+		if album.ImageTypes[img] == imageFront {
+			return img
+		}
+	}
+	if len(album.AlbumImages) > 0 {
+		return album.AlbumImages[0]
+	}
+	return ""
+}
+
+// imageSummary counts the kinds of images, e.g. " (1 front, 1 back, 12
+// booklet)".
+func imageSummary(album *Album) string {
+	counts := make(map[string]int)
+	for _, img := range album.AlbumImages {
+		counts[album.ImageTypes[img]]++
+	}
+	var parts []string
+	for _, kind := range []string{imageFront, imageBack, imageDisc, imageInlay, imageBooklet, imageOther} {
+		if counts[kind] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[kind], kind))
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(parts, ", ") + ")"
+}
+
+// downloadImage downloads an album image like a track, with retries and
+// resuming, then checks that the file is a non-empty image. A scan page
+// that answers with nothing or an error page is downloaded again.
+func downloadImage(imgURL, imagePath string) error {
+	var err error
+	for attempt := 1; attempt <= 3; attempt++ {
+		if attempt > 1 {
+			time.Sleep(time.Duration(attempt-1) * time.Second)
+		}
+		if err = downloadFile(imgURL, imagePath, 3, nil, nil); err != nil {
+			return err
+		}
+		if err = verifyImage(imagePath); err == nil {
+			return nil
+		}
+		os.Remove(imagePath)
+		logf("  %s: %v, downloading again\n", filepath.Base(imagePath), err)
+	}
+	return err
+}
+
+// verifyImage checks a downloaded image by its first bytes.
+func verifyImage(imagePath string) error {
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if n == 0 {
+		return fmt.Errorf("empty file")
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	// Formats the sniffer doesn't know come back as octet-stream, while
+	// error pages are text
+	if kind := http.DetectContentType(head[:n]); strings.HasPrefix(kind, "text/") {
+		return fmt.Errorf("not an image (%s)", kind)
+	}
+	return nil
+}
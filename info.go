@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runInfo prints an album's metadata without downloading it. With
+// --from-file it parses a saved album page instead of fetching one, which
+// also regenerates album.json for an existing download.
+func runInfo(args []string) error {
+	fromFile := ""
+	asJSON := false
+	verbose := false
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--from-file" && i+1 < len(args):
+			fromFile = args[i+1]
+			i++
+		case args[i] == "--json":
+			asJSON = true
+		case args[i] == "--verbose":
+			verbose = true
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+
+	opts, positional, err := parseOptions(rest)
+	if err != nil {
+		return err
+	}
+
+	var album *Album
+	switch {
+	case fromFile != "":
+		album, err = parseAlbumFile(fromFile)
+	case len(positional) == 1:
+		var albumURL string
+		if albumURL, err = resolveAlbumURL(positional[0]); err == nil {
+			album, err = ParseAlbumPage(albumURL)
+		}
+	default:
+		return fmt.Errorf("usage: khinsider_downloader info <album_url> | --from-file <page.html> [--json] [--verbose]")
+	}
+	if err != nil {
+		return err
+	}
+
+	if opts.WriteMetadata && fromFile != "" {
+		dir := filepath.Dir(fromFile)
+		matchDownloadedFiles(album, dir)
+		if err := writeAlbumMetadata(album, opts.TagMap, dir); err != nil {
+			return err
+		}
+		logf("Wrote %s\n", filepath.Join(dir, metadataFilename))
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(albumMetadataFor(album, opts.TagMap))
+	}
+
+	fmt.Printf("Album: %s\n", album.Name)
+	for _, field := range [][2]string{
+		{"URL", album.AlbumLink},
+		{"Platforms", strings.Join(album.Platforms, ", ")},
+		{"Year", album.Year},
+		{"Catalog number", album.CatalogNumber},
+		{"Published by", album.Publisher},
+		{"Album type", album.AlbumType},
+		{"Rating", formatRating(album)},
+	} {
+		if field[1] != "" {
+			fmt.Printf("%s: %s\n", field[0], field[1])
+		}
+	}
+	if album.Downloads > 0 {
+		fmt.Printf("Downloads: %d\n", album.Downloads)
+	}
+	var sizes []string
+	for _, format := range album.Formats {
+		var total int64
+		for _, song := range album.Songs {
+			total += int64(song.Sizes[format]) * 1024
+		}
+		if total > 0 {
+			sizes = append(sizes, format+" "+formatBytes(total))
+		}
+	}
+	if len(sizes) > 0 {
+		fmt.Printf("Size: %s\n", strings.Join(sizes, ", "))
+	}
+	fmt.Printf("Images: %d%s\n", len(album.AlbumImages), imageSummary(album))
+	fmt.Printf("Songs: %d\n", len(album.Songs))
+	for i, song := range album.Songs {
+		line := fmt.Sprintf("  %3d. %s (%d:%02d)", i+1, song.Name, song.LengthSeconds/60, song.LengthSeconds%60)
+		if verbose {
+			// The audio properties are only on the song pages
+			if err := ParseDownloadLinks(song); err != nil {
+				logf("Warning: %s: %v\n", song.Name, err)
+			}
+			if props := songProperties(song); props != "" {
+				line += " - " + props
+			}
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// parseAlbumFile parses a saved album page, e.g. the album.html written by
+// --save-page.
+func parseAlbumFile(path string) (*Album, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	album, err := parseAlbumHTML("", body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Saved pages don't know their URL, but the page usually names it
+	if doc, err := newDocument(bytes.NewReader(body)); err == nil {
+		for _, selector := range []string{"link[rel='canonical']", "meta[property='og:url']"} {
+			sel := doc.Find(selector).First()
+			if href, ok := sel.Attr("href"); ok {
+				album.AlbumLink = href
+				break
+			}
+			if content, ok := sel.Attr("content"); ok {
+				album.AlbumLink = content
+				break
+			}
+		}
+	}
+	return album, nil
+}
+
+// matchDownloadedFiles fills in the file names of tracks already in dir,
+// matching them by the name in the song link.
+func matchDownloadedFiles(album *Album, dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	byBase := make(map[string]string)
+	for _, e := range entries {
+		if !e.IsDir() {
+			byBase[strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))] = e.Name()
+		}
+	}
+	for _, song := range album.Songs {
+		base := filepath.Base(song.SongLink)
+		if unescaped, err := url.PathUnescape(base); err == nil {
+			base = unescaped
+		}
+		if name, ok := byBase[strings.TrimSuffix(base, filepath.Ext(base))]; ok {
+			song.Filename = name
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// readAlbumURLs reads one album URL per line, skipping blank lines and
+// # comments. Lines keep the album's own options after a |, see
+// splitBatchLine.
+func readAlbumURLs(r io.Reader) ([]string, error) {
+	var urls []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, scanner.Err()
+}
+
+// clipboardURLs returns the URLs currently on the system clipboard.
+func clipboardURLs() ([]string, error) {
+	var candidates [][]string
+	switch runtime.GOOS {
+	case "darwin":
+		candidates = [][]string{{"pbpaste"}}
+	case "windows":
+		candidates = [][]string{{"powershell", "-NoProfile", "-Command", "Get-Clipboard"}}
+	default:
+		candidates = [][]string{
+			{"wl-paste", "--no-newline"},
+			{"xclip", "-selection", "clipboard", "-o"},
+			{"xsel", "--clipboard", "--output"},
+		}
+	}
+
+	var tried []string
+	for _, c := range candidates {
+		tried = append(tried, c[0])
+		if _, err := exec.LookPath(c[0]); err != nil {
+			continue
+		}
+		out, err := exec.Command(c[0], c[1:]...).Output()
+		if err != nil {
+			return nil, fmt.Errorf("reading clipboard with %s: %v", c[0], err)
+		}
+		urls, _ := readAlbumURLs(strings.NewReader(string(out)))
+		if len(urls) == 0 {
+			return nil, fmt.Errorf("clipboard is empty")
+		}
+		return urls, nil
+	}
+	return nil, fmt.Errorf("no clipboard tool found (tried %s)", strings.Join(tried, ", "))
+}
+
+// inputURLs resolves the album URLs to download: the positional arguments,
+// the clipboard with --clipboard, or stdin when given "-" or piped in.
+func inputURLs(args []string, opts *Options) ([]string, error) {
+	switch {
+	case opts.Clipboard:
+		return clipboardURLs()
+	case len(args) == 1 && args[0] == "-", len(args) == 0 && !isTerminal(os.Stdin):
+		return readAlbumURLs(os.Stdin)
+	}
+	return args, nil
+}
+
+const albumURLPrefix = "https://downloads.khinsider.com/game-soundtracks/album/"
+
+// resolvePageURL turns an album slug ("chrono-trigger-original-sound-version")
+// or a "khinsider:<slug>" shorthand into the full album URL. URLs in an older
+// format are resolved by following the site's redirects.
+func resolvePageURL(input string) (string, error) {
+	input = strings.TrimSpace(input)
+	if slug, ok := strings.CutPrefix(input, "khinsider:"); ok {
+		input = strings.Trim(slug, "/")
+	}
+	if !strings.Contains(input, "/") {
+		if input == "" {
+			return "", fmt.Errorf("empty album")
+		}
+		return albumURLPrefix + url.PathEscape(input), nil
+	}
+	if !strings.Contains(input, "://") {
+		input = "https://" + input
+	}
+
+	u, err := url.Parse(input)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(u.Hostname(), siteDomain) || classifyURL(input) != unknownPage {
+		return input, nil
+	}
+	return followRedirects(input)
+}
+
+// followRedirects returns the URL a page ends up at.
+func followRedirects(pageURL string) (string, error) {
+	if err := politeWait(pageURL); err != nil {
+		return "", err
+	}
+
+	client := siteClient()
+	req, err := http.NewRequest("GET", pageURL, nil)
+	if err != nil {
+		return "", err
+	}
+	setRequestHeaders(req, "")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	metrics.countResponse(resp.StatusCode)
+	return resp.Request.URL.String(), nil
+}
+
+type pageKind int
+
+const (
+	unknownPage pageKind = iota
+	albumPage
+	songPage
+	searchPage
+)
+
+// classifyURL tells khinsider album, song and search pages apart.
+func classifyURL(pageURL string) pageKind {
+	u, err := url.Parse(pageURL)
+	if err != nil || !strings.HasSuffix(u.Hostname(), siteDomain) {
+		return unknownPage
+	}
+	if strings.TrimSuffix(u.Path, "/") == "/search" && u.Query().Get("search") != "" {
+		return searchPage
+	}
+
+	rest, ok := strings.CutPrefix(u.Path, "/game-soundtracks/album/")
+	rest = strings.Trim(rest, "/")
+	switch {
+	case !ok || rest == "":
+		return unknownPage
+	case strings.Contains(rest, "/"):
+		return songPage
+	}
+	return albumPage
+}
+
+func notAnAlbumError(input string) error {
+	return newClassError(errInvalidInput, fmt.Sprintf("%s is not a khinsider album page, expected %s<album-name>", input, albumURLPrefix))
+}
+
+// downloadInput downloads whatever the URL points to: an album, a single
+// track, or for a search page it lists the albums found. The result is only
+// set for albums.
+func downloadInput(input string, opts *Options) (*AlbumResult, error) {
+	if providerFor(input) != khinsider {
+		return downloadAlbum(input, opts)
+	}
+
+	pageURL, err := resolvePageURL(input)
+	if err != nil {
+		logf("Error resolving album URL: %v\n", err)
+		return nil, err
+	}
+
+	switch classifyURL(pageURL) {
+	case albumPage:
+		return downloadAlbum(pageURL, opts)
+	case songPage:
+		return nil, downloadSong(pageURL, opts)
+	case searchPage:
+		return nil, printSearchResults(pageURL)
+	}
+
+	err = notAnAlbumError(input)
+	logf("Error: %v\n", err)
+	return nil, err
+}
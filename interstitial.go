@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Some file hosts answer the direct link with a "click here to continue"
+// page (anti-hotlinking) that sets a cookie or adds a token to the real
+// link. Such pages are read for the link, which is then downloaded instead.
+
+// maxInterstitialHops is how many such pages in a row are followed.
+const maxInterstitialHops = 3
+
+// maxInterstitialSize is how much of a page is read looking for the link.
+const maxInterstitialSize = 512 << 10
+
+type interstitialError struct {
+	Page string // the URL that answered with a page
+	Next string // the real link, empty if none was found
+}
+
+func (e *interstitialError) Error() string {
+	if e.Next == "" {
+		return fmt.Sprintf("got a web page instead of the file from %s", e.Page)
+	}
+	return fmt.Sprintf("got a web page instead of the file from %s, it links %s", e.Page, e.Next)
+}
+
+// Is makes an interstitial without a usable link a parse error.
+func (e *interstitialError) Is(target error) bool {
+	return target == errParse && e.Next == ""
+}
+
+// isHTMLResponse tells if a response for a file is a web page.
+func isHTMLResponse(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// readInterstitial reads a page served instead of a file for the link to
+// the file.
+func readInterstitial(resp *http.Response) error {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxInterstitialSize))
+	if err != nil {
+		return err
+	}
+	return &interstitialError{Page: resp.Request.URL.String(), Next: interstitialLink(resp.Request.URL, body)}
+}
+
+var (
+	refreshURL       = regexp.MustCompile(`(?i)url\s*=\s*['"]?([^'"\s]+)`)
+	scriptLocation   = regexp.MustCompile(`(?:window\.|document\.)?location(?:\.href)?\s*=\s*['"]([^'"]+)['"]`)
+	continueLinkText = []string{"continue", "click here", "download", "proceed"}
+)
+
+// interstitialLink looks for the real file link on an interstitial page: a
+// meta refresh or script redirect, a link to the same file name, a link
+// saying "continue" or the like, or a GET form with the token as a field.
+func interstitialLink(page *url.URL, body []byte) string {
+	resolve := func(href string) string {
+		ref, err := url.Parse(strings.TrimSpace(href))
+		if err != nil || href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+			return ""
+		}
+		next := page.ResolveReference(ref)
+		if next.Scheme != "http" && next.Scheme != "https" || next.String() == page.String() {
+			return ""
+		}
+		return next.String()
+	}
+
+	doc, err := newDocument(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	var next string
+	doc.Find("meta").EachWithBreak(func(i int, s *selection) bool {
+		if equiv, _ := s.Attr("http-equiv"); strings.EqualFold(equiv, "refresh") {
+			content, _ := s.Attr("content")
+			if m := refreshURL.FindStringSubmatch(content); m != nil {
+				next = resolve(m[1])
+			}
+		}
+		return next == ""
+	})
+	if next != "" {
+		return next
+	}
+
+	// A link to the file asked for, e.g. with a token in the query
+	fileName := path.Base(page.Path)
+	var continueLink string
+	doc.Find("a").EachWithBreak(func(i int, s *selection) bool {
+		href, _ := s.Attr("href")
+		link := resolve(href)
+		if link == "" {
+			return true
+		}
+		if u, err := url.Parse(link); err == nil && fileName != "/" && path.Base(u.Path) == fileName {
+			next = link
+			return false
+		}
+		text := strings.ToLower(s.Text())
+		for _, word := range continueLinkText {
+			if continueLink == "" && strings.Contains(text, word) {
+				continueLink = link
+			}
+		}
+		return true
+	})
+	if next != "" {
+		return next
+	}
+	if continueLink != "" {
+		return continueLink
+	}
+
+	doc.Find("form").EachWithBreak(func(i int, form *selection) bool {
+		if method, _ := form.Attr("method"); method != "" && !strings.EqualFold(method, "get") {
+			return true
+		}
+		// The action is often the page itself, with the token added
+		action, _ := form.Attr("action")
+		ref, err := url.Parse(strings.TrimSpace(action))
+		if err != nil {
+			return true
+		}
+		u := page.ResolveReference(ref)
+		query := u.Query()
+		form.Find("input").Each(func(i int, input *selection) {
+			if name, ok := input.Attr("name"); ok && name != "" {
+				value, _ := input.Attr("value")
+				query.Set(name, value)
+			}
+		})
+		u.RawQuery = query.Encode()
+		if u.String() != page.String() {
+			next = u.String()
+		}
+		return next == ""
+	})
+	if next != "" {
+		return next
+	}
+
+	for _, script := range scriptLocation.FindAllSubmatch(body, -1) {
+		if next = resolve(string(script[1])); next != "" {
+			return next
+		}
+	}
+	return ""
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Secrets are kept in the OS keyring (Keychain on macOS, the Secret Service
+// via secret-tool on Linux, the Credential Manager on Windows) under the
+// program's name as service.
+
+var (
+	errNoKeyring    = errors.New("no keyring available")
+	errNotInKeyring = errors.New("not in the keyring")
+)
+
+// keyringDisabled is set by --no-keyring.
+var keyringDisabled bool
+
+// keyringPrefix marks option and config values that name a keyring entry,
+// like --telegram-token keyring:telegram.
+const keyringPrefix = "keyring:"
+
+func keyringEnabled() bool {
+	if keyringDisabled {
+		return false
+	}
+	off, _ := strconv.ParseBool(os.Getenv(envName("--no-keyring")))
+	return !off
+}
+
+// keyringCommand returns the command that runs a keyring action: get, set
+// (the secret on stdin) or delete.
+func keyringCommand(action, name string) (*exec.Cmd, error) {
+	if !keyringEnabled() {
+		return nil, errNoKeyring
+	}
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		switch action {
+		case "get":
+			cmd = exec.Command("security", "find-generic-password", "-s", programName, "-a", name, "-w")
+		case "set":
+			// Commands on stdin keep the secret out of the process list
+			cmd = exec.Command("security", "-i")
+		case "delete":
+			cmd = exec.Command("security", "delete-generic-password", "-s", programName, "-a", name)
+		}
+	case "windows":
+		vault := "[void][Windows.Security.Credentials.PasswordVault,Windows.Security.Credentials,ContentType=WindowsRuntime]; $v = New-Object Windows.Security.Credentials.PasswordVault; "
+		name := strings.ReplaceAll(name, "'", "''")
+		var script string
+		switch action {
+		case "get":
+			script = fmt.Sprintf("try { $c = $v.Retrieve('%s', '%s') } catch { exit 44 }; $c.RetrievePassword(); [Console]::Out.Write($c.Password)", programName, name)
+		case "set":
+			script = fmt.Sprintf("$s = [Console]::In.ReadToEnd(); $v.Add((New-Object Windows.Security.Credentials.PasswordCredential('%s', '%s', $s)))", programName, name)
+		case "delete":
+			script = fmt.Sprintf("try { $v.Remove($v.Retrieve('%s', '%s')) } catch { exit 44 }", programName, name)
+		}
+		cmd = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", vault+script)
+	default:
+		switch action {
+		case "get":
+			cmd = exec.Command("secret-tool", "lookup", "service", programName, "account", name)
+		case "set":
+			cmd = exec.Command("secret-tool", "store", "--label", programName+" "+name, "service", programName, "account", name)
+		case "delete":
+			cmd = exec.Command("secret-tool", "clear", "service", programName, "account", name)
+		}
+	}
+
+	if _, err := exec.LookPath(cmd.Path); err != nil {
+		return nil, errNoKeyring
+	}
+	return cmd, nil
+}
+
+func keyringGet(name string) (string, error) {
+	cmd, err := keyringCommand("get", name)
+	if err != nil {
+		return "", err
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		// security and the PowerShell script exit with 44 for a missing
+		// entry, secret-tool with 1
+		secretTool := runtime.GOOS != "darwin" && runtime.GOOS != "windows"
+		if isExitCode(err, 44) || secretTool && isExitCode(err, 1) {
+			return "", errNotInKeyring
+		}
+		return "", fmt.Errorf("reading %s from the keyring: %v", name, err)
+	}
+	secret := strings.TrimRight(string(out), "\r\n")
+	if secret == "" {
+		return "", errNotInKeyring
+	}
+	return secret, nil
+}
+
+func keyringSet(name, secret string) error {
+	cmd, err := keyringCommand("set", name)
+	if err != nil {
+		return err
+	}
+	if runtime.GOOS == "darwin" {
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -X %s\n", programName, strconv.Quote(name), hex.EncodeToString([]byte(secret))))
+	} else {
+		cmd.Stdin = strings.NewReader(secret)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("saving %s to the keyring: %v %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func keyringDelete(name string) error {
+	cmd, err := keyringCommand("delete", name)
+	if err != nil {
+		return err
+	}
+	if err := cmd.Run(); err != nil {
+		if isExitCode(err, 44) {
+			return errNotInKeyring
+		}
+		return fmt.Errorf("removing %s from the keyring: %v", name, err)
+	}
+	return nil
+}
+
+func isExitCode(err error, code int) bool {
+	var exitErr *exec.ExitError
+	return errors.As(err, &exitErr) && exitErr.ExitCode() == code
+}
+
+// resolveSecret returns the keyring entry for "keyring:<name>" values and
+// any other value as it is.
+func resolveSecret(value string) (string, error) {
+	name, ok := strings.CutPrefix(value, keyringPrefix)
+	if !ok {
+		return value, nil
+	}
+	secret, err := keyringGet(name)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", value, err)
+	}
+	return secret, nil
+}
+
+// Login credentials are kept in one entry so a session can be renewed
+// without asking for the password again.
+const loginKeyringName = "login"
+
+type savedLogin struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func saveLogin(username, password string) error {
+	data, err := json.Marshal(savedLogin{username, password})
+	if err != nil {
+		return err
+	}
+	return keyringSet(loginKeyringName, string(data))
+}
+
+func loadLogin() (*savedLogin, error) {
+	secret, err := keyringGet(loginKeyringName)
+	if err != nil {
+		return nil, err
+	}
+	var saved savedLogin
+	if err := json.Unmarshal([]byte(secret), &saved); err != nil {
+		return nil, fmt.Errorf("reading the saved login: %v", err)
+	}
+	return &saved, nil
+}
+
+// runKeyring stores and removes the secrets referenced as keyring:<name>.
+func runKeyring(args []string) error {
+	if len(args) != 2 || (args[0] != "set" && args[0] != "delete") {
+		return fmt.Errorf("usage: khinsider_downloader keyring set|delete <name>")
+	}
+	name := args[1]
+
+	if args[0] == "delete" {
+		if err := keyringDelete(name); err != nil {
+			return err
+		}
+		fmt.Printf("Removed %s from the keyring\n", name)
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Secret for %s: ", name)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return fmt.Errorf("reading the secret: %v", err)
+	}
+	secret := strings.TrimRight(line, "\r\n")
+	if secret == "" {
+		return fmt.Errorf("empty secret")
+	}
+	if err := keyringSet(name, secret); err != nil {
+		return err
+	}
+	fmt.Printf("Saved %s to the keyring, use it as %s%s\n", name, keyringPrefix, name)
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+)
+
+// khinsiderProvider reads downloads.khinsider.com, the site this tool was
+// written for.
+type khinsiderProvider struct{}
+
+func (khinsiderProvider) Name() string { return "khinsider" }
+
+func (khinsiderProvider) Hosts() []string { return []string{siteDomain} }
+
+func (khinsiderProvider) ResolveAlbum(input string) (string, error) {
+	albumURL, err := resolvePageURL(input)
+	if err != nil {
+		return "", err
+	}
+	if classifyURL(albumURL) != albumPage {
+		return "", notAnAlbumError(albumURL)
+	}
+	return albumURL, nil
+}
+
+func (khinsiderProvider) ListTracks(albumURL string) (*Album, error) {
+	body, err := fetchPage(albumURL)
+	if err != nil {
+		return nil, err
+	}
+	album, err := parseAlbumHTML(albumURL, body)
+	if err == nil && len(album.Songs) == 0 {
+		dumpDebugHTML(albumURL, body, albumPageSelectors())
+	}
+	return album, err
+}
+
+func (khinsiderProvider) ResolveDownload(song *Song) error {
+	body, err := fetchPage(song.SongLink)
+	if err != nil {
+		return err
+	}
+	song.page = body
+
+	doc, err := newDocument(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	// Find download links
+	doc.Find(selectors().DownloadLinks).Each(func(i int, s *selection) {
+		href, exists := s.Attr("href")
+		if !exists || !strings.HasPrefix(href, "https://") {
+			return
+		}
+
+		// Extract format from URL
+		ext := strings.ToUpper(filepath.Ext(href))
+		if len(ext) > 1 {
+			ext = ext[1:] // Remove the dot
+			song.DownloadLinks[ext] = href
+		}
+	})
+	if len(song.DownloadLinks) == 0 {
+		dumpDebugHTML(song.SongLink, body, songPageSelectors())
+	}
+	parseSongProperties(song, doc.Find(selectors().AlbumText).Text())
+
+	return nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A library is one root folder that all runs download into, unlike
+// --output-dir which can differ per run. Its albums are listed in
+// library.json at the root, which is checked before downloading so an album
+// is only kept once however it was asked for, and new albums are placed by
+// the layout.
+
+const (
+	libraryFilename      = "library.json"
+	defaultLibraryLayout = "{album}"
+)
+
+type libraryEntry struct {
+	URL      string    `json:"url,omitempty"` // missing for imported rips of unknown source
+	Album    string    `json:"album"`
+	Path     string    `json:"path"`              // relative to the root, or absolute outside of it
+	Archive  string    `json:"archive,omitempty"` // made with --archive, like path
+	Added    time.Time `json:"added"`
+	Imported bool      `json:"imported,omitempty"` // registered by library import
+}
+
+type library struct {
+	mu      sync.Mutex
+	root    string
+	Entries []libraryEntry `json:"albums"`
+}
+
+var (
+	librariesMu sync.Mutex
+	libraries   = make(map[string]*library)
+)
+
+// openLibrary returns the library at root, read once per run.
+func openLibrary(root string) (*library, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	librariesMu.Lock()
+	defer librariesMu.Unlock()
+	if lib, ok := libraries[root]; ok {
+		return lib, nil
+	}
+
+	lib := &library{root: root}
+	data, err := os.ReadFile(filepath.Join(root, libraryFilename))
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, lib); err != nil {
+			return nil, fmt.Errorf("reading %s: %v", filepath.Join(root, libraryFilename), err)
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+	libraries[root] = lib
+	return lib, nil
+}
+
+// find returns the album with this URL, or an imported album of unknown
+// source with the same name.
+func (lib *library) find(albumURL, name string) *libraryEntry {
+	lib.mu.Lock()
+	defer lib.mu.Unlock()
+	albumURL = normalizeAlbumURL(albumURL)
+	for i, e := range lib.Entries {
+		if albumURL != "" && e.URL != "" && normalizeAlbumURL(e.URL) == albumURL {
+			return &lib.Entries[i]
+		}
+	}
+	if name == "" {
+		return nil
+	}
+	for i, e := range lib.Entries {
+		if e.URL == "" && strings.EqualFold(e.Album, name) {
+			return &lib.Entries[i]
+		}
+	}
+	return nil
+}
+
+// add records an album, replacing an entry for the same URL or path, and
+// saves the library.
+func (lib *library) add(entry libraryEntry) error {
+	lib.mu.Lock()
+	defer lib.mu.Unlock()
+	if entry.Added.IsZero() {
+		entry.Added = time.Now()
+	}
+	entry.Path = lib.rel(entry.Path)
+	if entry.Archive != "" {
+		entry.Archive = lib.rel(entry.Archive)
+	}
+	for i, e := range lib.Entries {
+		if (entry.URL != "" && normalizeAlbumURL(e.URL) == normalizeAlbumURL(entry.URL)) || e.Path == entry.Path {
+			lib.Entries[i] = entry
+			return lib.save()
+		}
+	}
+	lib.Entries = append(lib.Entries, entry)
+	return lib.save()
+}
+
+func (lib *library) save() error {
+	if err := os.MkdirAll(lib.root, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(lib, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(lib.root, libraryFilename+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(lib.root, libraryFilename))
+}
+
+// rel makes an absolute path inside the root relative to it.
+func (lib *library) rel(path string) string {
+	if rel, err := filepath.Rel(lib.root, path); err == nil && filepath.IsAbs(path) && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
+
+// abs is the entry's folder on disk.
+func (lib *library) abs(e *libraryEntry) string {
+	return lib.absPath(e.Path)
+}
+
+// absPath undoes rel.
+func (lib *library) absPath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(lib.root, filepath.FromSlash(path))
+}
+
+// libraryDir is where an album goes by the layout, a path of placeholders
+// like "{platform}/{year} - {album}". Parts that come out empty are left
+// out, and each part is made a valid folder name.
+func libraryDir(album *Album, layout string) string {
+	if layout == "" {
+		layout = defaultLibraryLayout
+	}
+	fields := albumFields(album)
+
+	var parts []string
+	for _, part := range strings.Split(filepath.ToSlash(layout), "/") {
+		// "{year} - {album}" without a year shouldn't start with " - "
+		value, _ := expandTemplate(part, fields)
+		value = strings.Trim(value, " -_.")
+		if value = sanitizeFilename(value); value != "" {
+			parts = append(parts, value)
+		}
+	}
+	if len(parts) == 0 {
+		parts = append(parts, sanitizeFilename(album.Name))
+	}
+	return filepath.Join(parts...)
+}
+
+// runLibrary registers existing rips with a library, or lists it.
+func runLibrary(args []string) error {
+	args, err := withEnvArgs("library", args)
+	if err != nil {
+		return err
+	}
+	root := ""
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--library" && i+1 < len(args) {
+			root = args[i+1]
+			i++
+			continue
+		}
+		rest = append(rest, args[i])
+	}
+	usage := newClassError(errInvalidInput, "usage: khinsider_downloader library --library <root> import <dir>... | list")
+	if root == "" || len(rest) == 0 {
+		return usage
+	}
+	lib, err := openLibrary(root)
+	if err != nil {
+		return err
+	}
+
+	switch rest[0] {
+	case "import":
+		if len(rest) < 2 {
+			return usage
+		}
+		for _, dir := range rest[1:] {
+			n, err := lib.importDir(dir)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Registered %d album(s) from %s\n", n, dir)
+		}
+		return nil
+	case "list", "ls":
+		lib.mu.Lock()
+		defer lib.mu.Unlock()
+		entries := append([]libraryEntry(nil), lib.Entries...)
+		sort.Slice(entries, func(i, j int) bool { return strings.ToLower(entries[i].Album) < strings.ToLower(entries[j].Album) })
+		for _, e := range entries {
+			source := e.URL
+			if source == "" {
+				source = "(imported)"
+			}
+			fmt.Printf("%s\n  %s\n", e.Album, e.Path)
+			if e.Archive != "" {
+				fmt.Printf("  %s\n", e.Archive)
+			}
+			fmt.Printf("  %s\n", source)
+		}
+		fmt.Printf("%d album(s) in %s\n", len(entries), lib.root)
+		return nil
+	}
+	return usage
+}
+
+// importDir registers every folder under dir that holds audio files as an
+// album, in place. The album.json of earlier downloads gives the name and
+// source; other folders are named after themselves.
+func (lib *library) importDir(dir string) (int, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if formats, _ := folderAudio(path); len(formats) == 0 {
+			return nil
+		}
+		entry := libraryEntry{Album: filepath.Base(path), Path: path, Imported: true}
+		if meta := readAlbumMetadata(path); meta != nil {
+			entry.Album, entry.URL = meta.Album, meta.SourceURL
+		}
+		if existing := lib.find(entry.URL, entry.Album); existing != nil && lib.abs(existing) != path {
+			logf("Already in the library at %s, not registering %s\n", existing.Path, path)
+			return filepath.SkipDir
+		}
+		if err := lib.add(entry); err != nil {
+			return err
+		}
+		logf("  %s\n", entry.Album)
+		count++
+		return filepath.SkipDir
+	})
+	return count, err
+}
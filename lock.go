@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const lockFileName = ".khinsider.lock"
+
+// Locks from other machines can't be checked for a live process and are
+// considered stale after this long.
+const foreignLockTimeout = 12 * time.Hour
+
+// lockSettle is how long a run that took over a stale lock waits before
+// checking that no other run took it over at the same time.
+const lockSettle = 200 * time.Millisecond
+
+// waitForLock makes a second run wait for the album instead of giving up.
+var waitForLock bool
+
+// lockAlbumDir takes the lock on an album directory so two runs don't write
+// the same files. It returns the function releasing it.
+func lockAlbumDir(dir string) (func(), error) {
+	path := filepath.Join(dir, lockFileName)
+	hostname, _ := os.Hostname()
+	mine := fmt.Sprintf("%d %s %s\n", os.Getpid(), hostname, time.Now().Format(time.RFC3339Nano))
+	release := func() {
+		// Only our own lock, not one that replaced it as stale
+		if data, _ := os.ReadFile(path); string(data) == mine {
+			os.Remove(path)
+		}
+	}
+	announced := false
+
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = f.WriteString(mine)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			return release, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		owner, content, stale := readLock(path, hostname)
+		if stale {
+			logf("Taking over the stale lock left by %s\n", owner)
+			if takeOverLock(path, content, mine) {
+				return release, nil
+			}
+			continue
+		}
+		if !waitForLock {
+			return nil, fmt.Errorf("%s is being downloaded by another run (%s), use --wait-lock to wait for it or delete %s if that run is gone", dir, owner, path)
+		}
+		if !announced {
+			logf("Waiting for the other run on %s (%s) to finish...\n", dir, owner)
+			announced = true
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// takeOverLock replaces the stale lock with content stale by ours. Two runs
+// can find the same lock stale, so ours is written to a temporary file and
+// renamed over it, which never leaves the album unlocked, and read back a
+// moment later: only the run whose lock is still there goes on.
+func takeOverLock(path, stale, mine string) bool {
+	if data, err := os.ReadFile(path); err != nil || string(data) != stale {
+		return false
+	}
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	if err := os.WriteFile(tmp, []byte(mine), 0644); err != nil {
+		return false
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return false
+	}
+	time.Sleep(lockSettle)
+	data, _ := os.ReadFile(path)
+	return string(data) == mine
+}
+
+// readLock describes the lock's owner, returns its content and reports
+// whether it is stale.
+func readLock(path, hostname string) (string, string, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", "", false
+	}
+	data, _ := os.ReadFile(path)
+	content := string(data)
+	fields := strings.Fields(content)
+	if len(fields) < 2 {
+		// Half written or garbage, only trust it while it's fresh
+		return "unknown process", content, time.Since(info.ModTime()) > time.Minute
+	}
+
+	owner := fmt.Sprintf("pid %s on %s", fields[0], fields[1])
+	if fields[1] != hostname {
+		return owner, content, time.Since(info.ModTime()) > foreignLockTimeout
+	}
+	pid, err := strconv.Atoi(fields[0])
+	return owner, content, err != nil || !processAlive(pid)
+}
+
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	// FindProcess already fails for processes that don't exist on Windows
+	if runtime.GOOS == "windows" {
+		p.Release()
+		return true
+	}
+	// EPERM is a live process of another user, only a gone one is dead
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// deadPID returns the pid of a process that has exited.
+func deadPID(t *testing.T) int {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(exe, "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestProcessAlive(t *testing.T) {
+	if !processAlive(os.Getpid()) {
+		t.Error("processAlive(own pid) = false")
+	}
+	if runtime.GOOS != "windows" {
+		// Another user's process for anyone but root, which can't be
+		// signalled but is running
+		if !processAlive(1) {
+			t.Error("processAlive(1) = false")
+		}
+	}
+	if pid := deadPID(t); processAlive(pid) {
+		t.Errorf("processAlive(%d) = true for an exited process", pid)
+	}
+}
+
+func TestLockAlbumDir(t *testing.T) {
+	hostname, _ := os.Hostname()
+	old := time.Now().Add(-foreignLockTimeout - time.Hour)
+	tests := []struct {
+		name    string
+		content string    // of a lock already there, "" for none
+		mtime   time.Time // of that lock, zero for now
+		taken   bool      // whether the lock is taken over
+	}{
+		{"no lock", "", time.Time{}, true},
+		{"own live process", fmt.Sprintf("%d %s 2026-10-16T02:00:00Z\n", os.Getpid(), hostname), time.Time{}, false},
+		{"exited process", fmt.Sprintf("%d %s 2026-10-16T02:00:00Z\n", deadPID(t), hostname), time.Time{}, true},
+		{"bad pid", fmt.Sprintf("x %s 2026-10-16T02:00:00Z\n", hostname), time.Time{}, true},
+		{"other machine", "123 elsewhere.example 2026-10-16T02:00:00Z\n", time.Time{}, false},
+		{"other machine, old", "123 elsewhere.example 2026-10-16T02:00:00Z\n", old, true},
+		{"half written", "123", time.Time{}, false},
+		{"half written, old", "123", time.Now().Add(-2 * time.Minute), true},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		path := filepath.Join(dir, lockFileName)
+		if tt.content != "" {
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if !tt.mtime.IsZero() {
+				os.Chtimes(path, tt.mtime, tt.mtime)
+			}
+		}
+
+		release, err := lockAlbumDir(dir)
+		if !tt.taken {
+			if err == nil || !strings.Contains(err.Error(), "being downloaded by another run") {
+				t.Errorf("%s: lockAlbumDir error = %v, want the album to be locked", tt.name, err)
+			}
+			if data, _ := os.ReadFile(path); string(data) != tt.content {
+				t.Errorf("%s: the other run's lock was changed to %q", tt.name, data)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: lockAlbumDir error = %v", tt.name, err)
+			continue
+		}
+		data, _ := os.ReadFile(path)
+		if !strings.HasPrefix(string(data), fmt.Sprintf("%d %s ", os.Getpid(), hostname)) {
+			t.Errorf("%s: lock is %q, want ours", tt.name, data)
+		}
+
+		// Locked now, by a live process
+		if _, err := lockAlbumDir(dir); err == nil {
+			t.Errorf("%s: locked the album twice", tt.name)
+		}
+		release()
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s: lock still there after release: %v", tt.name, err)
+		}
+	}
+}
+
+// A run whose lock was taken over as stale leaves the new lock alone.
+func TestLockReleaseKeepsNewOwner(t *testing.T) {
+	dir := t.TempDir()
+	release, err := lockAlbumDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, lockFileName)
+	other := "123 elsewhere.example 2026-10-16T02:00:00Z\n"
+	if err := os.WriteFile(path, []byte(other), 0644); err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if data, _ := os.ReadFile(path); string(data) != other {
+		t.Errorf("lock after release = %q, want %q", data, other)
+	}
+}
+
+// Of two runs taking over the same stale lock, only one gets it.
+func TestTakeOverLockRace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), lockFileName)
+	stale := "1 host 2026-10-16T02:00:00Z\n"
+	if err := os.WriteFile(path, []byte(stale), 0644); err != nil {
+		t.Fatal(err)
+	}
+	results := make(chan bool, 2)
+	for _, mine := range []string{"2 host a\n", "3 host b\n"} {
+		go func() { results <- takeOverLock(path, stale, mine) }()
+	}
+	won := 0
+	for range 2 {
+		if <-results {
+			won++
+		}
+	}
+	if won != 1 {
+		t.Errorf("%d runs took over the lock", won)
+	}
+
+	if takeOverLock(path, "something else\n", "4 host c\n") {
+		t.Error("took over a lock that changed since it was found stale")
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	siteDomain = "khinsider.com"
+	loginPage  = "https://downloads.khinsider.com/forums/login/"
+	loginURL   = "https://downloads.khinsider.com/forums/login/login"
+)
+
+func runLogin(args []string) error {
+	username := ""
+	password := ""
+	cookieHeader := ""
+	logout := false
+	exportPath := ""
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--username":
+			if i+1 < len(args) {
+				username = args[i+1]
+				i++
+			}
+		case "--password":
+			if i+1 < len(args) {
+				password = args[i+1]
+				i++
+			}
+		case "--cookie":
+			if i+1 < len(args) {
+				cookieHeader = args[i+1]
+				i++
+			}
+		case "--cookies":
+			if i+1 < len(args) {
+				cookiesFile = args[i+1]
+				i++
+			}
+		case "--export-cookies":
+			if i+1 < len(args) {
+				exportPath = args[i+1]
+				i++
+			}
+		case "--logout":
+			logout = true
+		case "--no-keyring":
+			keyringDisabled = true
+		}
+	}
+
+	jar := cookieJar()
+
+	// Hand the session to other downloaders or the browser
+	if exportPath != "" {
+		jar.mu.Lock()
+		data, count := formatNetscapeCookies(jar.cookies), len(jar.cookies)
+		jar.mu.Unlock()
+		if err := os.WriteFile(exportPath, data, 0600); err != nil {
+			return err
+		}
+		fmt.Printf("Exported %d cookie(s) to %s\n", count, exportPath)
+		return nil
+	}
+
+	if logout {
+		if err := jar.Clear(); err != nil {
+			return err
+		}
+		if err := keyringDelete(loginKeyringName); err == nil {
+			fmt.Println("Removed the saved login from the keyring")
+		}
+		fmt.Println("Logged out")
+		return nil
+	}
+
+	// Import a session copied from the browser
+	if cookieHeader != "" {
+		cookies, err := http.ParseCookie(cookieHeader)
+		if err != nil {
+			return fmt.Errorf("invalid cookie string: %v", err)
+		}
+		for _, c := range cookies {
+			c.Domain = siteDomain
+			c.Expires = time.Now().AddDate(1, 0, 0)
+		}
+		jar.SetCookies(&url.URL{Scheme: "https", Host: "downloads." + siteDomain}, cookies)
+		fmt.Printf("Imported %d cookie(s) into %s\n", len(cookies), jar.path)
+		return nil
+	}
+
+	if username == "" {
+		// Renew the session with the login saved in the keyring
+		saved, err := loadLogin()
+		if err != nil {
+			return fmt.Errorf("usage: khinsider_downloader login --username <name> [--password <password>] | --cookie '<name=value; ...>' | --logout")
+		}
+		if err := login(saved.Username, saved.Password); err != nil {
+			return err
+		}
+		fmt.Printf("Logged in as %s with the login from the keyring\n", saved.Username)
+		return nil
+	}
+	if password == "" {
+		fmt.Print("Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("reading password: %v", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	if err := login(username, password); err != nil {
+		return err
+	}
+	fmt.Printf("Logged in as %s, session saved to %s\n", username, jar.path)
+
+	switch err := saveLogin(username, password); {
+	case err == nil:
+		fmt.Println("Login saved to the keyring, run 'khinsider_downloader login' to renew the session")
+	case errors.Is(err, errNoKeyring):
+		// Without a keyring the password isn't kept anywhere
+	default:
+		fmt.Printf("Warning: %v\n", err)
+	}
+	return nil
+}
+
+// loginFromKeyring logs in with the saved credentials, for when the
+// session has expired.
+func loginFromKeyring() error {
+	saved, err := loadLogin()
+	if err != nil {
+		return err
+	}
+	return login(saved.Username, saved.Password)
+}
+
+// login signs into the khinsider forums, which share their session with
+// the download site.
+func login(username, password string) error {
+	doc, err := fetchHTML(loginPage)
+	if err != nil {
+		return fmt.Errorf("loading login page: %v", err)
+	}
+
+	token, _ := doc.Find("input[name=_xfToken]").First().Attr("value")
+
+	form := url.Values{
+		"login":       {username},
+		"password":    {password},
+		"remember":    {"1"},
+		"_xfToken":    {token},
+		"_xfRedirect": {"https://downloads.khinsider.com/"},
+	}
+
+	client := siteClient()
+
+	req, err := http.NewRequest("POST", loginURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setRequestHeaders(req, loginPage)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	metrics.countResponse(resp.StatusCode)
+
+	// A successful login sets the persistent user cookie
+	for _, c := range cookieJar().Cookies(req.URL) {
+		if c.Name == "xf_user" {
+			return nil
+		}
+	}
+	return newClassError(errUnauthorized, fmt.Sprintf("login failed (status code: %d), check username and password", resp.StatusCode))
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// lowMemory is set by --low-memory, for small devices like a Raspberry Pi
+// Zero or a router: the song list is read without building a DOM for it,
+// only one album and one connection run at a time and buffers stay small.
+var lowMemory bool
+
+func enableLowMemory(opts *Options) {
+	opts.AlbumConcurrency = 1
+	maxHostConnections = 1
+	writeBufferSize = min(writeBufferSize, 64<<10)
+	httpTuning.idleConns = min(httpTuning.idleConns, 2)
+}
+
+var songTableStart = regexp.MustCompile(`(?i)<table[^>]*\bid=["']?songlist\b`)
+
+// cutSongTable takes the song list table out of an album page, which is
+// most of a big album's page. It returns the rest of the page and the
+// table, or a nil table if there is none.
+func cutSongTable(body []byte) ([]byte, []byte) {
+	loc := songTableStart.FindIndex(body)
+	if loc == nil {
+		return body, nil
+	}
+	start := loc[0]
+	end := bytes.Index(bytes.ToLower(body[start:]), []byte("</table>"))
+	if end < 0 {
+		return body, nil
+	}
+	end += start + len("</table>")
+
+	rest := make([]byte, 0, len(body)-(end-start))
+	rest = append(append(rest, body[:start]...), body[end:]...)
+	return rest, body[start:end]
+}
+
+// songRow collects the cells of a song list row while it is tokenized.
+type songRow struct {
+	header    bool
+	cells     []string
+	clickable []string // text of the td.clickable-row cells
+	name      string   // of the first link in a clickable cell
+	href      string
+}
+
+// streamSongTable reads the song list like parseAlbumHTML does, with the
+// tokenizer instead of a DOM.
+func streamSongTable(table []byte) (formats []string, songs []*Song) {
+	z := html.NewTokenizer(bytes.NewReader(table))
+	var (
+		row           *songRow
+		cell          strings.Builder
+		inCell        bool
+		cellClickable bool
+		inLink        bool
+		link          strings.Builder
+		sizeColumns   = make(map[int]string)
+		bitrateColumn = -1
+		discColumn    = -1
+	)
+
+	endCell := func() {
+		if !inCell {
+			return
+		}
+		text := strings.TrimSpace(cell.String())
+		row.cells = append(row.cells, text)
+		if cellClickable {
+			row.clickable = append(row.clickable, text)
+		}
+		inCell = false
+	}
+	endRow := func() {
+		endCell()
+		if row == nil {
+			return
+		}
+		if row.header {
+			for i, text := range row.cells {
+				header := strings.ToUpper(text)
+				switch {
+				case contains(audioFormats, header):
+					formats = append(formats, header)
+					sizeColumns[i] = header
+				case header == "BITRATE":
+					bitrateColumn = i
+				case header == "CD" || header == "DISC":
+					discColumn = i
+				}
+			}
+		} else if row.name != "" {
+			songs = append(songs, songFromRow(row, sizeColumns, bitrateColumn, discColumn))
+		}
+		row = nil
+	}
+
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			endRow()
+			return formats, songs
+		case html.TextToken:
+			text := z.Text() // can only be read once
+			if inCell {
+				cell.Write(text)
+			}
+			if inLink {
+				link.Write(text)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			attrs := make(map[string]string)
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				attrs[string(key)] = string(val)
+			}
+			switch string(name) {
+			case "tr":
+				endRow()
+				if !strings.Contains(attrs["id"], "songlist_footer") {
+					row = &songRow{header: attrs["id"] == "songlist_header"}
+				}
+			case "td", "th":
+				if row == nil {
+					continue
+				}
+				endCell()
+				inCell = true
+				cell.Reset()
+				cellClickable = strings.Contains(" "+attrs["class"]+" ", " clickable-row ")
+			case "a":
+				if inCell && cellClickable && row.href == "" && attrs["href"] != "" {
+					row.href = attrs["href"]
+					inLink = true
+					link.Reset()
+				}
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "a":
+				if inLink {
+					row.name = strings.TrimSpace(link.String())
+					inLink = false
+				}
+			case "td", "th":
+				endCell()
+			case "tr", "table":
+				endRow()
+			}
+		}
+	}
+}
+
+func songFromRow(row *songRow, sizeColumns map[int]string, bitrateColumn, discColumn int) *Song {
+	song := &Song{
+		Name:          row.name,
+		SongLink:      "https://downloads.khinsider.com" + row.href,
+		DownloadLinks: make(map[string]string),
+		Sizes:         make(map[string]int),
+	}
+	if len(row.clickable) > 1 {
+		song.LengthSeconds = convertToSeconds(row.clickable[1])
+	}
+	for j, text := range row.cells {
+		if format, ok := sizeColumns[j]; ok {
+			if kb := parseSizeKB(text); kb > 0 {
+				song.Sizes[format] = kb
+			}
+		} else if j == bitrateColumn {
+			song.Bitrate, _ = strconv.Atoi(strings.TrimSpace(strings.TrimSuffix(strings.ToLower(text), "kbps")))
+		} else if j == discColumn {
+			song.Disc = text
+		}
+	}
+	return song
+}
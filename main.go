@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,9 +12,9 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/PuerkitoBio/goquery"
 )
 
 type Song struct {
@@ -21,218 +23,885 @@ type Song struct {
 	LengthSeconds int
 	DownloadLinks map[string]string // format -> URL
 	Sizes         map[string]int    // format -> size in KB
+	Bitrate       int               // kbps of the lossy version, if listed
+	Disc          string            // the CD column of multi-disc albums
+	SampleRate    int               // Hz, from the song page
+	Channels      int               // from the song page
+	Filename      string            // name of the downloaded file
+	Fallback      bool              // saved in another format than the one asked for
+	ContentLength int64             // exact size from the preflight, if known
+
+	page []byte // the song page's HTML
 }
 
 type Album struct {
-	Name        string
-	AlbumLink   string
-	AlbumImages []string
-	Songs       []*Song
+	Name          string
+	AlbumLink     string
+	AlbumImages   []string
+	ImageTypes    map[string]string // AlbumImages URL -> front, back, disc, inlay, booklet or other
+	Songs         []*Song
+	Platforms     []string
+	Year          string
+	CatalogNumber string
+	Publisher     string
+	AlbumType     string
+	Formats       []string // formats listed in the song table, e.g. MP3, FLAC
+	Rating        float64  // average user rating out of 5, 0 if not rated
+	Votes         int
+	Downloads     int // download count, where the page shows one
+
+	page []byte // the album page's HTML
 }
 
 func main() {
+	defer recoverCrash("")
 	if len(os.Args) < 2 {
-		fmt.Println("Usage: khinsider_downloader <album_url> [options]")
-		fmt.Println("\nOptions:")
-		fmt.Println("  --format mp3|flac    Download format (default: flac)")
-		fmt.Println("  --no-images          Skip downloading album images")
+		printUsage()
 		return
 	}
 
-	albumURL := os.Args[1]
-	downloadFormat := "flac"
-	downloadImages := true
+	var command func([]string) error
+	switch os.Args[1] {
+	case "serve":
+		command = runServer
+	case "status":
+		command = runStatus
+	case "queue":
+		command = runQueue
+	case "library":
+		command = runLibrary
+	case "login":
+		command = runLogin
+	case "keyring":
+		command = runKeyring
+	case "favorites":
+		command = runFavorites
+	case "info":
+		command = runInfo
+	case "index":
+		command = runIndex
+	case "search":
+		command = runSearch
+	case "random":
+		command = runRandom
+	case "diff":
+		command = runDiff
+	case "export":
+		command = runExport
+	case "stats":
+		command = runStatsCommand
+	case "completion":
+		command = runCompletion
+	case "self-update":
+		command = runSelfUpdate
+	case "version", "--version":
+		command = runVersion
+	}
+	if command != nil {
+		err := command(os.Args[2:])
+		flushNotifications(notifyFlushTimeout)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
 
-	// Parse command line arguments
-	for i := 2; i < len(os.Args); i++ {
-		switch os.Args[i] {
-		case "--format":
-			if i+1 < len(os.Args) {
-				downloadFormat = strings.ToLower(os.Args[i+1])
-				i++
-			}
-		case "--no-images":
-			downloadImages = false
+	opts, args, err := parseOptions(os.Args[1:])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(exitCode(err))
+	}
+	urls, err := inputURLs(args, opts)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(exitCode(err))
+	}
+	if len(urls) == 0 {
+		printUsage()
+		return
+	}
+
+	// Lines of a batch file may carry options of their own after a |. They
+	// belong to the line, an album can be listed twice with different ones
+	albumOpts := make(map[int]*Options) // index into urls
+	for i, line := range urls {
+		albumURL, overrides := splitBatchLine(line)
+		urls[i] = albumURL
+		if overrides == "" {
+			continue
+		}
+		o, err := withAlbumOverrides(opts, overrides)
+		if err != nil {
+			fmt.Printf("Error: %s: %v\n", albumURL, err)
+			os.Exit(exitCode(newClassError(errInvalidInput, err.Error())))
+		}
+		albumOpts[i] = o
+	}
+
+	var (
+		mu       sync.Mutex
+		skipped  []string
+		started  int
+		firstErr error // decides the exit code
+	)
+	failed := downloadBatch(urls, opts, func(i int, albumURL string) error {
+		o := opts
+		if overridden, ok := albumOpts[i]; ok {
+			o = overridden
+		}
+		result, err := downloadInput(albumURL, o)
+		if err != nil && quietMode {
+			fmt.Fprintf(os.Stderr, "Error downloading %s: %v\n", albumURL, err)
+		}
+		report.add(albumURL, result, err)
+		mu.Lock()
+		defer mu.Unlock()
+		started++
+		if result != nil && result.Skipped {
+			skipped = append(skipped, fmt.Sprintf("%s (%s)", albumURL, result.SkipReason))
+		}
+		failure := err
+		if err == nil && result != nil && result.Failed > 0 {
+			failure = errPartial
+		}
+		// A failed album outranks one with some failed tracks
+		if failure != nil && (firstErr == nil || firstErr == errPartial) {
+			firstErr = failure
+		}
+		return err
+	})
+
+	if len(urls) > 1 {
+		logf("\n=== Batch Summary ===\n")
+		logf("Albums: %d, failed: %d, skipped: %d\n", len(urls), failed, len(skipped))
+		for _, s := range skipped {
+			logln(colorize("skipped", "  Skipped: "+s))
+		}
+		if policy.isStopped() {
+			logf("Not started after stopping early: %d\n", len(urls)-started)
+		}
+	}
+
+	flushNotifications(notifyFlushTimeout)
+	if opts.EmailReport {
+		if err := sendEmailReport(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error sending the email report: %v\n", err)
+		}
+	}
+	if err := policy.err(); err != nil && (firstErr == nil || firstErr == errPartial) {
+		firstErr = err
+	}
+	if firstErr != nil {
+		os.Exit(exitCode(firstErr))
+	}
+}
+
+type AlbumResult struct {
+	Album      *Album
+	Successful int
+	Failed     int
+	SavedTo    string
+	Skipped    bool
+	SkipReason string
+	NewTracks  int      // with --update, tracks added to the album since
+	Replaced   []string // with --update, files downloaded again
+	Unchanged  bool     // with --update, nothing was new
+}
+
+func downloadAlbum(albumURL string, opts *Options) (*AlbumResult, error) {
+	albumURL, err := resolveAlbumURL(albumURL)
+	if err != nil {
+		logf("Error resolving album URL: %v\n", err)
+		emitProgress(progressEvent{Event: "album_failed", Error: err.Error(), Code: errorCode(err)})
+		return nil, err
+	}
+
+	alreadyDownloaded := opts.DownloadArchive != "" && archiveContains(opts.DownloadArchive, albumURL)
+	if alreadyDownloaded && !opts.Update {
+		logf("Already downloaded (in %s), skipping: %s\n", opts.DownloadArchive, albumURL)
+		return &AlbumResult{Skipped: true, SkipReason: "already downloaded"}, nil
+	}
+
+	var lib *library
+	if opts.Library != "" {
+		if lib, err = openLibrary(opts.Library); err != nil {
+			logf("Error: %v\n", err)
+			emitProgress(progressEvent{Event: "album_failed", Error: err.Error(), Code: errorCode(err)})
+			return nil, err
+		}
+		if entry := lib.find(albumURL, ""); entry != nil && !opts.Update {
+			logf("Already in the library at %s, skipping: %s\n", entry.Path, albumURL)
+			return &AlbumResult{Skipped: true, SkipReason: "in the library"}, nil
 		}
 	}
 
 	// Parse the album page
 	album, err := ParseAlbumPage(albumURL)
 	if err != nil {
-		fmt.Printf("Error parsing album: %v\n", err)
-		return
+		logf("Error parsing album: %v\n", err)
+		emitProgress(progressEvent{Event: "album_failed", Error: err.Error(), Code: errorCode(err)})
+		return nil, err
+	}
+	if len(album.Songs) == 0 {
+		err := newClassError(errParse, fmt.Sprintf("no tracks found on %s, is it an album page?", albumURL))
+		logf("Error: %v\n", err)
+		emitProgress(progressEvent{Event: "album_failed", Album: album.Name, Error: err.Error(), Code: errorCode(err)})
+		return nil, err
+	}
+
+	if opts.RequireFormat != "" && !albumHasFormat(album, opts.RequireFormat) {
+		reason := "no " + strings.ToUpper(opts.RequireFormat)
+		logf("Skipping %s: %s\n", album.Name, reason)
+		emitProgress(progressEvent{Event: "album_skipped", Album: album.Name, Error: reason})
+		return &AlbumResult{Album: album, Skipped: true, SkipReason: reason}, nil
+	}
+	if reason, low := ratingTooLow(album, opts.MinRating); low {
+		logf("Skipping %s: %s\n", album.Name, reason)
+		emitProgress(progressEvent{Event: "album_skipped", Album: album.Name, Error: reason})
+		return &AlbumResult{Album: album, Skipped: true, SkipReason: reason}, nil
+	}
+	if reason, low := bitrateTooLow(album, opts.MinBitrate); low {
+		logf("Skipping %s: %s\n", album.Name, reason)
+		emitProgress(progressEvent{Event: "album_skipped", Album: album.Name, Error: reason})
+		return &AlbumResult{Album: album, Skipped: true, SkipReason: reason}, nil
 	}
 
-	fmt.Printf("Album: %s\n", album.Name)
-	fmt.Printf("Songs: %d\n", len(album.Songs))
-	fmt.Printf("Download format: %s\n", strings.ToUpper(downloadFormat))
+	// Rips registered with library import only have a name
+	if lib != nil && !opts.Update {
+		if entry := lib.find("", album.Name); entry != nil {
+			reason := "in the library at " + entry.Path
+			logf("Skipping %s: %s\n", album.Name, reason)
+			emitProgress(progressEvent{Event: "album_skipped", Album: album.Name, Error: reason})
+			return &AlbumResult{Album: album, Skipped: true, SkipReason: reason}, nil
+		}
+	}
+
+	logf("Album: %s\n", album.Name)
+	if len(album.Platforms) > 0 {
+		logf("Platforms: %s\n", strings.Join(album.Platforms, ", "))
+	}
+	if rating := formatRating(album); rating != "" {
+		logf("Rating: %s\n", rating)
+	}
+	logf("Songs: %d\n", len(album.Songs))
+	logf("Download format: %s\n", strings.ToUpper(opts.Format))
+	emitProgress(progressEvent{Event: "album_started", Album: album.Name, Total: len(album.Songs)})
 
 	// Create download directory
 	sanitizedName := sanitizeFilename(album.Name)
-	downloadDir := filepath.Join("downloads", sanitizedName)
+	downloadDir := filepath.Join(opts.OutputDir, sanitizedName)
+	if lib != nil {
+		// An album updated with --update stays where it is
+		if entry := lib.find(albumURL, album.Name); entry != nil {
+			downloadDir = lib.abs(entry)
+		} else {
+			downloadDir = filepath.Join(lib.root, libraryDir(album, opts.LibraryLayout))
+		}
+	}
 	os.MkdirAll(downloadDir, 0755)
 
+	unlock, err := lockAlbumDir(downloadDir)
+	if err != nil {
+		logf("Error: %v\n", err)
+		emitProgress(progressEvent{Event: "album_failed", Album: album.Name, Error: err.Error(), Code: errorCode(err)})
+		return nil, err
+	}
+	defer unlock()
+	defer restoreTitle()
+
+	var dedupeIndex *trackIndex
+	if opts.Dedupe != "" {
+		root := opts.OutputDir
+		if lib != nil {
+			root = lib.root
+		}
+		dedupeIndex = trackIndexFor(root)
+	}
+
+	// With --update, the album.json of the last download tells which
+	// tracks are new or were replaced on the site since
+	var previous *albumMetadata
+	if opts.Update {
+		previous = readAlbumMetadata(downloadDir)
+	}
+	newTracks := 0
+	var replaced []string
+
+	if opts.SavePage {
+		if err := os.WriteFile(filepath.Join(downloadDir, "album.html"), album.page, 0644); err != nil {
+			logf("Error saving the album page: %v\n", err)
+		}
+	}
+
+	// Tracks left out by --tracks, the duration filters or --filter-exec
+	excluded := make(map[*Song]string)
+	var wanted []*Song
+	for i, song := range album.Songs {
+		reason := trackExcluded(song, i+1, opts)
+		if reason == "" && opts.FilterExec != "" {
+			var err error
+			if reason, err = runTrackFilter(opts.FilterExec, album, song, i+1); err != nil {
+				logf("Error running --filter-exec for %s: %v, keeping it\n", song.Name, err)
+			}
+		}
+		if reason != "" {
+			excluded[song] = reason
+		} else {
+			wanted = append(wanted, song)
+		}
+	}
+
+	// Check all links up front so dead ones are reported before downloading
+	deadLinks := make(map[*Song]error)
+	var totalBytes int64
+	if opts.Preflight {
+		logln("\nChecking download links...")
+		deadLinks = runPreflight(wanted, opts)
+		for _, song := range wanted {
+			totalBytes += song.ContentLength
+		}
+		logf("Links OK: %d, dead: %d, total size: %s\n",
+			len(wanted)-len(deadLinks), len(deadLinks), formatBytes(totalBytes))
+		for _, song := range wanted {
+			if err, dead := deadLinks[song]; dead {
+				logf("  %s: %v\n", song.Name, err)
+			}
+		}
+	}
+
+	// Without a preflight the sizes in the track list still give an estimate
+	if !opts.Preflight {
+		for _, song := range wanted {
+			totalBytes += trackBytes(song, opts.Format)
+		}
+	}
+
 	// Download songs
-	fmt.Println("\nDownloading songs...")
+	logln("\nDownloading songs...")
 	successCount := 0
 	failCount := 0
-
+	startTime := time.Now()
+	var doneBytes, transferredBytes int64
+	stats := newAlbumStats(album)
+	names := newTrackNames()
+	extracted := readExtracted(downloadDir)
+	chooser := newFormatChooser(opts)
+	missingCount := 0 // skipped by --on-missing-format
+
+	stopped := false
 	for i, song := range album.Songs {
-		fmt.Printf("[%d/%d] %s\n", i+1, len(album.Songs), song.Name)
+		if opts.Pause.isPaused() {
+			logln("Paused")
+			return nil, errPaused
+		}
+		if policy.tracksStopped() {
+			stopped = true
+			logf("Stopped before %d of %d tracks\n", len(album.Songs)-i, len(album.Songs))
+			break
+		}
 
-		// Get download links for this song
-		err := ParseDownloadLinks(song)
-		if err != nil {
-			fmt.Printf("  Error getting download links: %v\n", err)
+		logf("[%d/%d] %s\n", i+1, len(album.Songs), song.Name)
+		emitProgress(progressEvent{Event: "track_started", Album: album.Name, Track: song.Name, Index: i + 1, Total: len(album.Songs)})
+		setTitle("[%d/%d] %s", i+1, len(album.Songs), album.Name)
+
+		fail := func(prefix string, err error) {
+			msg := prefix + ": " + err.Error()
+			logf("  %s\n", colorize("failed", msg))
+			emitProgress(progressEvent{Event: "track_failed", Album: album.Name, Track: song.Name, Index: i + 1, Total: len(album.Songs), Error: msg, Code: errorCode(err)})
 			failCount++
-			continue
+			recordFailure()
+			song.Filename = ""
 		}
 
-		// Select download URL based on format preference
-		var downloadURL string
-
-		formatUpper := strings.ToUpper(downloadFormat)
-		if url, ok := song.DownloadLinks[formatUpper]; ok {
-			downloadURL = url
-		} else if formatUpper == "FLAC" {
-			// Fallback to MP3 if FLAC not available
-			if url, ok := song.DownloadLinks["MP3"]; ok {
-				downloadURL = url
-				fmt.Printf("  FLAC not available, using MP3\n")
+		if reason, ok := excluded[song]; ok {
+			if song.LengthSeconds > 0 {
+				reason = formatTrackLength(song.LengthSeconds) + ", " + reason
 			}
-		} else {
-			// Get first available format
-			for _, url := range song.DownloadLinks {
-				downloadURL = url
-				break
+			logln(colorize("skipped", fmt.Sprintf("  Skipping (%s)", reason)))
+			emitProgress(progressEvent{Event: "track_skipped", Album: album.Name, Track: song.Name, Index: i + 1, Total: len(album.Songs)})
+			continue
+		}
+
+		if err, dead := deadLinks[song]; dead {
+			fail("Skipping", err)
+			continue
+		}
+
+		// Get download links for this song (unless the preflight already did)
+		if len(song.DownloadLinks) == 0 {
+			err := ParseDownloadLinks(song)
+			if err != nil {
+				fail("Error getting download links", err)
+				continue
 			}
 		}
 
+		if opts.SaveSongPages {
+			saveSongPage(song, i+1, downloadDir)
+		}
+
+		// Select download URL based on format preference
+		downloadURL, note, skip := chooser.choose(song)
+		if skip {
+			logln(colorize("skipped", fmt.Sprintf("  Skipping (no %s)", strings.ToUpper(opts.Format))))
+			emitProgress(progressEvent{Event: "track_skipped", Album: album.Name, Track: song.Name, Index: i + 1, Total: len(album.Songs)})
+			missingCount++
+			continue
+		}
+		if note != "" {
+			logf("  %s\n", colorize("fallback", note))
+		}
+
 		if downloadURL == "" {
-			fmt.Printf("  No download link found\n")
-			failCount++
+			fail("Error", newClassError(errParse, "no download link found"))
 			continue
 		}
 
-		// Extract original filename from URL
-		parsedURL, err := url.Parse(downloadURL)
+		originalFilename, err := trackFilename(album, song, i+1, downloadURL, opts)
 		if err != nil {
-			fmt.Printf("  Error parsing download URL: %v\n", err)
-			failCount++
+			fail("Error parsing download URL", err)
 			continue
 		}
 
-		// Get the original filename from the URL
-		originalFilename := filepath.Base(parsedURL.Path)
-		if originalFilename == "" || originalFilename == "/" {
-			// Fallback to generated name if we can't get original
-			ext := filepath.Ext(downloadURL)
-			if ext == "" {
-				ext = "." + strings.ToLower(formatUpper)
-			}
-			originalFilename = fmt.Sprintf("%03d - %s%s", i+1, sanitizeFilename(song.Name), ext)
+		// Two tracks with the same file name would overwrite each other
+		if name, first := names.claim(originalFilename, i+1); first != 0 {
+			logf("  %s\n", colorize("fallback", fmt.Sprintf("Same file name as track %d, saving as %s", first, name)))
+			originalFilename = name
 		}
 
 		filePath := filepath.Join(downloadDir, originalFilename)
+		song.Filename = originalFilename
+		song.Fallback = missingFormat(song, opts)
 
-		if _, err := os.Stat(filePath); err == nil {
-			fmt.Println("  File already exists, skipping download")
+		change, detail := trackUnchanged, ""
+		if previous != nil {
+			change, detail = previous.compareTrack(song, originalFilename)
+		}
+		if change == trackNew {
+			newTracks++
+		}
+		_, statErr := os.Stat(filePath)
+		if files, ok := extracted.present(downloadDir, originalFilename); ok && statErr != nil {
+			// An archive track is there as the files extracted from it.
+			// They keep their names unless it is downloaded again.
+			if change != trackReplaced {
+				for _, name := range files {
+					if !strings.EqualFold(name, originalFilename) {
+						names.claim(name, i+1)
+					}
+				}
+				song.Filename = files[0]
+			}
+			statErr = nil
+		}
+		if statErr == nil && change == trackReplaced {
+			logf("  %s\n", colorize("fallback", "Replaced on the site ("+detail+"), downloading again"))
+			replaced = append(replaced, originalFilename)
+		} else if statErr == nil {
+			logln(colorize("skipped", "  File already exists, skipping download"))
+			emitProgress(progressEvent{Event: "track_skipped", Album: album.Name, Track: song.Name, Index: i + 1, Total: len(album.Songs), File: filePath})
+			doneBytes += trackBytes(song, opts.Format)
 			successCount++
 			continue
 		}
 
-		err = downloadFile(downloadURL, filePath, 3)
+		// A compilation's track may already be there from another album
+		if opts.Dedupe != "" {
+			if src := dedupeIndex.duplicate(song, originalFilename, downloadDir); src != "" {
+				placed, err := placeDuplicate(opts.Dedupe, src, filePath)
+				if err != nil {
+					logf("  Error reusing %s: %v, downloading\n", src, err)
+				} else {
+					logln(colorize("skipped", "  Same track as another album's, "+placed))
+					emitProgress(progressEvent{Event: "track_skipped", Album: album.Name, Track: song.Name, Index: i + 1, Total: len(album.Songs), File: filePath})
+					doneBytes += trackBytes(song, opts.Format)
+					successCount++
+					continue
+				}
+			}
+		}
+
+		trackStart := time.Now()
+		err = fetchTrack(downloadURL, filePath, album.Name, song.Name, i+1, len(album.Songs), opts)
+		if errors.Is(err, errPaused) {
+			logln("  Paused, the partial file will be resumed")
+			return nil, err
+		}
 		if err != nil {
-			fmt.Printf("  Error downloading: %v\n", err)
-			failCount++
+			fail("Error downloading", err)
 			continue
 		}
 
-		fmt.Printf("  Downloaded: %s\n", originalFilename)
+		stats.addTrack(filePath, time.Since(trackStart))
+		logln(colorize("done", "  Downloaded: "+originalFilename))
+		emitProgress(progressEvent{Event: "track_completed", Album: album.Name, Track: song.Name, Index: i + 1, Total: len(album.Songs), File: filePath})
 		successCount++
+		metrics.completed.Add(1)
+
+		if !sizeMatchesList(song, filePath) {
+			logf("  %s\n", colorize("fallback", "Size differs from the track list, the file may be incomplete"))
+		}
+
+		// With known sizes we can estimate the remaining time
+		if size := trackBytes(song, opts.Format); totalBytes > 0 && size > 0 {
+			doneBytes += size
+			transferredBytes += size
+			rate := float64(transferredBytes) / time.Since(startTime).Seconds()
+			remaining := time.Duration(float64(totalBytes-doneBytes)/rate) * time.Second
+			logf("  %s of %s done, ETA %v\n", formatBytes(doneBytes), formatBytes(totalBytes), remaining.Round(time.Second))
+		}
+
+		downloadedFiles := finishTrack(album, song, i+1, filePath, downloadURL, names, opts)
+		if dedupeIndex != nil {
+			for _, path := range downloadedFiles {
+				dedupeIndex.add(path)
+			}
+		}
 
 		// Be nice to the server
 		time.Sleep(500 * time.Millisecond)
 	}
 
 	// Download album images
-	if downloadImages && len(album.AlbumImages) > 0 {
-		fmt.Println("\nDownloading album images...")
-		imageDir := filepath.Join(downloadDir, "Art")
-		os.MkdirAll(imageDir, 0755)
-
-		for i, imgURL := range album.AlbumImages {
-			if !strings.HasPrefix(imgURL, "http") {
-				imgURL = "https://downloads.khinsider.com" + imgURL
+	if opts.Images && len(album.AlbumImages) > 0 && !stopped {
+		if _, err := os.Stat(filepath.Join(downloadDir, scansArchive)); opts.ArtArchive && err == nil {
+			logf("\nAlbum images are in %s already\n", scansArchive)
+		} else {
+			logln("\nDownloading album images...")
+			front, failed := downloadAlbumImages(album, album.AlbumImages, downloadDir, opts)
+			switch {
+			case !opts.ArtArchive:
+			case failed > 0:
+				// The next run fetches the missing ones and bundles them all
+				logf("Not creating %s until all images are downloaded\n", scansArchive)
+			default:
+				if err := archiveArt(downloadDir, front, opts); err != nil {
+					logf("Error creating %s: %v\n", scansArchive, err)
+				}
 			}
+		}
+	}
 
-			// Extract original filename from URL
-			parsedURL, err := url.Parse(imgURL)
-			if err != nil {
-				fmt.Printf("Error parsing image URL %s: %v\n", imgURL, err)
-				continue
-			}
+	if opts.WriteMetadata || opts.Update {
+		if err := writeAlbumMetadata(album, opts.TagMap, downloadDir); err != nil {
+			logf("Error writing album metadata: %v\n", err)
+		}
+	}
 
-			// Get the last part of the path as filename
-			originalFilename := filepath.Base(parsedURL.Path)
-			if originalFilename == "" || originalFilename == "/" {
-				originalFilename = fmt.Sprintf("cover_%d.jpg", i)
+	if opts.BeetsImport && (previous == nil || newTracks > 0 || len(replaced) > 0) {
+		logln("\nImporting into beets...")
+		if err := runBeetsImport(downloadDir); err != nil {
+			logf("Error running beets import: %v\n", err)
+		}
+	}
+
+	savedTo, archivePath := downloadDir, ""
+	if opts.ArchiveFormat != "" {
+		logln("\nCreating archive...")
+		path, err := createArchive(downloadDir, opts.ArchiveFormat)
+		if err != nil {
+			logf("Error creating archive: %v\n", err)
+		} else {
+			savedTo, archivePath = path, path
+			switch {
+			case !opts.ArchiveDelete:
+			case failCount > 0 || stopped:
+				// The partial files let a new run continue where this one stopped
+				logf("Keeping %s, not all tracks were downloaded\n", downloadDir)
+			default:
+				if err := os.RemoveAll(downloadDir); err != nil {
+					logf("Error removing %s: %v\n", downloadDir, err)
+				}
 			}
+		}
+	}
 
-			imagePath := filepath.Join(imageDir, originalFilename)
+	logf("\n=== Download Summary ===\n")
+	logln(colorize("done", fmt.Sprintf("Successful: %d", successCount)))
+	if failCount > 0 {
+		logln(colorize("failed", fmt.Sprintf("Failed: %d", failCount)))
+	} else {
+		logf("Failed: %d\n", failCount)
+	}
+	stats.finish(failCount)
+	stats.print()
+	printFormatReport(album)
+	if len(excluded) > 0 {
+		logf("Left out by the filters: %d\n", len(excluded))
+	}
+	if missingCount > 0 {
+		logf("Skipped without %s: %d\n", strings.ToUpper(opts.Format), missingCount)
+	}
+	if names.renamed > 0 {
+		logf("Renamed because of duplicate file names: %d\n", names.renamed)
+	}
+	if previous != nil {
+		logf("New since the last download: %d\n", newTracks)
+		for _, name := range replaced {
+			logf("Replaced on the site: %s\n", name)
+		}
+	}
+	logf("Files saved to: %s\n", savedTo)
+	emitProgress(progressEvent{Event: "album_completed", Album: album.Name, Total: len(album.Songs), File: savedTo, Successful: successCount, Failed: failCount, album: album, stats: stats})
 
-			if _, err := os.Stat(imagePath); err == nil {
-				fmt.Printf("Image already exists, skipping: %s\n", originalFilename)
-				continue
-			}
+	if path := statsFilePath(opts); path != "" {
+		if err := appendStats(path, stats); err != nil {
+			logf("Error updating the stats file: %v\n", err)
+		}
+	}
 
-			err = downloadFile(imgURL, imagePath, 3)
-			if err != nil {
-				fmt.Printf("Error downloading image %s: %v\n", imgURL, err)
-			} else {
-				fmt.Printf("Downloaded: %s\n", originalFilename)
+	if quietMode && failCount > 0 {
+		fmt.Printf("%s: %d downloaded, %d failed, saved to %s\n", album.Name, successCount, failCount, savedTo)
+	}
+
+	policy.albumFinished(failCount > 0)
+	if opts.DownloadArchive != "" && failCount == 0 && !stopped && !alreadyDownloaded {
+		if err := recordInArchive(opts.DownloadArchive, albumURL); err != nil {
+			logf("Error updating download archive: %v\n", err)
+		}
+	}
+	if lib != nil && failCount == 0 && !stopped {
+		if err := lib.add(libraryEntry{URL: albumURL, Album: album.Name, Path: downloadDir, Archive: archivePath}); err != nil {
+			logf("Error updating the library: %v\n", err)
+		}
+	}
+
+	return &AlbumResult{
+		Album:      album,
+		Successful: successCount,
+		Failed:     failCount,
+		SavedTo:    savedTo,
+		NewTracks:  newTracks,
+		Replaced:   replaced,
+		Unchanged:  previous != nil && newTracks == 0 && len(replaced) == 0 && failCount == 0,
+	}, nil
+}
+
+// downloadAlbumImages saves the album images into the art folder, or next
+// to the tracks as cover, back and booklet-NN with --flat. Like the
+// preflight it runs a few downloads at once, one in polite mode. It
+// returns the path of the front cover and how many images failed.
+func downloadAlbumImages(album *Album, images []string, downloadDir string, opts *Options) (string, int) {
+	imageDir := filepath.Join(downloadDir, opts.ArtDir)
+	if opts.Flat {
+		imageDir = downloadDir
+	}
+	os.MkdirAll(imageDir, 0755)
+	counts := make(map[string]int) // of each kind of image
+	pages := 0                     // booklet pages and other scans
+	front, frontPath := album.frontCover(), ""
+
+	type imageJob struct{ url, name string }
+	var jobs []imageJob
+	for i, imgURL := range images {
+		if !strings.HasPrefix(imgURL, "http") {
+			imgURL = "https://downloads.khinsider.com" + imgURL
+		}
+
+		// Extract original filename from URL
+		parsedURL, err := url.Parse(imgURL)
+		if err != nil {
+			logf("Error parsing image URL %s: %v\n", imgURL, err)
+			continue
+		}
+
+		// Get the last part of the path as filename
+		originalFilename := sanitizeFilename(filepath.Base(parsedURL.Path))
+		if originalFilename == "" || originalFilename == "/" {
+			originalFilename = fmt.Sprintf("cover_%d.jpg", i)
+		}
+
+		// Named by kind: cover.jpg for the front, back.jpg, disc-01.jpg, ...
+		if opts.Flat {
+			ext := strings.ToLower(filepath.Ext(originalFilename))
+			kind := album.ImageTypes[images[i]]
+			counts[kind]++
+			switch {
+			case kind == imageFront && counts[kind] == 1:
+				originalFilename = "cover" + ext
+			case kind == imageBack && counts[kind] == 1:
+				originalFilename = "back" + ext
+			case kind == imageFront || kind == imageBack || kind == imageDisc || kind == imageInlay:
+				originalFilename = fmt.Sprintf("%s-%02d%s", kind, counts[kind], ext)
+			default:
+				pages++
+				originalFilename = fmt.Sprintf("booklet-%02d%s", pages, ext)
 			}
 		}
+
+		if images[i] == front {
+			frontPath = filepath.Join(imageDir, originalFilename)
+		}
+
+		if _, err := os.Stat(filepath.Join(imageDir, originalFilename)); err == nil {
+			logln(colorize("skipped", "Image already exists, skipping: "+originalFilename))
+			continue
+		}
+		jobs = append(jobs, imageJob{imgURL, originalFilename})
 	}
 
-	fmt.Printf("\n=== Download Summary ===\n")
-	fmt.Printf("Successful: %d\n", successCount)
-	fmt.Printf("Failed: %d\n", failCount)
-	fmt.Printf("Files saved to: %s\n", downloadDir)
+	workers := imageWorkers
+	if politeMode {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	var failed atomic.Int32
+	queue := make(chan imageJob)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				if err := downloadImage(job.url, filepath.Join(imageDir, job.name)); err != nil {
+					logf("%s\n", colorize("failed", fmt.Sprintf("Error downloading image %s: %v", job.url, err)))
+					failed.Add(1)
+				} else {
+					logln(colorize("done", "Downloaded: "+job.name))
+				}
+			}
+		}()
+	}
+	for _, job := range jobs {
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+
+	n := int(failed.Load())
+	if n > 0 {
+		logln(colorize("failed", fmt.Sprintf("Failed images: %d", n)))
+	}
+	return frontPath, n
 }
 
-func ParseAlbumPage(albumURL string) (*Album, error) {
-	doc, err := fetchHTML(albumURL)
-	if err != nil {
-		return nil, err
+var audioFormats = []string{"MP3", "FLAC", "OGG", "M4A", "AAC", "OPUS", "WAV", "ALAC", "APE", "WMA"}
+
+// albumHasFormat checks the formats in the song table header, or the first
+// track's download links when the header doesn't list them.
+func albumHasFormat(album *Album, format string) bool {
+	format = strings.ToUpper(format)
+	if len(album.Formats) > 0 {
+		return contains(album.Formats, format)
+	}
+	if len(album.Songs) == 0 {
+		return false
 	}
+	first := album.Songs[0]
+	if len(first.DownloadLinks) == 0 {
+		if err := ParseDownloadLinks(first); err != nil {
+			return false
+		}
+	}
+	_, ok := first.DownloadLinks[format]
+	return ok
+}
+
+func selectDownloadURL(song *Song, downloadFormat string) (string, string) {
+	formatUpper := strings.ToUpper(downloadFormat)
+	if url, ok := song.DownloadLinks[formatUpper]; ok {
+		return url, ""
+	}
+
+	if formatUpper == "FLAC" {
+		// Fallback to MP3 if FLAC not available
+		if url, ok := song.DownloadLinks["MP3"]; ok {
+			return url, "FLAC not available, using MP3"
+		}
+	} else {
+		// Get first available format
+		for _, url := range song.DownloadLinks {
+			return url, ""
+		}
+	}
+
+	// Some tracks are only offered as an archive
+	for _, ext := range []string{"ZIP", "RAR"} {
+		if url, ok := song.DownloadLinks[ext]; ok {
+			return url, ""
+		}
+	}
+	return "", ""
+}
 
+// parseAlbumHTML parses an album page that was already fetched.
+func parseAlbumHTML(albumURL string, body []byte) (*Album, error) {
 	album := &Album{
 		AlbumLink:   albumURL,
 		AlbumImages: make([]string, 0),
 		Songs:       make([]*Song, 0),
+		page:        body,
+	}
+
+	sel := selectors()
+
+	// The song list of a big album is most of the page
+	var table []byte
+	if lowMemory && !sel.songTableChanged() {
+		body, table = cutSongTable(body)
+	}
+	doc, err := newDocument(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
 	}
 
 	// Get album name
-	doc.Find("#pageContent h2").First().Each(func(i int, s *goquery.Selection) {
+	doc.Find(sel.AlbumTitle).First().Each(func(i int, s *selection) {
 		album.Name = strings.TrimSpace(s.Text())
 	})
 
-	// Get album images
-	doc.Find("div.albumImage a").Each(func(i int, s *goquery.Selection) {
+	// Get album metadata (platforms, year, ...)
+	doc.Find(sel.AlbumInfo).EachWithBreak(func(i int, s *selection) bool {
+		if !strings.Contains(s.Text(), "Platforms:") && !strings.Contains(s.Text(), "Year:") {
+			return true
+		}
+		parseAlbumInfo(album, s)
+		return false
+	})
+	parseAlbumRating(album, doc.Find(sel.AlbumText).Text())
+
+	// Get album images, with what their caption or thumbnail says they are
+	captions := make(map[string]string)
+	doc.Find(sel.AlbumImages).Each(func(i int, s *selection) {
 		if href, exists := s.Attr("href"); exists {
 			album.AlbumImages = append(album.AlbumImages, href)
+			title, _ := s.Attr("title")
+			alt, _ := s.Find("img").First().Attr("alt")
+			captions[href] = strings.Join([]string{title, alt, s.Text()}, " ")
 		}
 	})
+	classifyImages(album, captions)
+
+	if table != nil {
+		album.Formats, album.Songs = streamSongTable(table)
+		return album, nil
+	}
 
 	// Parse song list
-	songTable := doc.Find("table#songlist")
+	songTable := doc.Find(sel.SongTable)
 	if songTable.Length() == 0 {
 		return album, nil
 	}
 
+	// The header has a size column per format, and sometimes a bitrate and
+	// disc number
+	sizeColumns := make(map[int]string) // column -> format
+	bitrateColumn, discColumn := -1, -1
+	songTable.Find(sel.SongHeader).Each(func(i int, th *selection) {
+		header := strings.ToUpper(strings.TrimSpace(th.Text()))
+		switch {
+		case contains(audioFormats, header):
+			album.Formats = append(album.Formats, header)
+			sizeColumns[i] = header
+		case header == "BITRATE":
+			bitrateColumn = i
+		case header == "CD" || header == "DISC":
+			discColumn = i
+		}
+	})
+
 	// Parse songs
-	songTable.Find("tbody tr").Each(func(i int, s *goquery.Selection) {
+	songTable.Find(sel.SongRows).Each(func(i int, s *selection) {
 		id, _ := s.Attr("id")
 		if strings.Contains(id, "songlist_footer") {
 			return
@@ -244,7 +913,7 @@ func ParseAlbumPage(albumURL string) (*Album, error) {
 		}
 
 		// Get song name and link
-		s.Find("td.clickable-row a").First().Each(func(j int, a *goquery.Selection) {
+		s.Find(sel.SongLink).First().Each(func(j int, a *selection) {
 			song.Name = strings.TrimSpace(a.Text())
 			if href, exists := a.Attr("href"); exists {
 				song.SongLink = "https://downloads.khinsider.com" + href
@@ -252,11 +921,25 @@ func ParseAlbumPage(albumURL string) (*Album, error) {
 		})
 
 		// Get duration
-		s.Find("td.clickable-row").Eq(1).Each(func(j int, td *goquery.Selection) {
+		s.Find(sel.SongCells).Eq(1).Each(func(j int, td *selection) {
 			duration := strings.TrimSpace(td.Text())
 			song.LengthSeconds = convertToSeconds(duration)
 		})
 
+		// Get sizes and bitrate
+		s.Find("td").Each(func(j int, td *selection) {
+			text := strings.TrimSpace(td.Text())
+			if format, ok := sizeColumns[j]; ok {
+				if kb := parseSizeKB(text); kb > 0 {
+					song.Sizes[format] = kb
+				}
+			} else if j == bitrateColumn {
+				song.Bitrate, _ = strconv.Atoi(strings.TrimSpace(strings.TrimSuffix(strings.ToLower(text), "kbps")))
+			} else if j == discColumn {
+				song.Disc = text
+			}
+		})
+
 		if song.Name != "" {
 			album.Songs = append(album.Songs, song)
 		}
@@ -265,74 +948,165 @@ func ParseAlbumPage(albumURL string) (*Album, error) {
 	return album, nil
 }
 
-func ParseDownloadLinks(song *Song) error {
-	if song.SongLink == "" {
-		return fmt.Errorf("no song link available")
-	}
-
-	doc, err := fetchHTML(song.SongLink)
+func parseAlbumInfo(album *Album, s *selection) {
+	html, err := s.Html()
 	if err != nil {
-		return err
+		return
 	}
 
-	// Find download links
-	doc.Find("#pageContent p a").Each(func(i int, s *goquery.Selection) {
-		href, exists := s.Attr("href")
-		if !exists || !strings.HasPrefix(href, "https://") {
-			return
+	// The info block is a list of "Label: value" lines separated by <br>
+	lineBreak := regexp.MustCompile(`(?i)<br\s*/?>`)
+	for _, line := range lineBreak.Split(html, -1) {
+		frag, err := newDocument(strings.NewReader(line))
+		if err != nil {
+			continue
 		}
 
-		// Extract format from URL
-		ext := strings.ToUpper(filepath.Ext(href))
-		if len(ext) > 1 {
-			ext = ext[1:] // Remove the dot
-			song.DownloadLinks[ext] = href
+		label, value, ok := strings.Cut(frag.Text(), ":")
+		if !ok {
+			continue
 		}
-	})
+		value = strings.TrimSpace(value)
+
+		switch strings.ToLower(strings.TrimSpace(label)) {
+		case "platforms", "platform":
+			for _, p := range strings.Split(value, ",") {
+				if p = strings.TrimSpace(p); p != "" {
+					album.Platforms = append(album.Platforms, p)
+				}
+			}
+		case "year":
+			album.Year = value
+		case "catalog number":
+			album.CatalogNumber = value
+		case "published by":
+			album.Publisher = value
+		case "album type":
+			album.AlbumType = value
+		}
+	}
+}
 
-	return nil
+func fetchHTML(url string) (*document, error) {
+	body, err := fetchPage(url)
+	if err != nil {
+		return nil, err
+	}
+	return newDocument(bytes.NewReader(body))
 }
 
-func fetchHTML(url string) (*goquery.Document, error) {
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+func fetchPage(url string) ([]byte, error) {
+	cached, cachedBody := htmlCache.load(url)
+	if cached != nil && cached.fresh(htmlCache.ttl) {
+		return cachedBody, nil
 	}
 
+	if err := politeWait(url); err != nil {
+		return nil, err
+	}
+
+	client := siteClient()
+
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+	setRequestHeaders(req, "")
+	if cached != nil {
+		cached.addValidators(req)
+	}
 
+	release := acquireHost(url)
+	defer release()
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	metrics.countResponse(resp.StatusCode)
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		htmlCache.touch(cached)
+		return cachedBody, nil
+	}
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("status code: %d", resp.StatusCode)
+		if err := checkChallenge(resp); err != nil {
+			return nil, err
+		}
+		return nil, &httpStatusError{StatusCode: resp.StatusCode}
 	}
 
-	return goquery.NewDocumentFromReader(resp.Body)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	htmlCache.store(url, resp.Header, body)
+	return body, nil
 }
 
-func downloadFile(fileURL, filepath string, maxRetries int) error {
+// downloadFile downloads with retries, resuming partial downloads. It gives
+// up early with errPaused when pause is switched on.
+func downloadFile(fileURL, filepath string, maxRetries int, progress func(read, total int64), pause *pauseSwitch) error {
 	var lastErr error
+	mirrors := alternateMirrors(fileURL)
+	hops := 0
 
 	for attempt := 1; attempt <= maxRetries; attempt++ {
 		if attempt > 1 {
 			// Exponential backoff: 1s, 2s, 4s
 			backoffDuration := time.Duration((1 << (attempt - 2))) * time.Second
-			fmt.Printf("  Retry attempt %d/%d in %v...\n", attempt, maxRetries, backoffDuration)
+			logf("  Retry attempt %d/%d in %v...\n", attempt, maxRetries, backoffDuration)
 			time.Sleep(backoffDuration)
 		}
 
-		lastErr = downloader(fileURL, filepath)
+		waitForSchedule()
+		queuePause.wait()
+		lastErr = downloader(fileURL, filepath, progress, pause)
 		if lastErr == nil {
 			return nil
 		}
+
+		// This download was paused, the partial file is resumed later
+		if errors.Is(lastErr, errPaused) && pause.isPaused() {
+			return lastErr
+		}
+
+		// Pick the transfer up again once the window reopens or the queue
+		// is resumed
+		if errors.Is(lastErr, errOutsideSchedule) || errors.Is(lastErr, errPaused) {
+			attempt--
+			continue
+		}
+
+		// Retrying doesn't get past a challenge
+		if errors.Is(lastErr, errChallenge) {
+			os.Remove(filepath + ".tmp")
+			return lastErr
+		}
+
+		// Download the real file an interstitial page links to
+		var interstitial *interstitialError
+		if errors.As(lastErr, &interstitial) {
+			if interstitial.Next == "" || hops == maxInterstitialHops {
+				os.Remove(filepath + ".tmp")
+				return lastErr
+			}
+			logf("  Got a web page instead of the file, following its link\n")
+			fileURL = interstitial.Next
+			hops++
+			attempt--
+			continue
+		}
+
+		// Move on to another mirror right away if this host is struggling
+		if isServerFailure(lastErr) && len(mirrors) > 0 {
+			fileURL, mirrors = mirrors[0], mirrors[1:]
+			if parsedURL, err := url.Parse(fileURL); err == nil {
+				logf("  %v, switching to mirror %s\n", lastErr, parsedURL.Host)
+			}
+			attempt--
+		}
 	}
 
 	// Clean up
@@ -342,7 +1116,7 @@ func downloadFile(fileURL, filepath string, maxRetries int) error {
 	return fmt.Errorf("download failed after %d attempts: %v", maxRetries, lastErr)
 }
 
-func downloader(fileURL, filepath string) error {
+func downloader(fileURL, filepath string, progress func(read, total int64), pause *pauseSwitch) error {
 	// Parse URL to handle relative paths
 	parsedURL, err := url.Parse(fileURL)
 	if err != nil {
@@ -355,38 +1129,87 @@ func downloader(fileURL, filepath string) error {
 
 	tmpPath := filepath + ".tmp"
 
-	client := &http.Client{
-		Timeout: 60 * time.Second,
-	}
+	client := downloadClient()
 
-	req, err := http.NewRequest("GET", fileURL, nil)
+	ctx, watch := newStallWatch(httpTuning.stallTimeout)
+	defer watch.close()
+	req, err := http.NewRequestWithContext(ctx, "GET", fileURL, nil)
 	if err != nil {
 		return err
 	}
 
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
-	req.Header.Set("Referer", "https://downloads.khinsider.com/")
+	setRequestHeaders(req, siteReferer)
 
+	// Continue a partial download from an earlier attempt or run
+	var offset int64
+	if info, err := os.Stat(tmpPath); err == nil && info.Size() > 0 {
+		offset = info.Size()
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	release := acquireHost(fileURL)
+	defer release()
+	waited := watch.arm()
 	resp, err := client.Do(req)
+	waited()
 	if err != nil {
-		return err
+		return watch.check(err)
 	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{watch.reader(resp.Body), resp.Body}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("status code: %d", resp.StatusCode)
+	metrics.countResponse(resp.StatusCode)
+	if (resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent) && isHTMLResponse(resp) {
+		return readInterstitial(resp)
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	switch resp.StatusCode {
+	case http.StatusOK:
+		offset = 0
+	case http.StatusPartialContent:
+		flags = os.O_WRONLY
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file doesn't match, start over on the next attempt
+		os.Remove(tmpPath)
+		return &httpStatusError{StatusCode: resp.StatusCode}
+	default:
+		if err := checkChallenge(resp); err != nil {
+			return err
+		}
+		return &httpStatusError{StatusCode: resp.StatusCode}
 	}
 
-	out, err := os.Create(tmpPath)
+	out, err := os.OpenFile(tmpPath, flags, 0644)
 	if err != nil {
 		return err
 	}
+	if _, err := out.Seek(offset, io.SeekStart); err != nil {
+		out.Close()
+		return err
+	}
 
-	_, err = io.Copy(out, resp.Body)
+	total := resp.ContentLength
+	if total >= 0 {
+		total += offset
+	}
+	preallocateFile(out, total)
+	body := &pausableReader{r: scheduled(throttle(resp.Body)), pause: pause}
+	n, err := copyToFile(out, &progressReader{r: body, read: offset, total: total, report: progress})
+	metrics.bytes.Add(n)
+	caps.add(n)
+	if err != nil {
+		// Space reserved past the end would be taken for downloaded data
+		if end, seekErr := out.Seek(0, io.SeekCurrent); seekErr == nil {
+			out.Truncate(end)
+		}
+	}
 	out.Close()
 
+	// The partial file stays for the next attempt to resume
 	if err != nil {
-		os.Remove(tmpPath)
 		return err
 	}
 
@@ -394,8 +1217,67 @@ func downloader(fileURL, filepath string) error {
 	err = os.Rename(tmpPath, filepath)
 	if err != nil {
 		os.Remove(tmpPath)
+		return err
+	}
+
+	// Keep the server's timestamp so re-downloads look identical
+	if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		os.Chtimes(filepath, lastModified, lastModified)
+	}
+	return nil
+}
+
+// trackBytes is the track's size from the preflight, or else as listed in
+// the track list.
+func trackBytes(song *Song, format string) int64 {
+	if song.ContentLength > 0 {
+		return song.ContentLength
+	}
+	return int64(song.Sizes[strings.ToUpper(format)]) * 1024
+}
+
+// sizeMatchesList compares a downloaded file with the size in the track
+// list, which is rounded to 10 KB or so.
+func sizeMatchesList(song *Song, path string) bool {
+	listed := int64(song.Sizes[strings.ToUpper(strings.TrimPrefix(filepath.Ext(path), "."))]) * 1024
+	info, err := os.Stat(path)
+	if listed == 0 || err != nil {
+		return true
+	}
+	diff := info.Size() - listed
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= max(listed/50, 64*1024)
+}
+
+// parseSizeKB parses sizes from the track list like "3.45 MB" into KB.
+func parseSizeKB(text string) int {
+	fields := strings.Fields(text)
+	if len(fields) != 2 {
+		return 0
+	}
+	n, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0
+	}
+	switch strings.ToUpper(fields[1]) {
+	case "KB":
+		return int(n)
+	case "MB":
+		return int(n * 1024)
+	case "GB":
+		return int(n * 1024 * 1024)
 	}
-	return err
+	return 0
+}
+
+func albumReleaseTime(album *Album) (time.Time, bool) {
+	year, err := strconv.Atoi(strings.TrimSpace(album.Year))
+	if err != nil || year <= 0 {
+		return time.Time{}, false
+	}
+	return time.Date(year, time.January, 1, 0, 0, 0, 0, time.Local), true
 }
 
 func convertToSeconds(duration string) int {
@@ -410,22 +1292,6 @@ func convertToSeconds(duration string) int {
 	return minutes*60 + seconds
 }
 
-func sanitizeFilename(name string) string {
-	// Remove invalid characters
-	reg := regexp.MustCompile(`[<>:"/\\|?*]`)
-	name = reg.ReplaceAllString(name, "")
-
-	// Replace spaces with underscores
-	//name = strings.ReplaceAll(name, " ", "_")
-
-	// Limit length
-	if len(name) > 200 {
-		name = name[:200]
-	}
-
-	return name
-}
-
 func contains(slice []string, item string) bool {
 	for _, s := range slice {
 		if s == item {
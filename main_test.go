@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMirrors serves the requests for every host, which the download client
+// is pointed at as its proxy. handle gets the host asked for.
+func fakeMirrors(t *testing.T, handle func(host string, w http.ResponseWriter, r *http.Request)) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handle(r.Host, w, r)
+	}))
+	t.Cleanup(srv.Close)
+	proxy, _ := url.Parse(srv.URL)
+
+	initClients()
+	client := fileClient
+	fileClient = &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxy)}}
+	t.Cleanup(func() { fileClient = client })
+}
+
+func TestDownloadFileResumes(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	var mu sync.Mutex
+	var ranges []string
+	fakeMirrors(t, func(_ string, w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		http.ServeContent(w, r, "01.mp3", time.Time{}, bytes.NewReader(content))
+	})
+
+	path := filepath.Join(t.TempDir(), "01.mp3")
+	if err := os.WriteFile(path+".tmp", content[:4000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	var last int64
+	err := downloadFile("http://vgmsite.com/soundtracks/a/01.mp3", path, 3, func(read, total int64) { last = read }, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if !bytes.Equal(data, content) {
+		t.Errorf("downloaded %d bytes, want the %d of the file", len(data), len(content))
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("partial file left behind: %v", err)
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=4000-" {
+		t.Errorf("requested ranges %q, want [bytes=4000-]", ranges)
+	}
+	if last != int64(len(content)) {
+		t.Errorf("progress ended at %d, want %d", last, len(content))
+	}
+}
+
+// A partial file that doesn't fit the server's (416) is dropped and the
+// next attempt starts over.
+func TestDownloadFileRestartsUnsatisfiableRange(t *testing.T) {
+	content := []byte("the whole file")
+	fakeMirrors(t, func(_ string, w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "01.mp3", time.Time{}, bytes.NewReader(content))
+	})
+
+	path := filepath.Join(t.TempDir(), "01.mp3")
+	if err := os.WriteFile(path+".tmp", bytes.Repeat([]byte("x"), 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := downloadFile("http://vgmsite.com/soundtracks/a/01.mp3", path, 2, func(int64, int64) {}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, content) {
+		t.Errorf("downloaded %q, want %q", data, content)
+	}
+}
+
+// A transfer that breaks off keeps its partial file, and the retry asks for
+// the rest.
+func TestDownloadFileResumesBrokenTransfer(t *testing.T) {
+	content := bytes.Repeat([]byte("abcdefghij"), 1000)
+	var mu sync.Mutex
+	var ranges []string
+	fakeMirrors(t, func(_ string, w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		first := len(ranges) == 1
+		mu.Unlock()
+		if first {
+			// Half the file, then the connection breaks
+			w.Header().Set("Content-Length", "10000")
+			w.Write(content[:5000])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "01.mp3", time.Time{}, bytes.NewReader(content))
+	})
+
+	path := filepath.Join(t.TempDir(), "01.mp3")
+	if err := downloadFile("http://example.com/01.mp3", path, 2, func(int64, int64) {}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, content) {
+		t.Errorf("downloaded %d bytes, want the %d of the file", len(data), len(content))
+	}
+	if want := []string{"", "bytes=5000-"}; strings.Join(ranges, ",") != strings.Join(want, ",") {
+		t.Errorf("requested ranges %q, want %q", ranges, want)
+	}
+}
+
+// A struggling mirror is left for the next one right away, without using
+// up an attempt, and the partial file is resumed there.
+func TestDownloadFileFailsOver(t *testing.T) {
+	content := bytes.Repeat([]byte("abcdefghij"), 1000)
+	var mu sync.Mutex
+	var asked []string
+	fakeMirrors(t, func(host string, w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		asked = append(asked, host+" "+r.Header.Get("Range"))
+		mu.Unlock()
+		switch host {
+		case "vgmsite.com":
+			w.WriteHeader(http.StatusBadGateway)
+		case "vgmdownloads.com":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			http.ServeContent(w, r, "01.mp3", time.Time{}, bytes.NewReader(content))
+		}
+	})
+
+	path := filepath.Join(t.TempDir(), "01.mp3")
+	if err := os.WriteFile(path+".tmp", content[:3000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := downloadFile("http://vgmsite.com/soundtracks/a/01.mp3", path, 1, func(int64, int64) {}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, content) {
+		t.Errorf("downloaded %d bytes, want the %d of the file", len(data), len(content))
+	}
+	want := []string{"vgmsite.com bytes=3000-", "vgmdownloads.com bytes=3000-", "eta.vgmtreasurechest.com bytes=3000-"}
+	if strings.Join(asked, ", ") != strings.Join(want, ", ") {
+		t.Errorf("requests %q, want %q", asked, want)
+	}
+}
+
+// A missing file isn't looked for on the other mirrors.
+func TestDownloadFileNoFailoverOnNotFound(t *testing.T) {
+	var mu sync.Mutex
+	var hosts []string
+	fakeMirrors(t, func(host string, w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hosts = append(hosts, host)
+		mu.Unlock()
+		http.NotFound(w, r)
+	})
+
+	path := filepath.Join(t.TempDir(), "01.mp3")
+	err := downloadFile("http://vgmsite.com/soundtracks/a/01.mp3", path, 1, func(int64, int64) {}, nil)
+	if err == nil || !strings.Contains(err.Error(), "status code: 404") {
+		t.Errorf("downloadFile error = %v, want a 404", err)
+	}
+	if len(hosts) != 1 {
+		t.Errorf("asked %q, want only vgmsite.com", hosts)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file created for a failed download: %v", err)
+	}
+}
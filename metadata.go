@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+const metadataFilename = "album.json"
+
+// Field names follow beets' album and item fields so the file can be fed to
+// beets plugins (or anything else) without translation.
+type albumMetadata struct {
+	Album      string          `json:"album"`
+	Year       string          `json:"year,omitempty"`
+	CatalogNum string          `json:"catalognum,omitempty"`
+	Label      string          `json:"label,omitempty"`
+	AlbumType  string          `json:"albumtype,omitempty"`
+	Media      string          `json:"media,omitempty"`
+	Genre      string          `json:"genre,omitempty"`
+	Platforms  []string        `json:"platforms,omitempty"`
+	Rating     float64         `json:"rating,omitempty"` // out of 5
+	Votes      int             `json:"votes,omitempty"`
+	Downloads  int             `json:"downloads,omitempty"`
+	SourceURL  string          `json:"source_url"`
+	Images     []imageMetadata `json:"images,omitempty"`
+	Formats    map[string]int  `json:"formats,omitempty"`  // downloaded tracks by format
+	Lossless   *bool           `json:"lossless,omitempty"` // whether all of them are lossless
+	Tracks     []trackMetadata `json:"tracks"`
+}
+
+type imageMetadata struct {
+	URL  string `json:"url"`
+	Type string `json:"type"` // front, back, disc, inlay, booklet or other
+}
+
+type trackMetadata struct {
+	Track    int    `json:"track"`
+	Title    string `json:"title"`
+	Length   int    `json:"length,omitempty"`
+	Path     string `json:"path,omitempty"`
+	URL      string `json:"source_url,omitempty"`
+	SizeKB   int    `json:"size_kb,omitempty"` // in the track list, to notice replaced rips
+	Format   string `json:"format,omitempty"`
+	Fallback bool   `json:"fallback,omitempty"` // not in the format asked for
+}
+
+func writeAlbumMetadata(album *Album, tagMap *TagMapping, dir string) error {
+	data, err := json.MarshalIndent(albumMetadataFor(album, tagMap), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, metadataFilename), data, 0644)
+}
+
+func albumMetadataFor(album *Album, tagMap *TagMapping) albumMetadata {
+	tags := tagMap.TagsFor(album)
+
+	meta := albumMetadata{
+		Album:      album.Name,
+		Year:       album.Year,
+		CatalogNum: album.CatalogNumber,
+		Label:      album.Publisher,
+		AlbumType:  album.AlbumType,
+		Media:      tags["MEDIA"],
+		Genre:      tags["GENRE"],
+		Platforms:  album.Platforms,
+		Rating:     album.Rating,
+		Votes:      album.Votes,
+		Downloads:  album.Downloads,
+		SourceURL:  album.AlbumLink,
+		Tracks:     make([]trackMetadata, 0, len(album.Songs)),
+	}
+
+	for _, img := range album.AlbumImages {
+		meta.Images = append(meta.Images, imageMetadata{URL: img, Type: album.ImageTypes[img]})
+	}
+	for i, song := range album.Songs {
+		meta.Tracks = append(meta.Tracks, trackMetadata{
+			Track:    i + 1,
+			Title:    song.Name,
+			Length:   song.LengthSeconds,
+			Path:     song.Filename,
+			URL:      song.SongLink,
+			SizeKB:   listedSizeKB(song),
+			Format:   songFormat(song),
+			Fallback: song.Fallback,
+		})
+	}
+	if counts := formatCounts(album); len(counts) > 0 {
+		lossless := allLossless(counts)
+		meta.Formats = counts
+		meta.Lossless = &lossless
+	}
+
+	return meta
+}
+
+func runBeetsImport(dir string) error {
+	cmd := exec.Command("beet", "import", "-A", dir)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+type downloadMetrics struct {
+	started    atomic.Int64
+	completed  atomic.Int64
+	failed     atomic.Int64
+	bytes      atomic.Int64
+	queueDepth atomic.Int64
+
+	mu        sync.Mutex
+	responses map[int]int64 // HTTP status code -> count
+}
+
+var metrics = &downloadMetrics{responses: make(map[int]int64)}
+
+func (m *downloadMetrics) countResponse(code int) {
+	m.mu.Lock()
+	m.responses[code]++
+	m.mu.Unlock()
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *downloadMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	writeMetric := func(name, kind, help string, value int64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+	}
+	writeMetric("khinsider_downloads_started_total", "counter", "Track downloads started.", m.started.Load())
+	writeMetric("khinsider_downloads_completed_total", "counter", "Track downloads completed.", m.completed.Load())
+	writeMetric("khinsider_downloads_failed_total", "counter", "Tracks that failed to download.", m.failed.Load())
+	writeMetric("khinsider_bytes_transferred_total", "counter", "Bytes downloaded.", m.bytes.Load())
+	writeMetric("khinsider_queue_depth", "gauge", "Albums waiting in the queue.", m.queueDepth.Load())
+
+	m.mu.Lock()
+	codes := make([]int, 0, len(m.responses))
+	for code := range m.responses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+
+	fmt.Fprintf(w, "# HELP khinsider_http_responses_total HTTP responses by status code.\n")
+	fmt.Fprintf(w, "# TYPE khinsider_http_responses_total counter\n")
+	for _, code := range codes {
+		fmt.Fprintf(w, "khinsider_http_responses_total{code=\"%d\"} %d\n", code, m.responses[code])
+	}
+	m.mu.Unlock()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Hosts that serve the same files under the same paths.
+var mirrorHosts = []string{
+	"vgmsite.com",
+	"vgmdownloads.com",
+	"eta.vgmtreasurechest.com",
+	"lambda.vgmtreasurechest.com",
+}
+
+// Domains whose numbered/lettered subdomains are file mirrors.
+var mirrorDomains = []string{"vgmsite.com", "vgmdownloads.com", "vgmtreasurechest.com"}
+
+type httpStatusError struct {
+	StatusCode int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("status code: %d", e.StatusCode)
+}
+
+// alternateMirrors returns the file URL rewritten to every other mirror
+// host, or nothing if the URL isn't served from a known mirror.
+func alternateMirrors(fileURL string) []string {
+	parsedURL, err := url.Parse(fileURL)
+	if err != nil || !isMirrorHost(parsedURL.Hostname()) {
+		return nil
+	}
+
+	var alternates []string
+	for _, host := range mirrorHosts {
+		if strings.EqualFold(host, parsedURL.Hostname()) {
+			continue
+		}
+		alt := *parsedURL
+		alt.Host = host
+		alternates = append(alternates, alt.String())
+	}
+	return alternates
+}
+
+func isMirrorHost(host string) bool {
+	host = strings.ToLower(host)
+	for _, h := range mirrorHosts {
+		if host == h {
+			return true
+		}
+	}
+	for _, domain := range mirrorDomains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// isServerFailure reports whether an error means the host itself is
+// having trouble (5xx or a timeout), as opposed to the file missing.
+func isServerFailure(err error) bool {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// What --on-missing-format does with a track not offered in --format.
+const (
+	missingFallback = "fallback" // download another format, as always
+	missingSkip     = "skip"
+	missingAsk      = "ask"
+)
+
+// promptMu keeps prompts of albums downloading at the same time apart. The
+// reader is shared, so nothing typed ahead is lost between prompts.
+var (
+	promptMu     sync.Mutex
+	promptReader *bufio.Reader
+)
+
+// formatChooser decides on tracks of one album that aren't offered in the
+// wanted format. With ask, an answer can be kept for the rest of the album.
+type formatChooser struct {
+	opts   *Options
+	choice string // "skip", a format, or "" to ask again
+}
+
+func newFormatChooser(opts *Options) *formatChooser {
+	return &formatChooser{opts: opts}
+}
+
+// missingFormat reports whether a song isn't offered in the wanted format,
+// or with --prefer in no format of the same kind.
+func missingFormat(song *Song, opts *Options) bool {
+	wanted := strings.ToUpper(opts.Format)
+	if _, ok := song.DownloadLinks[wanted]; ok {
+		return false
+	}
+	if opts.Prefer != "" {
+		for format := range song.DownloadLinks {
+			if contains(audioFormats, format) && isLossless(format) == isLossless(wanted) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// choose returns the URL to download for a song, a note about the choice,
+// and whether to skip the song instead.
+func (c *formatChooser) choose(song *Song) (string, string, bool) {
+	downloadURL, note := chooseDownloadURL(song, c.opts)
+	if downloadURL == "" || !missingFormat(song, c.opts) {
+		return downloadURL, note, false
+	}
+
+	choice := c.choice
+	switch {
+	case c.opts.OnMissingFormat == missingSkip:
+		choice = missingSkip
+	case c.opts.OnMissingFormat == missingAsk && choice == "":
+		choice = c.ask(song, urlFormat(downloadURL))
+	}
+
+	switch choice {
+	case "":
+		return downloadURL, note, false
+	case missingSkip:
+		return "", "", true
+	}
+	if url, ok := song.DownloadLinks[choice]; ok {
+		return url, fmt.Sprintf("%s not available, using %s", strings.ToUpper(c.opts.Format), choice), false
+	}
+	// The format kept for the album isn't offered for this track
+	return downloadURL, note, false
+}
+
+// ask prompts for a format of the ones offered, or to skip the track. An
+// answer ending in ! is kept for the rest of the album.
+func (c *formatChooser) ask(song *Song, fallback string) string {
+	var offered []string
+	for format := range song.DownloadLinks {
+		offered = append(offered, format)
+	}
+	slices.Sort(offered)
+
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	if promptReader == nil {
+		promptReader = bufio.NewReader(os.Stdin)
+	}
+	for {
+		fmt.Fprintf(os.Stderr, "  %s isn't available for %s. Download %s, s to skip (add ! for the rest of the album) [%s]: ",
+			strings.ToUpper(c.opts.Format), song.Name, strings.Join(offered, ", "), fallback)
+		line, err := promptReader.ReadString('\n')
+		if err != nil && line == "" {
+			return ""
+		}
+		answer := strings.ToUpper(strings.TrimSpace(line))
+		answer, keep := strings.CutSuffix(answer, "!")
+		switch {
+		case answer == "":
+			answer = fallback
+		case answer == "S":
+			answer = missingSkip
+		case !slices.Contains(offered, answer):
+			fmt.Fprintf(os.Stderr, "  Not offered: %s\n", answer)
+			continue
+		}
+		if keep {
+			c.choice = answer
+		}
+		return answer
+	}
+}
+
+// urlFormat is the format of a download URL by its extension, e.g. MP3.
+func urlFormat(downloadURL string) string {
+	return strings.ToUpper(strings.TrimPrefix(path.Ext(strings.SplitN(downloadURL, "?", 2)[0]), "."))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// nameRules controls how titles become file and folder names.
+type nameRules struct {
+	ASCII       bool   // transliterate to ASCII, tags keep the original titles
+	Replacement string // replaces characters that are invalid in file names
+	Underscores bool   // spaces become underscores
+	Lowercase   bool
+	MaxLength   int // in bytes, extensions are kept
+}
+
+var naming = nameRules{MaxLength: 200}
+
+var invalidFilenameChars = regexp.MustCompile(`[<>:"/\\|?*\x00-\x1f]`)
+
+func sanitizeFilename(name string) string {
+	if naming.ASCII {
+		// Names written only in kanji have nothing left
+		if name = toASCII(name); name == "" {
+			name = "_"
+		}
+	}
+
+	// Remove invalid characters
+	name = invalidFilenameChars.ReplaceAllString(name, naming.Replacement)
+
+	if naming.Underscores {
+		name = strings.ReplaceAll(name, " ", "_")
+	}
+	if naming.Lowercase {
+		name = strings.ToLower(name)
+	}
+
+	// Windows doesn't allow names ending in a dot or space
+	name = strings.TrimRight(name, ". ")
+
+	return truncateName(name, naming.MaxLength)
+}
+
+// truncateName shortens name to at most max bytes without splitting a
+// character or cutting off the extension.
+func truncateName(name string, max int) string {
+	if max <= 0 || len(name) <= max {
+		return name
+	}
+	ext := filepath.Ext(name)
+	if len(ext) > 10 || len(ext) >= max {
+		ext = ""
+	}
+	base := strings.TrimSuffix(name, ext)
+	cut := max - len(ext)
+	for cut > 0 && !utf8.RuneStart(base[cut]) {
+		cut--
+	}
+	return strings.TrimRight(base[:cut], ". ") + ext
+}
+
+// trackNames hands out the file names of an album's tracks, so two tracks
+// with the same name don't overwrite each other.
+type trackNames struct {
+	used    map[string]int // lowercased file name -> track number
+	renamed int
+}
+
+func newTrackNames() *trackNames {
+	return &trackNames{used: make(map[string]int)}
+}
+
+// claim returns name for the track, or "name (track).ext" when an earlier
+// track has it, along with the number of that track.
+func (n *trackNames) claim(name string, track int) (string, int) {
+	first := n.used[strings.ToLower(name)]
+	if first != 0 {
+		ext := filepath.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+		name = fmt.Sprintf("%s (%d)%s", base, track, ext)
+		// An archive can hold several files of the same name
+		for k := 2; n.used[strings.ToLower(name)] != 0; k++ {
+			name = fmt.Sprintf("%s (%d-%d)%s", base, track, k, ext)
+		}
+		n.renamed++
+	}
+	n.used[strings.ToLower(name)] = track
+	return name, first
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notifier receives the download lifecycle events: album_started,
+// track_completed, track_failed, album_completed, album_failed and
+// failure_threshold, plus the progress events for --progress json.
+type Notifier interface {
+	Notify(ev progressEvent) error
+}
+
+var (
+	notifiersMu sync.Mutex
+	notifiers   []Notifier
+)
+
+func addNotifier(n Notifier) {
+	notifiersMu.Lock()
+	notifiers = append(notifiers, n)
+	notifiersMu.Unlock()
+}
+
+// parseNotifier creates a notifier from a --notify value: stdout, desktop,
+// webhook:<url>, discord:<url> or exec:<command>.
+func parseNotifier(spec string) (Notifier, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "stdout":
+		return stdoutNotifier{}, nil
+	case "desktop":
+		return queueNotifier(desktopNotifier{}), nil
+	case "webhook", "discord", "exec":
+	default:
+		return nil, fmt.Errorf("unknown notifier: %s", kind)
+	}
+	if arg == "" {
+		return nil, fmt.Errorf("%s needs an argument, like %s:<%s>", kind, kind, notifierArg[kind])
+	}
+
+	switch kind {
+	case "webhook":
+		return queueNotifier(webhookNotifier{url: arg}), nil
+	case "discord":
+		return queueNotifier(discordNotifier{url: arg}), nil
+	}
+	return queueNotifier(execNotifier{command: arg}), nil
+}
+
+var notifierArg = map[string]string{"webhook": "url", "discord": "url", "exec": "command"}
+
+// isLifecycleEvent leaves out the frequent progress events.
+func isLifecycleEvent(event string) bool {
+	switch event {
+	case "album_started", "track_completed", "track_failed", "album_completed", "album_failed", "failure_threshold":
+		return true
+	}
+	return false
+}
+
+// notifyQueueSize is how many events a slow notifier can fall behind
+// before new ones are dropped.
+const notifyQueueSize = 256
+
+// notifyFlushTimeout limits how long the program waits for queued
+// notifications before it exits, about one webhook timeout.
+const notifyFlushTimeout = 30 * time.Second
+
+// pendingNotifications counts the events still waiting in the queues.
+var pendingNotifications sync.WaitGroup
+
+// queuedNotifier sends the lifecycle events to a notifier that may be slow,
+// like a webhook or a command, from a goroutine of its own, so a hanging
+// endpoint doesn't hold up the download.
+type queuedNotifier struct {
+	next   Notifier
+	events chan progressEvent
+}
+
+func queueNotifier(n Notifier) *queuedNotifier {
+	q := &queuedNotifier{next: n, events: make(chan progressEvent, notifyQueueSize)}
+	go q.run()
+	return q
+}
+
+func (q *queuedNotifier) Notify(ev progressEvent) error {
+	if !isLifecycleEvent(ev.Event) {
+		return nil
+	}
+	pendingNotifications.Add(1)
+	select {
+	case q.events <- ev:
+		return nil
+	default:
+		pendingNotifications.Done()
+		return fmt.Errorf("%d notifications are still waiting, dropped this one", notifyQueueSize)
+	}
+}
+
+func (q *queuedNotifier) run() {
+	for ev := range q.events {
+		if err := q.next.Notify(ev); err != nil {
+			logf("Error sending %s notification: %v\n", ev.Event, err)
+		}
+		pendingNotifications.Done()
+	}
+}
+
+// flushNotifications waits up to timeout for the queued notifications to
+// go out, so the last album's aren't lost when the program exits.
+func flushNotifications(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		pendingNotifications.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		logf("Gave up waiting for notifications to be sent\n")
+	}
+}
+
+// stdoutNotifier prints every event as a JSON line, for --progress json.
+type stdoutNotifier struct{}
+
+var stdoutMu sync.Mutex
+
+func (stdoutNotifier) Notify(ev progressEvent) error {
+	stdoutMu.Lock()
+	defer stdoutMu.Unlock()
+	return json.NewEncoder(os.Stdout).Encode(ev)
+}
+
+// webhookNotifier posts the lifecycle events as JSON.
+type webhookNotifier struct {
+	url string
+}
+
+func (n webhookNotifier) Notify(ev progressEvent) error {
+	if !isLifecycleEvent(ev.Event) {
+		return nil
+	}
+	return postWebhook(n.url, ev)
+}
+
+// desktopNotifier shows finished and failed albums and failure alerts.
+type desktopNotifier struct{}
+
+func (desktopNotifier) Notify(ev progressEvent) error {
+	switch ev.Event {
+	case "album_completed":
+		message := fmt.Sprintf("%d tracks downloaded", ev.Successful)
+		if ev.Failed > 0 {
+			message += fmt.Sprintf(", %d failed", ev.Failed)
+		}
+		return desktopNotify(ev.Album, message)
+	case "album_failed":
+		return desktopNotify(programName+": download failed", strings.TrimSpace(ev.Album+" "+ev.Error))
+	case "failure_threshold":
+		return desktopNotify(programName+": downloads are failing", ev.Error)
+	}
+	return nil
+}
+
+// execNotifier runs a command for every lifecycle event, with the event as
+// JSON on stdin and its main fields in KHINSIDER_* environment variables.
+type execNotifier struct {
+	command string
+}
+
+func (n execNotifier) Notify(ev progressEvent) error {
+	if !isLifecycleEvent(ev.Event) {
+		return nil
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", n.command)
+	} else {
+		cmd = exec.Command("sh", "-c", n.command)
+	}
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = logOutput
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"KHINSIDER_EVENT="+ev.Event,
+		"KHINSIDER_ALBUM="+ev.Album,
+		"KHINSIDER_TRACK="+ev.Track,
+		"KHINSIDER_FILE="+ev.File,
+		"KHINSIDER_ERROR="+ev.Error,
+		"KHINSIDER_SUCCESSFUL="+strconv.Itoa(ev.Successful),
+		"KHINSIDER_FAILED="+strconv.Itoa(ev.Failed),
+	)
+	return cmd.Run()
+}
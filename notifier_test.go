@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookNotifierDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer hanging.Close()
+	defer close(release)
+
+	n, err := parseNotifier("webhook:" + hanging.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifiersMu.Lock()
+	saved := notifiers
+	notifiers = []Notifier{n}
+	notifiersMu.Unlock()
+	defer func() {
+		notifiersMu.Lock()
+		notifiers = saved
+		notifiersMu.Unlock()
+	}()
+
+	start := time.Now()
+	for i := range 20 {
+		emitProgress(progressEvent{Event: "track_completed", Album: "Album", Index: i + 1, Total: 20})
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("20 track events took %v with a hanging webhook, want them queued", elapsed)
+	}
+}
+
+func TestQueuedNotifierDropsWhenFull(t *testing.T) {
+	n := blockingNotifier{started: make(chan struct{}, 1), block: make(chan struct{})}
+	q := queueNotifier(n)
+	defer close(n.block)
+
+	// One event is being sent, then notifyQueueSize can wait
+	q.Notify(progressEvent{Event: "track_completed"})
+	<-n.started
+	var dropped int
+	for range notifyQueueSize + 9 {
+		if q.Notify(progressEvent{Event: "track_completed"}) != nil {
+			dropped++
+		}
+	}
+	if dropped != 9 {
+		t.Errorf("dropped %d events, want 9", dropped)
+	}
+	if err := q.Notify(progressEvent{Event: "track_progress"}); err != nil {
+		t.Errorf("progress events should be skipped, not queued: %v", err)
+	}
+}
+
+type blockingNotifier struct {
+	started chan struct{}
+	block   chan struct{}
+}
+
+func (n blockingNotifier) Notify(progressEvent) error {
+	select {
+	case n.started <- struct{}{}:
+	default:
+	}
+	<-n.block
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// desktopNotify shows a desktop notification with the platform's tools.
+func desktopNotify(title, message string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %q with title %q", message, title)
+		cmd = exec.Command("osascript", "-e", script)
+	case "windows":
+		quote := func(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" }
+		script := "Add-Type -AssemblyName System.Windows.Forms; " +
+			"$n = New-Object System.Windows.Forms.NotifyIcon; " +
+			"$n.Icon = [System.Drawing.SystemIcons]::Information; $n.Visible = $true; " +
+			"$n.ShowBalloonTip(10000, " + quote(title) + ", " + quote(message) + ", 'None'); Start-Sleep -Seconds 10"
+		// The balloon disappears with the process, so don't wait for it
+		return exec.Command("powershell", "-NoProfile", "-Command", script).Start()
+	default:
+		cmd = exec.Command("notify-send", "--app-name="+programName, title, message)
+	}
+	return cmd.Run()
+}
+
+// postWebhook posts payload as JSON.
+func postWebhook(webhookURL string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	req, err := http.NewRequest("POST", webhookURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", programName+"/"+version)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status code: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// directoryProvider reads a plain HTTP directory listing (Apache, nginx,
+// a personal file server, ...) of audio files as an album: the folder name
+// is the album name and the file names give the track order and titles. The
+// same track in several formats ("01 Title.flac", "01 Title.mp3") is one
+// track with a link per format.
+type directoryProvider struct{}
+
+func (directoryProvider) Name() string { return "directory listing" }
+
+// Hosts is empty; providerFor falls back to this provider.
+func (directoryProvider) Hosts() []string { return nil }
+
+func (directoryProvider) ResolveAlbum(input string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(input))
+	if err != nil {
+		return "", newClassError(errInvalidInput, err.Error())
+	}
+	// Listings are folders, and relative links only resolve under the slash
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+		u.RawPath = ""
+	}
+	u.RawQuery, u.Fragment = "", ""
+	return u.String(), nil
+}
+
+var imageExtensions = []string{".jpg", ".jpeg", ".png", ".gif", ".webp"}
+
+func (directoryProvider) ListTracks(albumURL string) (*Album, error) {
+	body, err := fetchPage(albumURL)
+	if err != nil {
+		return nil, err
+	}
+	base, err := url.Parse(albumURL)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := newDocument(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	name, _ := url.PathUnescape(path.Base(strings.TrimSuffix(base.Path, "/")))
+	if name == "" || name == "." || name == "/" {
+		name = base.Hostname()
+	}
+	album := &Album{
+		Name:        name,
+		AlbumLink:   albumURL,
+		AlbumImages: make([]string, 0),
+		Songs:       make([]*Song, 0),
+		page:        body,
+	}
+
+	tracks := make(map[string]*Song) // file name without extension -> track
+	var stems []string
+	seen := make(map[string]bool)
+	doc.Find("a[href]").Each(func(i int, a *selection) {
+		href, _ := a.Attr("href")
+		ref, err := url.Parse(href)
+		if err != nil || ref.RawQuery != "" {
+			return // e.g. the ?C=N;O=D sort links
+		}
+		fileURL := base.ResolveReference(ref)
+		// Only files directly in the folder
+		if path.Dir(fileURL.Path)+"/" != base.Path || fileURL.Host != base.Host || seen[fileURL.String()] {
+			return
+		}
+		seen[fileURL.String()] = true
+
+		filename, err := url.PathUnescape(path.Base(fileURL.Path))
+		if err != nil {
+			return
+		}
+		ext := strings.ToLower(path.Ext(filename))
+		format := strings.ToUpper(strings.TrimPrefix(ext, "."))
+		switch {
+		case contains(imageExtensions, ext):
+			album.AlbumImages = append(album.AlbumImages, fileURL.String())
+		case contains(audioFormats, format):
+			stem := strings.TrimSuffix(filename, path.Ext(filename))
+			song, ok := tracks[stem]
+			if !ok {
+				song = &Song{
+					Name:          trackTitle(stem),
+					SongLink:      fileURL.String(),
+					DownloadLinks: make(map[string]string),
+					Sizes:         make(map[string]int),
+				}
+				tracks[stem] = song
+				stems = append(stems, stem)
+			}
+			song.DownloadLinks[format] = fileURL.String()
+			if !contains(album.Formats, format) {
+				album.Formats = append(album.Formats, format)
+			}
+		}
+	})
+
+	sort.Slice(stems, func(i, j int) bool { return naturalLess(stems[i], stems[j]) })
+	for _, stem := range stems {
+		album.Songs = append(album.Songs, tracks[stem])
+	}
+	sort.Slice(album.AlbumImages, func(i, j int) bool { return coverFirst(album.AlbumImages[i], album.AlbumImages[j]) })
+	classifyImages(album, nil)
+	return album, nil
+}
+
+func (directoryProvider) ResolveDownload(song *Song) error {
+	if len(song.DownloadLinks) == 0 {
+		addFileLink(song, song.SongLink)
+	}
+	return nil
+}
+
+var trackNumberPrefix = regexp.MustCompile(`^(\d+[-.]?)?\d+(\s*[-._)]\s*|\s+)`)
+
+// trackTitle is a file name without the track number in front, e.g.
+// "Title" for "01 - Title" or "1-03. Title".
+func trackTitle(stem string) string {
+	if title := trackNumberPrefix.ReplaceAllString(stem, ""); strings.TrimSpace(title) != "" {
+		return strings.TrimSpace(title)
+	}
+	return stem
+}
+
+var digitRuns = regexp.MustCompile(`\d+|\D+`)
+
+// naturalLess orders names with the numbers in them compared by value, so
+// "2 Title" comes before "10 Title" also without leading zeros.
+func naturalLess(a, b string) bool {
+	pa, pb := digitRuns.FindAllString(strings.ToLower(a), -1), digitRuns.FindAllString(strings.ToLower(b), -1)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		if pa[i] == pb[i] {
+			continue
+		}
+		na, errA := strconv.Atoi(pa[i])
+		nb, errB := strconv.Atoi(pb[i])
+		if errA == nil && errB == nil && na != nb {
+			return na < nb
+		}
+		return pa[i] < pb[i]
+	}
+	return len(pa) < len(pb)
+}
+
+// coverFirst sorts an image named like a cover before the other scans.
+func coverFirst(a, b string) bool {
+	ca := strings.Contains(strings.ToLower(path.Base(a)), "cover") || strings.Contains(strings.ToLower(path.Base(a)), "folder")
+	cb := strings.Contains(strings.ToLower(path.Base(b)), "cover") || strings.Contains(strings.ToLower(path.Base(b)), "folder")
+	if ca != cb {
+		return ca
+	}
+	return naturalLess(a, b)
+}
@@ -0,0 +1,910 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Options struct {
+	Format          string
+	OutputDir       string
+	Images          bool
+	Tags            bool
+	TagMap          *TagMapping
+	WriteMetadata   bool
+	BeetsImport     bool
+	ArchiveFormat   string
+	ArchiveDelete   bool
+	Preflight       bool
+	MtimeFromYear   bool
+	DownloadArchive string
+	Clipboard       bool
+	// AlbumConcurrency is how many albums of a batch download at once
+	AlbumConcurrency int
+	// Library is the root of the library albums are placed in by
+	// LibraryLayout, instead of OutputDir
+	Library       string
+	LibraryLayout string
+	Dedupe        string // hardlink, symlink, copy or skip tracks found in other albums
+	// AlbumDelay is the pause before each further album of a batch, plus
+	// up to AlbumJitter at random
+	AlbumDelay    time.Duration
+	AlbumJitter   time.Duration
+	Flat          bool   // images next to the tracks instead of ArtDir
+	ArtDir        string // folder for the album images
+	ArtArchive    bool   // bundle the images into Scans.zip
+	SavePage      bool   // keep album.html next to the tracks
+	SaveSongPages bool   // and the song pages in pages/
+	RequireFormat string // skip albums not offered in this format
+	MinRating     float64
+	MinBitrate    int    // skip lossy-only albums below this many kbps
+	Update        bool   // check albums downloaded before for new and replaced tracks
+	Prefer        string // smallest or largest, pick the format by size
+	StatsFile     string // JSON lines file every album run is added to
+	EmailReport   bool   // mail a summary of the run, SMTP settings are in the config file
+	MinDuration   int    // skip shorter tracks, in seconds
+	MaxDuration   int    // skip longer tracks, in seconds
+	FilterExec    string // command deciding on each track, see runTrackFilter
+	TrackName     string // template for track file names, see expandTemplate
+	Tracks        string // track numbers to download, e.g. 1-10,12
+	// OnMissingFormat is fallback, skip or ask for tracks not offered in
+	// Format
+	OnMissingFormat string
+	// Provenance records where each track came from in its tags, a
+	// sidecar file or both
+	Provenance string
+	// Pause stops the album's downloads, set per queue item by the server
+	Pause *pauseSwitch
+}
+
+// optionHelp describes a command line flag for the usage text and the
+// shell completions.
+type optionHelp struct {
+	Flag   string
+	Arg    string // argument placeholder, empty for boolean flags
+	Help   string
+	Values []string // fixed argument values to complete
+	File   bool     // the argument is a path
+}
+
+var downloadOptions = []optionHelp{
+	{Flag: "--output-dir", Arg: "<dir>", Help: "Folder to download into (default: downloads)", File: true},
+	{Flag: "--format", Arg: "mp3|flac", Help: "Download format (default: flac)", Values: []string{"mp3", "flac"}},
+	{Flag: "--prefer", Arg: "smallest|largest", Help: "Pick the smallest or largest format of the same kind (lossy or lossless) by size", Values: []string{"smallest", "largest"}},
+	{Flag: "--on-missing-format", Arg: "ask|fallback|skip", Help: "For tracks not offered in --format: ask, download another format (default) or skip them", Values: []string{"ask", "fallback", "skip"}},
+	{Flag: "--require-format", Arg: "<format>", Help: "Skip albums that aren't available in this format", Values: []string{"flac", "mp3"}},
+	{Flag: "--update", Help: "Check albums downloaded before for new and replaced tracks (keeps album.json up to date)"},
+	{Flag: "--min-rating", Arg: "<0-5>", Help: "Skip albums rated lower, or not rated at all"},
+	{Flag: "--min-bitrate", Arg: "<kbps>", Help: "Skip lossy-only albums with a lower bitrate"},
+	{Flag: "--tracks", Arg: "<list>", Help: "Only download these track numbers, e.g. 1-10,12 or 5-"},
+	{Flag: "--min-duration", Arg: "<len>", Help: "Skip tracks shorter than this, e.g. 0:10 or 10s"},
+	{Flag: "--max-duration", Arg: "<len>", Help: "Skip tracks longer than this, e.g. 30:00 or 30m"},
+	{Flag: "--filter-exec", Arg: "<command>", Help: "Run a command with each track's metadata as JSON on stdin, exit 1 leaves the track out"},
+	{Flag: "--no-images", Help: "Skip downloading album images"},
+	{Flag: "--flat", Help: "Save images next to the tracks named by kind: cover, back, disc-NN, inlay-NN and booklet-NN"},
+	{Flag: "--art-archive", Help: "Bundle the album images into " + scansArchive + ", keeping the front cover loose as folder.jpg"},
+	{Flag: "--art-dir", Arg: "<name>", Help: "Folder for the album images (default: Art)"},
+	{Flag: "--tags", Help: "Write genre/platform tags to downloaded files"},
+	{Flag: "--provenance", Arg: "tags|sidecar|both", Help: "Record the source URL, download date and version in tags, a .source.json next to each track, or both", Values: []string{"tags", "sidecar", "both"}},
+	{Flag: "--tag-map", Arg: "<file>", Help: "JSON file overriding the tag mapping table", File: true},
+	{Flag: "--write-metadata", Help: "Write album.json with beets-compatible field names"},
+	{Flag: "--beets-import", Help: "Run 'beet import -A' on the album when done"},
+	{Flag: "--archive", Arg: "zip|tar.gz", Help: "Pack the album into a single archive file", Values: []string{"zip", "tar.gz"}},
+	{Flag: "--archive-delete", Help: "Delete the loose files after archiving, unless tracks failed"},
+	{Flag: "--save-page", Help: "Save the album page as album.html next to the tracks"},
+	{Flag: "--save-song-pages", Help: "Also save every song page into pages/"},
+	{Flag: "--preflight", Help: "Check all links and sizes before downloading"},
+	{Flag: "--mtime-from-year", Help: "Set file times to the album's release year instead of the server's"},
+	{Flag: "--progress", Arg: "text|json", Help: "Progress output format; json prints one event per line on stdout", Values: []string{"text", "json"}},
+	{Flag: "--library", Arg: "<root>", Help: "Download into this library, skipping albums it already has", File: true},
+	{Flag: "--library-layout", Arg: "<layout>", Help: "Folders of new albums in the library, e.g. {platform}/{year} - {album} (default: {album})"},
+	{Flag: "--dedupe", Arg: "<mode>", Help: "Reuse tracks other albums already have (same name and size): hardlink, symlink, copy or skip", Values: dedupeModes},
+	{Flag: "--download-archive", Arg: "<file>", Help: "Skip albums listed in the file and record completed ones", File: true},
+	{Flag: "--album-concurrency", Arg: "<n>", Help: "Download up to n albums of a batch at the same time"},
+	{Flag: "--album-delay", Arg: "<duration>", Help: "Wait this long between the albums of a batch, e.g. 30s or 2m"},
+	{Flag: "--album-jitter", Arg: "<duration>", Help: "Wait up to this much longer between albums, at random"},
+	{Flag: "--host-connections", Arg: "<n>", Help: "Limit on requests to one host across all albums (default: 4)"},
+	{Flag: "--max-rate", Arg: "<rate>", Help: "Cap the total download speed, e.g. 500K or 2M (bytes per second)"},
+	{Flag: "--schedule", Arg: "<HH:MM-HH:MM>", Help: "Only transfer files during this time of day, pausing outside it"},
+	{Flag: "--wait-lock", Help: "Wait for another run downloading the same album instead of skipping it"},
+	{Flag: "--ascii-names", Help: "Transliterate file and folder names to ASCII (tags keep the original titles)"},
+	{Flag: "--replace-char", Arg: "<c>", Help: "Replace characters not allowed in file names instead of removing them"},
+	{Flag: "--underscores", Help: "Use underscores instead of spaces in file names"},
+	{Flag: "--lowercase", Help: "Lowercase file and folder names"},
+	{Flag: "--track-name", Arg: "<template>", Help: "Name track files by a template, e.g. '<{disc}->{track|pad:2} {title}' (default: as on the site)"},
+	{Flag: "--max-name-length", Arg: "<n>", Help: "Longest file or folder name in bytes (default: 200)"},
+	{Flag: "--clipboard", Help: "Download the album URLs on the clipboard"},
+	{Flag: "--stats-file", Arg: "<file>", Help: "Append each album's transfer statistics to this file (JSON lines, default: stats.jsonl in the state directory)", File: true},
+	{Flag: "--max-bytes", Arg: "<size>", Help: "Stop the run after downloading this much, e.g. 5G"},
+	{Flag: "--monthly-bytes", Arg: "<size>", Help: "Stop when this month's downloads (counted over all runs) reach this much"},
+	{Flag: "--max-failures", Arg: "<n>", Help: "Stop the run once this many tracks failed"},
+	{Flag: "--abort-on-failure", Help: "Don't start further albums after one with failed tracks"},
+	{Flag: "--fail-fast", Help: "Stop the run at the first failed track or album"},
+	{Flag: "--alert-failures", Arg: "<n|n%>", Help: "Alert once when this many (or this share of) tracks failed"},
+	{Flag: "--alert-webhook", Arg: "<url>", Help: "Also post the failure alert as JSON to this URL"},
+	{Flag: "--discord-webhook", Arg: "<url>", Help: "Post a summary of every finished album to a Discord webhook"},
+	{Flag: "--notify", Arg: "<notifier>", Help: "Send download events to stdout, desktop, webhook:<url>, discord:<url> or exec:<command> (repeatable)"},
+	{Flag: "--email-report", Help: "Email a summary of new albums and failures after the run (SMTP settings in the config file)"},
+	{Flag: "--profile", Arg: "<name>", Help: "Use a bundle of options defined in the config file (repeatable)"},
+	{Flag: "--config", Arg: "<file>", Help: "Config file to use instead of config.json in the user config directory", File: true},
+	{Flag: "--mirror", Arg: "<host>", Help: "Additional file mirror to fail over to (repeatable)"},
+	{Flag: "--cache-dir", Arg: "<dir>", Help: "Folder for the page cache (default: the user cache directory)", File: true},
+	{Flag: "--state-dir", Arg: "<dir>", Help: "Folder for the login cookies and the favorites archive (default: the user state directory)", File: true},
+	{Flag: "--no-keyring", Help: "Don't use the OS keyring for the saved login and keyring: secrets"},
+	{Flag: "--no-cache", Help: "Don't use the on-disk cache for album and song pages"},
+	{Flag: "--cache-ttl", Arg: "<dur>", Help: "Reuse cached pages without revalidating for this long (default: 1h)"},
+	{Flag: "--user-agent", Arg: "<ua>", Help: "User-Agent for all requests"},
+	{Flag: "--referer", Arg: "<url>", Help: "Referer for all requests"},
+	{Flag: "--header", Arg: "'K: V'", Help: "Extra request header (repeatable)"},
+	{Flag: "--connect-timeout", Arg: "<dur>", Help: "Time allowed for connecting to the site, raise it on slow links (default: 30s)"},
+	{Flag: "--stall-timeout", Arg: "<dur>", Help: "Give up on a download that receives no data for this long (default: 60s)"},
+	{Flag: "--http-idle-conns", Arg: "<n>", Help: "Idle connections kept open per host for reuse (default: 16)"},
+	{Flag: "--http-idle-timeout", Arg: "<dur>", Help: "How long an unused connection is kept open (default: 90s)"},
+	{Flag: "--ipv4", Help: "Only connect over IPv4"},
+	{Flag: "--ipv6", Help: "Only connect over IPv6"},
+	{Flag: "--dns", Arg: "<server>", Help: "Look up host names with this DNS server (e.g. 1.1.1.1 or 9.9.9.9:53)"},
+	{Flag: "--ca-cert", Arg: "<file>", Help: "Also trust the CA certificates in this PEM file (repeatable)", File: true},
+	{Flag: "--insecure-tls", Help: "Don't verify HTTPS certificates (only behind a proxy you trust)"},
+	{Flag: "--write-buffer", Arg: "<size>", Help: "Write downloads in chunks of this size, e.g. 4M for slow disks (default: 1M)"},
+	{Flag: "--no-preallocate", Help: "Don't reserve the space of a download before writing it"},
+	{Flag: "--low-memory", Help: "Use less memory on small devices: lighter page parsing, one album and connection at a time"},
+	{Flag: "--cookies", Arg: "<file>", Help: "Read and save cookies in this cookies.txt (Netscape format) instead of the login's cookie jar", File: true},
+	{Flag: "--cookies-from-browser", Arg: "<browser>", Help: "Import the site's cookies from firefox (or firefox:<profile folder>), e.g. to pass an anti-bot challenge"},
+	{Flag: "--tor", Help: "Connect through a local Tor daemon and turn off features that would leak what is downloaded"},
+	{Flag: "--tor-proxy", Arg: "<host:port>", Help: "SOCKS address of Tor for --tor (default: 127.0.0.1:9050)"},
+	{Flag: "--no-http2", Help: "Only use HTTP/1.1"},
+	{Flag: "--no-keepalive", Help: "Open a new connection for every request"},
+	{Flag: "--polite", Help: "Honor robots.txt, fetch one page at a time and space out requests"},
+	{Flag: "--debug-html", Help: "Save pages without tracks or download links to debug-html in the output folder, noting which selectors matched nothing"},
+	{Flag: "--pprof", Arg: "<addr>", Help: "Serve Go's profiling endpoints on this address, e.g. localhost:6060"},
+	{Flag: "--quiet", Help: "Only print a one-line summary when something failed"},
+	{Flag: "--no-title", Help: "Don't show the progress in the terminal window title"},
+	{Flag: "--no-color", Help: "Disable colored output (also disabled by NO_COLOR or when piped)"},
+}
+
+type commandHelp struct {
+	Name    string
+	Usage   []string
+	Help    string
+	Options []optionHelp
+}
+
+var searchOptions = []optionHelp{
+	{Flag: "--platform", Arg: "<name>", Help: "Only albums for this platform (offline only)"},
+	{Flag: "--year", Arg: "<year[-year]>", Help: "Only albums from this year or range of years (offline only)"},
+	{Flag: "--json", Help: "Print the results as JSON"},
+}
+
+var commands = []commandHelp{
+	{
+		Name:  "serve",
+		Usage: []string{"serve [--listen <addr>] [--token <token> | --basic-auth <user:password>] [--tls-cert <file> --tls-key <file>] [options]"},
+		Help:  "Run as a daemon downloading queued albums",
+		Options: []optionHelp{
+			{Flag: "--listen", Arg: "<addr>", Help: "Address to listen on (default: :8080)"},
+			{Flag: "--token", Arg: "<token>", Help: "Require this bearer token for the API (repeatable)"},
+			{Flag: "--basic-auth", Arg: "<user:password>", Help: "Require HTTP basic auth for the API"},
+			{Flag: "--tls-cert", Arg: "<file>", Help: "Serve HTTPS with this certificate"},
+			{Flag: "--tls-key", Arg: "<file>", Help: "Private key of the certificate"},
+			{Flag: "--telegram-token", Arg: "<token>", Help: "Take album URLs from a Telegram bot and report back"},
+			{Flag: "--telegram-chat", Arg: "<id,...>", Help: "Telegram chats the bot accepts albums from"},
+		},
+	},
+	{
+		Name:  "status",
+		Usage: []string{"status [--server <url>] [--token <token> | --basic-auth <user:password>]"},
+		Help:  "Show the state of a running server",
+		Options: []optionHelp{
+			{Flag: "--server", Arg: "<url>", Help: "Server address (default: http://localhost:8080)"},
+			{Flag: "--token", Arg: "<token>", Help: "Bearer token for the server"},
+			{Flag: "--basic-auth", Arg: "<user:password>", Help: "Basic auth credentials for the server"},
+		},
+	},
+	{
+		Name:  "queue",
+		Usage: []string{"queue add [--priority low|normal|high] <album_url>... | list | rm <id> | prioritize <id> | priority <id> low|normal|high [--server <url>] [--token <token> | --basic-auth <user:password>]"},
+		Help:  "Manage the queue of a running server",
+		Options: []optionHelp{
+			{Flag: "--server", Arg: "<url>", Help: "Server address (default: http://localhost:8080)"},
+			{Flag: "--token", Arg: "<token>", Help: "Bearer token for the server"},
+			{Flag: "--basic-auth", Arg: "<user:password>", Help: "Basic auth credentials for the server"},
+		},
+	},
+	{
+		Name:  "login",
+		Usage: []string{"login [--username <name> [--password <password>]] [--no-keyring]", "login --cookie '<name=value; ...>' | --export-cookies <file> | --logout"},
+		Help:  "Log into khinsider and store the session",
+		Options: []optionHelp{
+			{Flag: "--username", Arg: "<name>", Help: "Account name"},
+			{Flag: "--password", Arg: "<password>", Help: "Password (prompted for if missing)"},
+			{Flag: "--cookie", Arg: "<cookies>", Help: "Import a session from a browser cookie string"},
+			{Flag: "--export-cookies", Arg: "<file>", Help: "Write the session to a cookies.txt for other tools", File: true},
+			{Flag: "--cookies", Arg: "<file>", Help: "Keep the session in this cookies.txt instead of the cookie jar", File: true},
+			{Flag: "--logout", Help: "Remove the stored session"},
+			{Flag: "--no-keyring", Help: "Don't save the login to the OS keyring"},
+		},
+	},
+	{
+		Name:  "library",
+		Usage: []string{"library --library <root> import <dir>... | list"},
+		Help:  "Register existing rips with a library, or list it",
+		Options: []optionHelp{
+			{Flag: "--library", Arg: "<root>", Help: "Root folder of the library", File: true},
+		},
+	},
+	{
+		Name:  "diff",
+		Usage: []string{"diff <album_url> <dir>"},
+		Help:  "Compare a downloaded album folder with the album on the site",
+	},
+	{
+		Name:  "stats",
+		Usage: []string{"stats [--stats-file <file>] [--since <date|duration>] [--json]"},
+		Help:  "Show download statistics over time",
+		Options: []optionHelp{
+			{Flag: "--stats-file", Arg: "<file>", Help: "Stats file to read (default: stats.jsonl in the state directory)", File: true},
+			{Flag: "--since", Arg: "<date|duration>", Help: "Only albums since this date (2024-01-31) or this long ago (30d, 12h)"},
+			{Flag: "--json", Help: "Print the statistics as JSON"},
+		},
+	},
+	{
+		Name:  "export",
+		Usage: []string{"export [--format csv|tsv] [--output <file>] <album_url | library_dir>", "export [--format csv|tsv] [--output <file>] --from-file <page.html>"},
+		Help:  "Write an album's track list or a folder of downloaded albums as CSV or TSV",
+		Options: []optionHelp{
+			{Flag: "--format", Arg: "<csv|tsv>", Help: "Output format (default: csv)", Values: []string{"csv", "tsv"}},
+			{Flag: "--output", Arg: "<file>", Help: "Write to this file instead of stdout", File: true},
+			{Flag: "--from-file", Arg: "<page.html>", Help: "Use a saved album page instead of fetching one", File: true},
+		},
+	},
+	{
+		Name:    "index",
+		Usage:   []string{"index build [options]", "index search [--platform <name>] [--year <year[-year]>] [--json] <query>"},
+		Help:    "Keep a local copy of the album index and search it offline",
+		Options: searchOptions,
+	},
+	{
+		Name:  "search",
+		Usage: []string{"search [--offline] [--platform <name>] [--year <year[-year]>] [--json] <query>"},
+		Help:  "Search for albums on khinsider or in the local index",
+		Options: append([]optionHelp{
+			{Flag: "--offline", Help: "Search the local album index, falling back to the site when it is missing or out of date"},
+		}, searchOptions...),
+	},
+	{
+		Name:  "random",
+		Usage: []string{"random [--platform <name>] [--year <year[-year]>] [--download] [options]"},
+		Help:  "Pick a random album from the local index or the site",
+		Options: []optionHelp{
+			{Flag: "--platform", Arg: "<name>", Help: "Only albums for this platform (needs the local index)"},
+			{Flag: "--year", Arg: "<year[-year]>", Help: "Only albums from this year or range of years (needs the local index)"},
+			{Flag: "--download", Help: "Download the album instead of only printing it"},
+		},
+	},
+	{
+		Name:  "keyring",
+		Usage: []string{"keyring set|delete <name>"},
+		Help:  "Store a secret in the OS keyring, to use as keyring:<name> for tokens and passwords",
+	},
+	{
+		Name:  "favorites",
+		Usage: []string{"favorites sync [options]"},
+		Help:  "Download the albums on the account's favorites page",
+		Options: []optionHelp{
+			{Flag: "--favorites-url", Arg: "<url>", Help: "Favorites page to read"},
+		},
+	},
+	{
+		Name:  "info",
+		Usage: []string{"info <album_url> | --from-file <page.html> [--json] [--verbose] [--write-metadata]"},
+		Help:  "Print an album's metadata, from the site or a saved page",
+		Options: []optionHelp{
+			{Flag: "--from-file", Arg: "<file>", Help: "Parse a saved album page (album.html) instead of fetching it", File: true},
+			{Flag: "--json", Help: "Print the metadata as JSON, in the album.json format"},
+			{Flag: "--verbose", Help: "Fetch the song pages and list each track's bitrate, sample rate and channels"},
+		},
+	},
+	{
+		Name:  "self-update",
+		Usage: []string{"self-update [--check] [--force]"},
+		Help:  "Update to the latest GitHub release",
+		Options: []optionHelp{
+			{Flag: "--check", Help: "Only check for a newer release"},
+			{Flag: "--force", Help: "Update even if the version is current or unknown"},
+		},
+	},
+	{
+		Name:  "version",
+		Usage: []string{"version [--json]"},
+		Help:  "Print version and build information",
+		Options: []optionHelp{
+			{Flag: "--json", Help: "Print the build information as JSON"},
+		},
+	},
+	{
+		Name:  "completion",
+		Usage: []string{"completion bash|zsh|fish|powershell"},
+		Help:  "Print a shell completion script",
+	},
+}
+
+func printUsage() {
+	fmt.Println("Usage: khinsider_downloader <album_url>... [options]")
+	fmt.Println("       khinsider_downloader - [options] < urls.txt")
+	for _, cmd := range commands {
+		for _, usage := range cmd.Usage {
+			fmt.Println("       khinsider_downloader " + usage)
+		}
+	}
+
+	fmt.Println("\nOptions:")
+	printOptions(downloadOptions)
+
+	for _, cmd := range commands {
+		if len(cmd.Options) > 0 {
+			fmt.Printf("\n%s options:\n", strings.ToUpper(cmd.Name[:1])+cmd.Name[1:])
+			printOptions(cmd.Options)
+		}
+	}
+}
+
+func printOptions(options []optionHelp) {
+	for _, o := range options {
+		fmt.Printf("  %-20s %s\n", strings.TrimSpace(o.Flag+" "+o.Arg), o.Help)
+	}
+}
+
+// parseOptions parses the download options in args and returns the
+// remaining positional arguments.
+func parseOptions(args []string) (*Options, []string, error) {
+	opts, positional, err := parseOptionList(args)
+	if err != nil {
+		return nil, nil, newClassError(errInvalidInput, err.Error())
+	}
+	return opts, positional, nil
+}
+
+func parseOptionList(args []string) (*Options, []string, error) {
+	opts := &Options{
+		Format:          "flac",
+		OutputDir:       "downloads",
+		Images:          true,
+		ArtDir:          "Art",
+		OnMissingFormat: missingFallback,
+	}
+	tagMapPath := ""
+	useTor, torProxy := false, defaultTorProxy
+	cookieBrowser := ""
+	debugHTML := false
+	progressFormat := "text"
+	noCache, cacheTTL := false, time.Hour
+	var positional []string
+
+	env, err := envArgs(downloadOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+	if args, err = expandProfiles(append(env, args...)); err != nil {
+		return nil, nil, err
+	}
+
+	// Parse command line arguments
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--format":
+			if i+1 < len(args) {
+				opts.Format = strings.ToLower(args[i+1])
+				i++
+			}
+		case "--output-dir", "-o":
+			if i+1 < len(args) {
+				opts.OutputDir = args[i+1]
+				i++
+			}
+		case "--update":
+			opts.Update = true
+		case "--min-rating":
+			if i+1 < len(args) {
+				rating, err := strconv.ParseFloat(args[i+1], 64)
+				if err != nil || rating < 0 || rating > 5 {
+					return nil, nil, fmt.Errorf("invalid --min-rating: %s, expected 0 to 5", args[i+1])
+				}
+				opts.MinRating = rating
+				i++
+			}
+		case "--min-bitrate":
+			if i+1 < len(args) {
+				kbps, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(args[i+1]), "kbps"))
+				if err != nil || kbps <= 0 {
+					return nil, nil, fmt.Errorf("invalid --min-bitrate: %s, expected kbps", args[i+1])
+				}
+				opts.MinBitrate = kbps
+				i++
+			}
+		case "--require-format":
+			if i+1 < len(args) {
+				opts.RequireFormat = strings.ToLower(args[i+1])
+				i++
+			}
+		case "--tracks":
+			if i+1 < len(args) {
+				if _, err := parseTrackList(args[i+1]); err != nil {
+					return nil, nil, err
+				}
+				opts.Tracks = args[i+1]
+				i++
+			}
+		case "--filter-exec":
+			if i+1 < len(args) {
+				opts.FilterExec = args[i+1]
+				i++
+			}
+		case "--min-duration", "--max-duration":
+			if i+1 < len(args) {
+				seconds, err := parseTrackDuration(args[i+1])
+				if err != nil {
+					return nil, nil, fmt.Errorf("invalid %s: %v", args[i], err)
+				}
+				if args[i] == "--min-duration" {
+					opts.MinDuration = seconds
+				} else {
+					opts.MaxDuration = seconds
+				}
+				i++
+			}
+		case "--provenance":
+			if i+1 < len(args) {
+				opts.Provenance = strings.ToLower(args[i+1])
+				i++
+			}
+		case "--on-missing-format":
+			if i+1 < len(args) {
+				opts.OnMissingFormat = strings.ToLower(args[i+1])
+				i++
+			}
+		case "--prefer":
+			if i+1 < len(args) {
+				opts.Prefer = strings.ToLower(args[i+1])
+				i++
+			}
+		case "--no-images":
+			opts.Images = false
+		case "--flat":
+			opts.Flat = true
+		case "--art-archive":
+			opts.ArtArchive = true
+		case "--art-dir":
+			if i+1 < len(args) {
+				opts.ArtDir = sanitizeFilename(args[i+1])
+				i++
+			}
+		case "--tags":
+			opts.Tags = true
+		case "--tag-map":
+			if i+1 < len(args) {
+				tagMapPath = args[i+1]
+				opts.Tags = true
+				i++
+			}
+		case "--write-metadata":
+			opts.WriteMetadata = true
+		case "--beets-import":
+			opts.BeetsImport = true
+		case "--archive":
+			if i+1 < len(args) {
+				opts.ArchiveFormat = strings.ToLower(args[i+1])
+				i++
+			}
+		case "--archive-delete":
+			opts.ArchiveDelete = true
+		case "--save-page":
+			opts.SavePage = true
+		case "--save-song-pages":
+			opts.SavePage = true
+			opts.SaveSongPages = true
+		case "--preflight":
+			opts.Preflight = true
+		case "--mtime-from-year":
+			opts.MtimeFromYear = true
+		case "--stats-file":
+			if i+1 < len(args) {
+				opts.StatsFile = args[i+1]
+				i++
+			}
+		case "--max-bytes", "--monthly-bytes":
+			if i+1 < len(args) {
+				size, err := parseRate(args[i+1])
+				if err != nil {
+					return nil, nil, fmt.Errorf("invalid %s: %s, expected a size like 5G", args[i], args[i+1])
+				}
+				if args[i] == "--max-bytes" {
+					caps.run = size
+				} else {
+					caps.monthly = size
+				}
+				i++
+			}
+		case "--max-failures":
+			if i+1 < len(args) {
+				n, err := strconv.ParseInt(args[i+1], 10, 64)
+				if err != nil || n < 1 {
+					return nil, nil, fmt.Errorf("invalid --max-failures: %s", args[i+1])
+				}
+				policy.maxFailures = n
+				i++
+			}
+		case "--abort-on-failure":
+			policy.abortOnFailure = true
+		case "--fail-fast":
+			policy.failFast = true
+			policy.maxFailures = 1
+		case "--alert-failures":
+			if i+1 < len(args) {
+				if err := alert.parseThreshold(args[i+1]); err != nil {
+					return nil, nil, fmt.Errorf("invalid --alert-failures: %v", err)
+				}
+				i++
+			}
+		case "--alert-webhook":
+			if i+1 < len(args) {
+				alert.webhook = args[i+1]
+				i++
+			}
+		case "--discord-webhook":
+			if i+1 < len(args) {
+				addNotifier(queueNotifier(discordNotifier{url: args[i+1]}))
+				i++
+			}
+		case "--notify":
+			if i+1 < len(args) {
+				n, err := parseNotifier(args[i+1])
+				if err != nil {
+					return nil, nil, fmt.Errorf("invalid --notify: %v", err)
+				}
+				addNotifier(n)
+				i++
+			}
+		case "--email-report":
+			opts.EmailReport = true
+		case "--config":
+			if i+1 < len(args) {
+				configFile = args[i+1]
+				i++
+			}
+		case "--download-archive":
+			if i+1 < len(args) {
+				opts.DownloadArchive = args[i+1]
+				i++
+			}
+		case "--album-concurrency":
+			if i+1 < len(args) {
+				n, err := strconv.Atoi(args[i+1])
+				if err != nil || n < 1 {
+					return nil, nil, fmt.Errorf("invalid --album-concurrency: %s", args[i+1])
+				}
+				opts.AlbumConcurrency = n
+				i++
+			}
+		case "--library":
+			if i+1 < len(args) {
+				opts.Library = args[i+1]
+				i++
+			}
+		case "--library-layout", "--track-name":
+			if i+1 < len(args) {
+				if err := checkTemplate(args[i+1]); err != nil {
+					return nil, nil, fmt.Errorf("invalid %s: %v", args[i], err)
+				}
+				if args[i] == "--track-name" {
+					opts.TrackName = args[i+1]
+				} else {
+					opts.LibraryLayout = args[i+1]
+				}
+				i++
+			}
+		case "--dedupe":
+			if i+1 < len(args) {
+				opts.Dedupe = strings.ToLower(args[i+1])
+				if !contains(dedupeModes, opts.Dedupe) {
+					return nil, nil, fmt.Errorf("unknown --dedupe mode %q, use %s", args[i+1], strings.Join(dedupeModes, ", "))
+				}
+				i++
+			}
+		case "--album-delay", "--album-jitter":
+			if i+1 < len(args) {
+				d, err := time.ParseDuration(args[i+1])
+				if err != nil || d < 0 {
+					return nil, nil, fmt.Errorf("invalid %s: %s", args[i], args[i+1])
+				}
+				if args[i] == "--album-delay" {
+					opts.AlbumDelay = d
+				} else {
+					opts.AlbumJitter = d
+				}
+				i++
+			}
+		case "--host-connections":
+			if i+1 < len(args) {
+				n, err := strconv.Atoi(args[i+1])
+				if err != nil || n < 1 {
+					return nil, nil, fmt.Errorf("invalid --host-connections: %s", args[i+1])
+				}
+				maxHostConnections = n
+				i++
+			}
+		case "--max-rate":
+			if i+1 < len(args) {
+				rate, err := parseRate(args[i+1])
+				if err != nil {
+					return nil, nil, fmt.Errorf("invalid --max-rate: %v", err)
+				}
+				bandwidth = newRateLimiter(rate)
+				i++
+			}
+		case "--schedule":
+			if i+1 < len(args) {
+				window, err := parseSchedule(args[i+1])
+				if err != nil {
+					return nil, nil, fmt.Errorf("invalid --schedule: %v", err)
+				}
+				downloadSchedule = window
+				i++
+			}
+		case "--wait-lock":
+			waitForLock = true
+		case "--ascii-names":
+			naming.ASCII = true
+		case "--replace-char":
+			if i+1 < len(args) {
+				if invalidFilenameChars.MatchString(args[i+1]) {
+					return nil, nil, fmt.Errorf("invalid --replace-char: %q is not allowed in file names", args[i+1])
+				}
+				naming.Replacement = args[i+1]
+				i++
+			}
+		case "--underscores":
+			naming.Underscores = true
+		case "--lowercase":
+			naming.Lowercase = true
+		case "--max-name-length":
+			if i+1 < len(args) {
+				n, err := strconv.Atoi(args[i+1])
+				if err != nil || n < 16 {
+					return nil, nil, fmt.Errorf("invalid --max-name-length: %s (minimum 16)", args[i+1])
+				}
+				naming.MaxLength = n
+				i++
+			}
+		case "--clipboard":
+			opts.Clipboard = true
+		case "--mirror":
+			if i+1 < len(args) {
+				mirrorHosts = append(mirrorHosts, strings.ToLower(args[i+1]))
+				i++
+			}
+		case "--cache-dir":
+			if i+1 < len(args) {
+				cacheDirOverride = args[i+1]
+				i++
+			}
+		case "--state-dir":
+			if i+1 < len(args) {
+				stateDirOverride = args[i+1]
+				i++
+			}
+		case "--no-keyring":
+			keyringDisabled = true
+		case "--no-cache":
+			noCache = true
+		case "--cache-ttl":
+			if i+1 < len(args) {
+				ttl, err := time.ParseDuration(args[i+1])
+				if err != nil {
+					return nil, nil, fmt.Errorf("invalid --cache-ttl: %v", err)
+				}
+				cacheTTL = ttl
+				i++
+			}
+		case "--user-agent":
+			if i+1 < len(args) {
+				userAgent = args[i+1]
+				i++
+			}
+		case "--referer":
+			if i+1 < len(args) {
+				refererHeader = args[i+1]
+				i++
+			}
+		case "--header":
+			if i+1 < len(args) {
+				if err := addExtraHeader(args[i+1]); err != nil {
+					return nil, nil, err
+				}
+				i++
+			}
+		case "--connect-timeout", "--http-idle-timeout", "--stall-timeout":
+			if i+1 < len(args) {
+				d, err := time.ParseDuration(args[i+1])
+				if err != nil || d <= 0 {
+					return nil, nil, fmt.Errorf("invalid %s: %s", args[i], args[i+1])
+				}
+				switch args[i] {
+				case "--connect-timeout":
+					httpTuning.connectTimeout = d
+				case "--http-idle-timeout":
+					httpTuning.idleTimeout = d
+				default:
+					httpTuning.stallTimeout = d
+				}
+				i++
+			}
+		case "--http-idle-conns":
+			if i+1 < len(args) {
+				n, err := strconv.Atoi(args[i+1])
+				if err != nil || n < 1 {
+					return nil, nil, fmt.Errorf("invalid --http-idle-conns: %s", args[i+1])
+				}
+				httpTuning.idleConns = n
+				i++
+			}
+		case "--ipv4":
+			httpTuning.network = "tcp4"
+		case "--ipv6":
+			httpTuning.network = "tcp6"
+		case "--dns":
+			if i+1 < len(args) {
+				server := args[i+1]
+				if _, _, err := net.SplitHostPort(server); err != nil {
+					server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+				}
+				httpTuning.dnsServer = server
+				i++
+			}
+		case "--ca-cert":
+			if i+1 < len(args) {
+				if err := addCACert(args[i+1]); err != nil {
+					return nil, nil, fmt.Errorf("invalid --ca-cert: %v", err)
+				}
+				i++
+			}
+		case "--insecure-tls":
+			if !httpTuning.insecureTLS {
+				logln(colorize("failed", "Warning: HTTPS certificates aren't verified (--insecure-tls)"))
+			}
+			httpTuning.insecureTLS = true
+		case "--write-buffer":
+			if i+1 < len(args) {
+				size, err := parseRate(args[i+1])
+				if err != nil || size < 4096 {
+					return nil, nil, fmt.Errorf("invalid --write-buffer: %s", args[i+1])
+				}
+				writeBufferSize = int(size)
+				i++
+			}
+		case "--no-preallocate":
+			preallocate = false
+		case "--low-memory":
+			lowMemory = true
+		case "--cookies":
+			if i+1 < len(args) {
+				cookiesFile = args[i+1]
+				i++
+			}
+		case "--cookies-from-browser":
+			if i+1 < len(args) {
+				cookieBrowser = args[i+1]
+				i++
+			}
+		case "--tor":
+			useTor = true
+		case "--tor-proxy":
+			if i+1 < len(args) {
+				torProxy = args[i+1]
+				useTor = true
+				i++
+			}
+		case "--no-http2":
+			httpTuning.noHTTP2 = true
+		case "--no-keepalive":
+			httpTuning.noKeepAlive = true
+		case "--debug-html":
+			debugHTML = true
+		case "--pprof":
+			if i+1 < len(args) {
+				pprofAddr = args[i+1]
+				i++
+			}
+		case "--polite":
+			politeMode = true
+		case "--quiet", "-q":
+			enableQuietMode()
+		case "--no-title":
+			titleEnabled = false
+		case "--no-color":
+			noColor = true
+		case "--progress":
+			if i+1 < len(args) {
+				progressFormat = strings.ToLower(args[i+1])
+				i++
+			}
+		default:
+			if !strings.HasPrefix(args[i], "--") {
+				positional = append(positional, args[i])
+			}
+		}
+	}
+
+	htmlCache = newPageCache(cacheTTL, !noCache)
+	if useTor {
+		enableTor(torProxy)
+	}
+	if lowMemory {
+		enableLowMemory(opts)
+	}
+	if debugHTML {
+		debugHTMLDir = filepath.Join(opts.OutputDir, "debug-html")
+	}
+	if pprofAddr != "" {
+		startPprof(pprofAddr)
+	}
+	if err := checkTorConflicts(opts); err != nil {
+		return nil, nil, err
+	}
+	if cookieBrowser != "" {
+		if err := importBrowserCookies(cookieBrowser); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	switch progressFormat {
+	case "text":
+	case "json":
+		enableJSONProgress()
+	default:
+		return nil, nil, fmt.Errorf("unknown progress format: %s", progressFormat)
+	}
+
+	switch opts.OnMissingFormat {
+	case missingFallback, missingSkip:
+	case missingAsk:
+		if !isTerminal(os.Stdin) {
+			return nil, nil, fmt.Errorf("--on-missing-format ask needs a terminal to ask on, use fallback or skip")
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown --on-missing-format: %s", opts.OnMissingFormat)
+	}
+
+	switch opts.Provenance {
+	case "", provenanceTags, provenanceSidecar, provenanceBoth:
+	default:
+		return nil, nil, fmt.Errorf("unknown --provenance: %s", opts.Provenance)
+	}
+
+	switch opts.Prefer {
+	case "", "smallest", "largest":
+	default:
+		return nil, nil, fmt.Errorf("unknown --prefer: %s", opts.Prefer)
+	}
+
+	switch opts.ArchiveFormat {
+	case "", "zip", "tar.gz", "tgz":
+	default:
+		return nil, nil, fmt.Errorf("unknown archive format: %s", opts.ArchiveFormat)
+	}
+
+	opts.TagMap = defaultTagMapping()
+	if tagMapPath != "" {
+		var err error
+		opts.TagMap, err = loadTagMapping(tagMapPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("loading tag mapping: %v", err)
+		}
+	}
+
+	return opts, positional, nil
+}
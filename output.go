@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Human readable output goes through logf/logln so it can be moved out of
+// the way when stdout is used for machine-readable progress.
+var logOutput io.Writer = os.Stdout
+
+func logf(format string, a ...any) {
+	text := fmt.Sprintf(format, a...)
+	recentLog.add(text)
+	io.WriteString(logOutput, text)
+}
+
+func logln(a ...any) {
+	text := fmt.Sprintln(a...)
+	recentLog.add(text)
+	io.WriteString(logOutput, text)
+}
+
+// quietMode drops all per-track output, only failures are summarized.
+var quietMode bool
+
+func enableQuietMode() {
+	quietMode = true
+	logOutput = io.Discard
+}
+
+// progressEvent is one line of the --progress json stream.
+type progressEvent struct {
+	Event      string    `json:"event"`
+	Time       time.Time `json:"time"`
+	Album      string    `json:"album,omitempty"`
+	Track      string    `json:"track,omitempty"`
+	Index      int       `json:"index,omitempty"`
+	Total      int       `json:"total,omitempty"`
+	File       string    `json:"file,omitempty"`
+	Bytes      int64     `json:"bytes,omitempty"`
+	Size       int64     `json:"size,omitempty"`
+	Percent    float64   `json:"percent,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Code       string    `json:"code,omitempty"` // failure class, see errorClasses
+	Successful int       `json:"successful,omitempty"`
+	Failed     int       `json:"failed,omitempty"`
+
+	// Details for notifiers that need more than the JSON fields
+	album *Album
+	stats *albumStats
+}
+
+var jsonProgress bool
+
+// enableJSONProgress switches stdout to JSON lines and sends the human
+// readable output to stderr instead.
+func enableJSONProgress() {
+	jsonProgress = true
+	logOutput = os.Stderr
+	addNotifier(stdoutNotifier{})
+}
+
+// emitProgress passes an event to all notifiers.
+func emitProgress(ev progressEvent) {
+	ev.Time = time.Now()
+
+	notifiersMu.Lock()
+	current := notifiers
+	notifiersMu.Unlock()
+
+	for _, n := range current {
+		if err := n.Notify(ev); err != nil {
+			logf("Error sending %s notification: %v\n", ev.Event, err)
+		}
+	}
+}
+
+// progressReader reports how much of a download has been read, at most a
+// few times per second.
+type progressReader struct {
+	r          io.Reader
+	read       int64
+	total      int64
+	lastReport time.Time
+	report     func(read, total int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	if p.report != nil && (err == io.EOF || time.Since(p.lastReport) >= 250*time.Millisecond) {
+		p.lastReport = time.Now()
+		p.report(p.read, p.total)
+	}
+	return n, err
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+var errPaused = errors.New("paused")
+
+// pauseSwitch pauses transfers at runtime. A nil switch is never paused.
+type pauseSwitch struct {
+	mu      sync.Mutex
+	paused  bool
+	resumed chan struct{}
+}
+
+// queuePause pauses every transfer, set from the server API.
+var queuePause = &pauseSwitch{}
+
+func (p *pauseSwitch) set(paused bool) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if paused == p.paused {
+		return
+	}
+	p.paused = paused
+	if paused {
+		p.resumed = make(chan struct{})
+	} else {
+		close(p.resumed)
+	}
+}
+
+func (p *pauseSwitch) isPaused() bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+// wait blocks while the switch is paused.
+func (p *pauseSwitch) wait() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	paused, resumed := p.paused, p.resumed
+	p.mu.Unlock()
+	if paused {
+		<-resumed
+	}
+}
+
+// pausableReader stops a transfer as soon as the queue or the download it
+// belongs to is paused. The partial file is kept and resumed later.
+type pausableReader struct {
+	r     io.Reader
+	pause *pauseSwitch
+}
+
+func (r *pausableReader) Read(b []byte) (int, error) {
+	if queuePause.isPaused() || r.pause.isPaused() {
+		return 0, errPaused
+	}
+	return r.r.Read(b)
+}
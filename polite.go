@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Minimum gap between page fetches in polite mode, robots.txt can ask for more.
+const politePageDelay = 2 * time.Second
+
+var politeMode bool
+
+type robotsRules struct {
+	crawlDelay time.Duration
+	disallow   []string
+}
+
+var (
+	politeMu      sync.Mutex
+	robotsByHost  = make(map[string]*robotsRules)
+	lastPageFetch time.Time
+)
+
+// politeWait blocks until the next page fetch is allowed and returns an
+// error if robots.txt disallows the page. It does nothing unless --polite
+// is set.
+func politeWait(pageURL string) error {
+	if !politeMode {
+		return nil
+	}
+
+	parsedURL, err := url.Parse(pageURL)
+	if err != nil {
+		return err
+	}
+
+	politeMu.Lock()
+	defer politeMu.Unlock()
+
+	rules, ok := robotsByHost[parsedURL.Host]
+	if !ok {
+		rules = fetchRobots(parsedURL)
+		robotsByHost[parsedURL.Host] = rules
+	}
+
+	for _, prefix := range rules.disallow {
+		if strings.HasPrefix(parsedURL.RequestURI(), prefix) {
+			return fmt.Errorf("disallowed by robots.txt: %s", parsedURL.Path)
+		}
+	}
+
+	delay := politePageDelay
+	if rules.crawlDelay > delay {
+		delay = rules.crawlDelay
+	}
+	if wait := delay - time.Since(lastPageFetch); wait > 0 {
+		time.Sleep(wait)
+	}
+	lastPageFetch = time.Now()
+	return nil
+}
+
+// fetchRobots loads the rules for all user agents from robots.txt. A
+// missing or broken robots.txt allows everything.
+func fetchRobots(siteURL *url.URL) *robotsRules {
+	rules := &robotsRules{}
+	robotsURL := siteURL.Scheme + "://" + siteURL.Host + "/robots.txt"
+
+	client := &http.Client{
+		Transport: httpTransport(),
+		Timeout:   httpTuning.pageTimeout,
+	}
+
+	req, err := http.NewRequest("GET", robotsURL, nil)
+	if err != nil {
+		return rules
+	}
+	setRequestHeaders(req, "")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return rules
+	}
+	defer resp.Body.Close()
+
+	metrics.countResponse(resp.StatusCode)
+	if resp.StatusCode != 200 {
+		return rules
+	}
+
+	applies := false
+	inAgents := false
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		if key == "user-agent" {
+			// Consecutive user-agent lines form one group
+			if !inAgents {
+				applies = false
+			}
+			inAgents = true
+			if value == "*" {
+				applies = true
+			}
+			continue
+		}
+		inAgents = false
+
+		if !applies {
+			continue
+		}
+		switch key {
+		case "disallow":
+			if value != "" {
+				rules.disallow = append(rules.disallow, value)
+			}
+		case "crawl-delay":
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+				rules.crawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+		}
+	}
+	return rules
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"sync"
+)
+
+// pprofAddr is set by --pprof.
+var (
+	pprofAddr string
+	pprofOnce sync.Once
+)
+
+// addPprofRoutes adds Go's profiling endpoints under /debug/pprof/, for
+// finding slow parses or memory growth in long crawls:
+//
+//	go tool pprof http://localhost:6060/debug/pprof/heap
+//
+// cmdline is left out: the arguments can hold secrets like --token.
+func addPprofRoutes(mux *http.ServeMux, wrap func(http.HandlerFunc) http.HandlerFunc) {
+	mux.HandleFunc("GET /debug/pprof/", wrap(pprof.Index))
+	mux.HandleFunc("GET /debug/pprof/cmdline", http.NotFound)
+	mux.HandleFunc("GET /debug/pprof/profile", wrap(pprof.Profile))
+	mux.HandleFunc("GET /debug/pprof/symbol", wrap(pprof.Symbol))
+	mux.HandleFunc("GET /debug/pprof/trace", wrap(pprof.Trace))
+}
+
+// startPprof serves the profiling endpoints on addr (--pprof) while a
+// download runs. It's meant for localhost only, there is no auth.
+func startPprof(addr string) {
+	pprofOnce.Do(func() { servePprof(addr) })
+}
+
+func servePprof(addr string) {
+	mux := http.NewServeMux()
+	addPprofRoutes(mux, func(h http.HandlerFunc) http.HandlerFunc { return h })
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logf("Error serving pprof on %s: %v\n", addr, err)
+		}
+	}()
+	logf("Profiling at http://%s/debug/pprof/\n", addr)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+const preflightWorkers = 4
+
+// runPreflight resolves the download link of every song and checks it with
+// a HEAD request, recording the exact size of each file. It returns the
+// songs that can't be downloaded together with the reason.
+func runPreflight(songs []*Song, opts *Options) map[*Song]error {
+	failed := make(map[*Song]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	checked := 0
+
+	workers := preflightWorkers
+	if politeMode {
+		workers = 1
+	}
+
+	queue := make(chan *Song)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for song := range queue {
+				size, err := preflightSong(song, opts)
+
+				mu.Lock()
+				if err != nil {
+					failed[song] = err
+				} else {
+					song.ContentLength = size
+				}
+				checked++
+				logf("\r  Checked %d/%d", checked, len(songs))
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, song := range songs {
+		queue <- song
+	}
+	close(queue)
+	wg.Wait()
+	logln()
+
+	return failed
+}
+
+func preflightSong(song *Song, opts *Options) (int64, error) {
+	if err := ParseDownloadLinks(song); err != nil {
+		return 0, fmt.Errorf("error getting download links: %v", err)
+	}
+
+	downloadURL, _ := chooseDownloadURL(song, opts)
+	if downloadURL == "" {
+		return 0, fmt.Errorf("no download link found")
+	}
+
+	return headRequest(downloadURL)
+}
+
+// headRequest checks that a file exists and returns its size (0 if the
+// server doesn't report one).
+func headRequest(fileURL string) (int64, error) {
+	parsedURL, err := url.Parse(fileURL)
+	if err != nil {
+		return 0, err
+	}
+
+	if parsedURL.Scheme == "" {
+		fileURL = "https://downloads.khinsider.com" + fileURL
+	}
+
+	client := siteClient()
+
+	req, err := http.NewRequest("HEAD", fileURL, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	setRequestHeaders(req, siteReferer)
+
+	release := acquireHost(fileURL)
+	defer release()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	metrics.countResponse(resp.StatusCode)
+	if resp.StatusCode != 200 {
+		return 0, fmt.Errorf("dead link, %w", &httpStatusError{StatusCode: resp.StatusCode})
+	}
+
+	if resp.ContentLength < 0 {
+		return 0, nil
+	}
+	return resp.ContentLength, nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
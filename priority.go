@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Queue priorities. An album waiting longer counts as one level higher for
+// every priorityAging, so a long series crawl at low priority still gets its
+// turn between urgent albums.
+const (
+	priorityLow = iota
+	priorityNormal
+	priorityHigh
+)
+
+const priorityAging = time.Hour
+
+var priorityNames = []string{"low", "normal", "high"}
+
+// parsePriority returns the level of "low", "normal" or "high"; empty is
+// normal.
+func parsePriority(name string) (int, error) {
+	if name == "" {
+		return priorityNormal, nil
+	}
+	for level, n := range priorityNames {
+		if strings.EqualFold(name, n) {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority %q, use low, normal or high", name)
+}
+
+// effectivePriority is the item's level raised by how long it has waited.
+func (item *queueItem) effectivePriority(now time.Time) int {
+	level, _ := parsePriority(item.Priority)
+	return level + int(now.Sub(item.Added)/priorityAging)
+}
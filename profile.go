@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// profile is a named bundle of download options from the config file, e.g.
+//
+//	"profiles": {
+//	  "phone": {"format": "mp3", "prefer": "smallest", "no-images": true}
+//	}
+//
+// Option names are the flags without the dashes. Switches take true or
+// false, repeatable options a list.
+type profile map[string]json.RawMessage
+
+// expandProfiles takes each --profile <name> out of args and puts the
+// profile's options in front, so options given with it still win.
+func expandProfiles(args []string) ([]string, error) {
+	var names, rest []string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--profile" && i+1 < len(args):
+			names = append(names, args[i+1])
+			i++
+		case args[i] == "--config" && i+1 < len(args):
+			// The profiles are in the config file given with them
+			configFile = args[i+1]
+			rest = append(rest, args[i], args[i+1])
+			i++
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	if len(names) == 0 {
+		return args, nil
+	}
+
+	config, err := appConfig()
+	if err != nil {
+		return nil, err
+	}
+	var expanded []string
+	for _, name := range names {
+		p, ok := config.Profiles[name]
+		if !ok {
+			known := make([]string, 0, len(config.Profiles))
+			for name := range config.Profiles {
+				known = append(known, name)
+			}
+			slices.Sort(known)
+			if len(known) == 0 {
+				return nil, fmt.Errorf("unknown profile %s, the config file defines none", name)
+			}
+			return nil, fmt.Errorf("unknown profile %s, expected one of %s", name, strings.Join(known, ", "))
+		}
+		profileArgs, err := p.args()
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %v", name, err)
+		}
+		expanded = append(expanded, profileArgs...)
+	}
+	return append(expanded, rest...), nil
+}
+
+// args turns the profile into command line options, in the order of their
+// names.
+func (p profile) args() ([]string, error) {
+	keys := make([]string, 0, len(p))
+	for key := range p {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	var args []string
+	for _, key := range keys {
+		flag := "--" + strings.TrimPrefix(key, "--")
+		i := slices.IndexFunc(downloadOptions, func(o optionHelp) bool { return o.Flag == flag })
+		if i < 0 || flag == "--profile" {
+			return nil, fmt.Errorf("unknown option %s", key)
+		}
+
+		raw := p[key]
+		if downloadOptions[i].Arg == "" {
+			var on bool
+			if err := json.Unmarshal(raw, &on); err != nil {
+				return nil, fmt.Errorf("%s: expected true or false", key)
+			}
+			if on {
+				args = append(args, flag)
+			}
+			continue
+		}
+
+		var values []any
+		if err := json.Unmarshal(raw, &values); err != nil {
+			values = []any{nil}
+			if err := json.Unmarshal(raw, &values[0]); err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+		}
+		for _, v := range values {
+			switch v := v.(type) {
+			case string:
+				args = append(args, flag, v)
+			case float64:
+				args = append(args, flag, strconv.FormatFloat(v, 'f', -1, 64))
+			default:
+				return nil, fmt.Errorf("%s: expected a string or number", key)
+			}
+		}
+	}
+	return args, nil
+}
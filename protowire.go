@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Just enough of the protocol buffers wire format for the messages of the
+// gRPC API (api/downloader.proto): varints, doubles, strings and embedded
+// messages. Zero values are left out, as in proto3.
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+type protoMessage []byte
+
+func (m *protoMessage) tag(field, wire int) {
+	*m = binary.AppendUvarint(*m, uint64(field)<<3|uint64(wire))
+}
+
+func (m *protoMessage) int(field int, v int64) {
+	if v != 0 {
+		m.tag(field, wireVarint)
+		*m = binary.AppendUvarint(*m, uint64(v))
+	}
+}
+
+func (m *protoMessage) bool(field int, v bool) {
+	if v {
+		m.int(field, 1)
+	}
+}
+
+func (m *protoMessage) double(field int, v float64) {
+	if v != 0 {
+		m.tag(field, wireFixed64)
+		*m = binary.LittleEndian.AppendUint64(*m, math.Float64bits(v))
+	}
+}
+
+func (m *protoMessage) string(field int, v string) {
+	if v != "" {
+		m.bytes(field, []byte(v))
+	}
+}
+
+// message embeds v, even when it is empty, so repeated fields keep their
+// count.
+func (m *protoMessage) message(field int, v protoMessage) {
+	m.bytes(field, v)
+}
+
+func (m *protoMessage) bytes(field int, v []byte) {
+	m.tag(field, wireBytes)
+	*m = binary.AppendUvarint(*m, uint64(len(v)))
+	*m = append(*m, v...)
+}
+
+var errBadProto = errors.New("malformed protobuf message")
+
+// parseProto calls fn for every field of a message with its varint or its
+// bytes; fixed-size fields, which no request has, are skipped.
+func parseProto(data []byte, fn func(field int, v uint64, b []byte)) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errBadProto
+		}
+		data = data[n:]
+		field := int(key >> 3)
+
+		switch key & 7 {
+		case wireVarint:
+			v, n := binary.Uvarint(data)
+			if n <= 0 {
+				return errBadProto
+			}
+			data = data[n:]
+			fn(field, v, nil)
+		case wireBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || size > uint64(len(data)-n) {
+				return errBadProto
+			}
+			fn(field, 0, data[n:n+int(size)])
+			data = data[n+int(size):]
+		case wireFixed64:
+			if len(data) < 8 {
+				return errBadProto
+			}
+			data = data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return errBadProto
+			}
+			data = data[4:]
+		default:
+			return errBadProto
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestProtoMessageEncoding(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(m *protoMessage)
+		want  []byte
+	}{
+		// The examples of the protocol buffers encoding documentation
+		{"varint", func(m *protoMessage) { m.int(1, 150) }, []byte{0x08, 0x96, 0x01}},
+		{"string", func(m *protoMessage) { m.string(2, "testing") }, []byte{0x12, 0x07, 't', 'e', 's', 't', 'i', 'n', 'g'}},
+		{"bool", func(m *protoMessage) { m.bool(5, true) }, []byte{0x28, 0x01}},
+		{"double", func(m *protoMessage) { m.double(10, 1) }, []byte{0x51, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f}},
+		{"negative", func(m *protoMessage) { m.int(1, -1) }, []byte{0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"zero values left out", func(m *protoMessage) {
+			m.int(1, 0)
+			m.string(2, "")
+			m.bool(3, false)
+			m.double(4, 0)
+		}, nil},
+		{"empty message kept", func(m *protoMessage) { m.message(1, nil) }, []byte{0x0a, 0x00}},
+		{"large field number", func(m *protoMessage) { m.int(16, 1) }, []byte{0x80, 0x01, 0x01}},
+	}
+	for _, tt := range tests {
+		var m protoMessage
+		tt.build(&m)
+		if !bytes.Equal(m, tt.want) {
+			t.Errorf("%s: encoded % x, want % x", tt.name, []byte(m), tt.want)
+		}
+	}
+}
+
+func TestProtoRoundTrip(t *testing.T) {
+	var inner protoMessage
+	inner.int(1, 7)
+	inner.string(2, "queued")
+
+	var m protoMessage
+	m.int(1, 42)
+	m.string(2, "https://example.com/album/ä")
+	m.double(3, math.Pi) // skipped by parseProto
+	m.bool(4, true)
+	m.int(5, -3)
+	m.message(6, inner)
+	m.message(6, nil)
+	m.int(1000, 1<<40)
+
+	type field struct {
+		field int
+		v     uint64
+		b     string
+	}
+	var got []field
+	if err := parseProto(m, func(f int, v uint64, b []byte) {
+		got = append(got, field{f, v, string(b)})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := []field{
+		{1, 42, ""},
+		{2, 0, "https://example.com/album/ä"},
+		{4, 1, ""},
+		{5, uint64(math.MaxUint64 - 2), ""}, // int64(-3)
+		{6, 0, string(inner)},
+		{6, 0, ""},
+		{1000, 1 << 40, ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseProto = %+v, want %+v", got, want)
+	}
+	if int64(got[3].v) != -3 {
+		t.Errorf("negative int came back as %d", int64(got[3].v))
+	}
+
+	var nested []field
+	if err := parseProto([]byte(got[4].b), func(f int, v uint64, b []byte) {
+		nested = append(nested, field{f, v, string(b)})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []field{{1, 7, ""}, {2, 0, "queued"}}; !reflect.DeepEqual(nested, want) {
+		t.Errorf("embedded message = %+v, want %+v", nested, want)
+	}
+}
+
+func TestParseProtoMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated key", []byte{0x80}},
+		{"truncated varint", []byte{0x08, 0x96}},
+		{"length past the end", []byte{0x12, 0x07, 't', 'e'}},
+		{"huge length", []byte{0x12, 0xff, 0xff, 0xff, 0xff, 0x0f}},
+		{"truncated fixed64", []byte{0x19, 0, 0, 0}},
+		{"truncated fixed32", []byte{0x1d, 0, 0}},
+		{"group wire type", []byte{0x0b}},
+	}
+	for _, tt := range tests {
+		err := parseProto(tt.data, func(int, uint64, []byte) {})
+		if !errors.Is(err, errBadProto) {
+			t.Errorf("%s: parseProto(% x) error = %v, want %v", tt.name, tt.data, err, errBadProto)
+		}
+	}
+	if err := parseProto(nil, func(int, uint64, []byte) { t.Error("called for an empty message") }); err != nil {
+		t.Errorf("parseProto(empty) error = %v", err)
+	}
+}
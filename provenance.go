@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	provenanceTags    = "tags"
+	provenanceSidecar = "sidecar"
+	provenanceBoth    = "both"
+)
+
+// provenanceSuffix is added to a track's file name for its sidecar, e.g.
+// "01 Title.flac.source.json".
+const provenanceSuffix = ".source.json"
+
+// provenance records where a downloaded file came from, so it can be
+// traced and checked against the site again years later.
+type provenance struct {
+	Album      string `json:"album,omitempty"`
+	AlbumURL   string `json:"album_url,omitempty"`
+	Track      string `json:"track"`
+	TrackURL   string `json:"track_url,omitempty"`
+	FileURL    string `json:"file_url"`
+	Downloaded string `json:"downloaded"` // RFC 3339, UTC
+	Tool       string `json:"tool"`
+	Size       int64  `json:"size,omitempty"`
+	SHA256     string `json:"sha256,omitempty"`
+}
+
+// newProvenance describes a track downloaded from fileURL just now. album
+// is nil for single songs.
+func newProvenance(album *Album, song *Song, fileURL string) provenance {
+	p := provenance{
+		Track:      song.Name,
+		TrackURL:   song.SongLink,
+		FileURL:    fileURL,
+		Downloaded: time.Now().UTC().Format(time.RFC3339),
+		Tool:       programName + " " + currentVersion().Version,
+	}
+	if album != nil {
+		p.Album = album.Name
+		p.AlbumURL = album.AlbumLink
+	}
+	return p
+}
+
+// tags are the custom tags --provenance tags adds: SOURCE_URL, the album
+// page or the song's without one, DOWNLOADED, the date, and DOWNLOADED_WITH.
+func (p provenance) tags() map[string]string {
+	source := p.AlbumURL
+	if source == "" {
+		source = p.TrackURL
+	}
+	return map[string]string{
+		"SOURCE_URL":      source,
+		"DOWNLOADED":      p.Downloaded[:len("2006-01-02")],
+		"DOWNLOADED_WITH": p.Tool,
+	}
+}
+
+// trackTags are the tags to write on a downloaded track: the mapped album
+// tags with --tags and the provenance ones with --provenance tags or both.
+func trackTags(album *Album, prov provenance, opts *Options) map[string]string {
+	tags := make(map[string]string)
+	if opts.Tags && album != nil {
+		tags = opts.TagMap.TagsFor(album)
+	}
+	if opts.Provenance == provenanceTags || opts.Provenance == provenanceBoth {
+		for tag, value := range prov.tags() {
+			tags[tag] = value
+		}
+	}
+	return tags
+}
+
+// writeProvenanceSidecar saves prov next to the file with its size and
+// checksum, taken after tagging so they match the file as it is kept.
+func writeProvenanceSidecar(path string, prov provenance, opts *Options) error {
+	if opts.Provenance != provenanceSidecar && opts.Provenance != provenanceBoth {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if prov.Size, err = io.Copy(h, f); err != nil {
+		return err
+	}
+	prov.SHA256 = hex.EncodeToString(h.Sum(nil))
+
+	data, err := json.MarshalIndent(prov, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+provenanceSuffix, append(data, '\n'), 0644)
+}
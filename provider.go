@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// SiteProvider reads albums from one download site. The provider is picked
+// by the host of the URL, so more sites can be added next to khinsider and
+// share the naming, tagging, resume and archive features.
+type SiteProvider interface {
+	// Name is shown in messages, e.g. "khinsider".
+	Name() string
+	// Hosts are the domains the provider handles, with their subdomains.
+	Hosts() []string
+	// ResolveAlbum turns what the user typed into the album page URL. It
+	// fails with errInvalidInput for pages that aren't albums.
+	ResolveAlbum(input string) (string, error)
+	// ListTracks reads the album page: its details and track list.
+	ListTracks(albumURL string) (*Album, error)
+	// ResolveDownload fills in the download links of a track, per format.
+	ResolveDownload(song *Song) error
+}
+
+var (
+	khinsider     SiteProvider = khinsiderProvider{}
+	openDirectory SiteProvider = directoryProvider{}
+)
+
+// providers are asked in order; khinsider also takes what has no host, like
+// album slugs, and openDirectory the remaining URLs.
+var providers = []SiteProvider{khinsider, zopharProvider{}}
+
+// providerFor returns the provider for a URL's host. Other http(s) URLs are
+// read as a directory listing.
+func providerFor(input string) SiteProvider {
+	input = strings.TrimSpace(input)
+	explicit := strings.Contains(input, "://")
+	if !explicit {
+		input = "https://" + input
+	}
+	u, err := url.Parse(input)
+	if err != nil {
+		return khinsider
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, p := range providers {
+		for _, domain := range p.Hosts() {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return p
+			}
+		}
+	}
+	if explicit && (u.Scheme == "http" || u.Scheme == "https") {
+		return openDirectory
+	}
+	return khinsider
+}
+
+// resolveAlbumURL returns the album page URL for the input, from the
+// provider of its site.
+func resolveAlbumURL(input string) (string, error) {
+	return providerFor(input).ResolveAlbum(input)
+}
+
+func ParseAlbumPage(albumURL string) (*Album, error) {
+	return providerFor(albumURL).ListTracks(albumURL)
+}
+
+func ParseDownloadLinks(song *Song) error {
+	if song.SongLink == "" {
+		return fmt.Errorf("no song link available")
+	}
+	return providerFor(song.SongLink).ResolveDownload(song)
+}
+
+// addFileLink adds a direct link to an audio file under its extension's
+// format.
+func addFileLink(song *Song, fileURL string) {
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return
+	}
+	if ext := strings.ToUpper(strings.TrimPrefix(path.Ext(u.Path), ".")); ext != "" {
+		song.DownloadLinks[ext] = fileURL
+	}
+}
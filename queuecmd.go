@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const queueUsage = "usage: khinsider_downloader queue add [--priority low|normal|high] <album_url>... | list | rm <id> | prioritize <id> | priority <id> low|normal|high [--server <url>] [--token <token> | --basic-auth <user:password>]"
+
+// runQueue manages the queue of a running server: adding albums, listing,
+// removing and reordering them while it keeps downloading.
+func runQueue(args []string) error {
+	args, err := withEnvArgs("queue", args)
+	if err != nil {
+		return err
+	}
+	conn, rest, err := parseServerArgs(args)
+	if err != nil {
+		return err
+	}
+	if len(rest) == 0 {
+		return newClassError(errInvalidInput, queueUsage)
+	}
+
+	action, rest := rest[0], rest[1:]
+	switch action {
+	case "add":
+		priority := ""
+		if len(rest) > 1 && rest[0] == "--priority" {
+			priority, rest = rest[1], rest[2:]
+			if _, err := parsePriority(priority); err != nil {
+				return newClassError(errInvalidInput, err.Error())
+			}
+		}
+		if len(rest) == 0 {
+			return newClassError(errInvalidInput, queueUsage)
+		}
+		for _, albumURL := range rest {
+			var item queueItem
+			if err := conn.do("POST", "/api/queue", map[string]string{"url": albumURL, "priority": priority}, &item); err != nil {
+				return err
+			}
+			fmt.Printf("Queued #%d %s (%s priority)\n", item.ID, item.URL, item.Priority)
+		}
+		return nil
+
+	case "list", "ls":
+		var items []queueItem
+		if err := conn.do("GET", "/api/queue", nil, &items); err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			fmt.Println("The queue is empty.")
+		}
+		for _, item := range items {
+			name := item.Album
+			if name == "" {
+				name = item.URL
+			}
+			fmt.Printf("#%-4d %-12s %-7s %s\n", item.ID, item.Status, item.Priority, name)
+		}
+		return nil
+
+	case "priority":
+		if len(rest) != 2 {
+			return newClassError(errInvalidInput, queueUsage)
+		}
+		id, err := strconv.Atoi(rest[0])
+		if err != nil {
+			return newClassError(errInvalidInput, fmt.Sprintf("invalid queue item id: %s", rest[0]))
+		}
+		if _, err := parsePriority(rest[1]); err != nil {
+			return newClassError(errInvalidInput, err.Error())
+		}
+		var item queueItem
+		if err := conn.do("POST", fmt.Sprintf("/api/queue/%d/priority", id), map[string]string{"priority": rest[1]}, &item); err != nil {
+			return err
+		}
+		fmt.Printf("#%d now has %s priority\n", item.ID, item.Priority)
+		return nil
+
+	case "rm", "remove", "prioritize":
+		if len(rest) != 1 {
+			return newClassError(errInvalidInput, queueUsage)
+		}
+		id, err := strconv.Atoi(rest[0])
+		if err != nil {
+			return newClassError(errInvalidInput, fmt.Sprintf("invalid queue item id: %s", rest[0]))
+		}
+		var item queueItem
+		if action == "prioritize" {
+			if err := conn.do("POST", fmt.Sprintf("/api/queue/%d/prioritize", id), nil, &item); err != nil {
+				return err
+			}
+			fmt.Printf("#%d is next, with high priority\n", item.ID)
+			return nil
+		}
+		if err := conn.do("DELETE", fmt.Sprintf("/api/queue/%d", id), nil, &item); err != nil {
+			return err
+		}
+		fmt.Printf("Removed #%d %s\n", item.ID, item.URL)
+		return nil
+	}
+	return newClassError(errInvalidInput, queueUsage)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+)
+
+// randomAlbumURL redirects to a random album on the site.
+const randomAlbumURL = "https://downloads.khinsider.com/random-album"
+
+// runRandom picks a random album from the local index, or from the site
+// when there is no index, and prints or downloads it.
+func runRandom(args []string) error {
+	var filter catalogFilter
+	download := false
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--platform" && i+1 < len(args):
+			filter.platform = args[i+1]
+			i++
+		case args[i] == "--year" && i+1 < len(args):
+			if err := filter.parseYears(args[i+1]); err != nil {
+				return err
+			}
+			i++
+		case args[i] == "--download":
+			download = true
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+
+	opts, positional, err := parseOptions(rest)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return fmt.Errorf("usage: khinsider_downloader random [--platform <name>] [--year <year[-year]>] [--download] [options]")
+	}
+
+	albumURL, err := pickRandomAlbum(filter)
+	if err != nil {
+		return err
+	}
+	if !download {
+		return nil
+	}
+
+	logln()
+	_, err = downloadAlbum(albumURL, opts)
+	return err
+}
+
+func pickRandomAlbum(filter catalogFilter) (string, error) {
+	c, err := loadCatalog()
+	if err != nil {
+		if filter.active() {
+			return "", fmt.Errorf("--platform and --year need the local album index: %v", err)
+		}
+		albumURL, err := followRedirects(randomAlbumURL)
+		if err != nil {
+			return "", err
+		}
+		if classifyURL(albumURL) != albumPage {
+			return "", fmt.Errorf("the random album page led to %s", albumURL)
+		}
+		fmt.Println(albumURL)
+		return albumURL, nil
+	}
+
+	var candidates []catalogAlbum
+	for _, album := range c.Albums {
+		if filter.match(album) {
+			candidates = append(candidates, album)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("no albums in the index match the filters")
+	}
+	album := candidates[rand.IntN(len(candidates))]
+	printCatalogAlbums([]catalogAlbum{album})
+	return album.URL(), nil
+}
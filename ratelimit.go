@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket shared by all downloads, so --max-rate caps
+// the total throughput no matter how many run at once.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	tokens float64
+	last   time.Time
+}
+
+// bandwidth is the global limiter, nil when unlimited.
+var bandwidth *rateLimiter
+
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	return &rateLimiter{rate: float64(bytesPerSecond), last: time.Now()}
+}
+
+// wait blocks until n bytes may be transferred.
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	// Allow bursts of at most one second worth of data
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+// limitedReader throttles reads through the shared limiter.
+type limitedReader struct {
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (r *limitedReader) Read(b []byte) (int, error) {
+	// Small reads keep the rate smooth between concurrent downloads
+	if len(b) > 32*1024 {
+		b = b[:32*1024]
+	}
+	n, err := r.r.Read(b)
+	if n > 0 {
+		r.limiter.wait(n)
+	}
+	return n, err
+}
+
+// throttle wraps r with the global bandwidth limit, if there is one.
+func throttle(r io.Reader) io.Reader {
+	if bandwidth == nil {
+		return r
+	}
+	return &limitedReader{r: r, limiter: bandwidth}
+}
+
+// parseRate parses rates like "500K", "2M" or "1.5MB" into bytes per second.
+func parseRate(rate string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(rate))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "/S"), "B")
+
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1 << 30
+	}
+	s = strings.TrimRight(s, "KMG")
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate: %s", rate)
+	}
+	return int64(n * multiplier), nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Not every album has been rated. Where it has, the album page shows the
+// average like "Rating: 4.52/5 (123 votes)".
+var (
+	ratingPattern    = regexp.MustCompile(`(?i)rating:?\s*([0-9]+(?:\.[0-9]+)?)\s*(?:/|out of)\s*([0-9]+)`)
+	votesPattern     = regexp.MustCompile(`(?i)([0-9][0-9,]*)\s+votes?\b`)
+	downloadsPattern = regexp.MustCompile(`(?i)(?:downloads|downloaded):?\s*([0-9][0-9,]*)`)
+)
+
+// parseAlbumRating reads the rating, number of votes and download count from
+// the album page's text, scaling the rating to 0-5.
+func parseAlbumRating(album *Album, text string) {
+	if m := ratingPattern.FindStringSubmatch(text); m != nil {
+		value, _ := strconv.ParseFloat(m[1], 64)
+		scale, _ := strconv.ParseFloat(m[2], 64)
+		if scale > 0 && value <= scale {
+			album.Rating = value * 5 / scale
+		}
+	}
+	if m := votesPattern.FindStringSubmatch(text); m != nil {
+		album.Votes, _ = strconv.Atoi(strings.ReplaceAll(m[1], ",", ""))
+	}
+	if m := downloadsPattern.FindStringSubmatch(text); m != nil {
+		album.Downloads, _ = strconv.Atoi(strings.ReplaceAll(m[1], ",", ""))
+	}
+}
+
+// formatRating prints a rating like "4.5/5 (123 votes)".
+func formatRating(album *Album) string {
+	if album.Rating == 0 {
+		return ""
+	}
+	s := fmt.Sprintf("%.1f/5", album.Rating)
+	if album.Votes > 0 {
+		s += fmt.Sprintf(" (%d votes)", album.Votes)
+	}
+	return s
+}
+
+// ratingTooLow tells why an album is below --min-rating, unrated albums
+// included.
+func ratingTooLow(album *Album, minRating float64) (string, bool) {
+	switch {
+	case minRating == 0:
+		return "", false
+	case album.Rating == 0:
+		return "not rated", true
+	case album.Rating < minRating:
+		return fmt.Sprintf("rated %.1f, below %.1f", album.Rating, minRating), true
+	}
+	return "", false
+}
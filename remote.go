@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// serverConn talks to the API of a running server for the status and
+// queue commands.
+type serverConn struct {
+	url       string
+	token     string
+	basicAuth string // user:password
+}
+
+// parseServerArgs reads --server, --token and --basic-auth from args and
+// returns the rest.
+func parseServerArgs(args []string) (*serverConn, []string, error) {
+	conn := &serverConn{url: "http://localhost:8080"}
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--server" && i+1 < len(args):
+			conn.url = strings.TrimSuffix(args[i+1], "/")
+			i++
+		case args[i] == "--token" && i+1 < len(args):
+			conn.token = args[i+1]
+			i++
+		case args[i] == "--basic-auth" && i+1 < len(args):
+			conn.basicAuth = args[i+1]
+			i++
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	var err error
+	if conn.token, err = resolveSecret(conn.token); err != nil {
+		return nil, nil, err
+	}
+	if user, pass, ok := strings.Cut(conn.basicAuth, ":"); ok {
+		if pass, err = resolveSecret(pass); err != nil {
+			return nil, nil, err
+		}
+		conn.basicAuth = user + ":" + pass
+	}
+	return conn, rest, nil
+}
+
+// do sends a request with body as JSON and decodes the JSON answer into
+// out. Error answers are returned with the server's message.
+func (c *serverConn) do(method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.url+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if user, pass, ok := strings.Cut(c.basicAuth, ":"); ok {
+		req.SetBasicAuth(user, pass)
+	}
+
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		var netErr *net.OpError
+		if errors.As(err, &netErr) {
+			return fmt.Errorf("no server running at %s: %v", c.url, err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("server answered %s: %s", resp.Status, apiErr.Error)
+		}
+		return fmt.Errorf("server answered %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("reading the answer: %v", err)
+	}
+	return nil
+}
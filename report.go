@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runReport collects what happened to every album of a run for the email
+// report.
+type runReport struct {
+	mu      sync.Mutex
+	started time.Time
+	entries []reportEntry
+}
+
+type reportEntry struct {
+	URL        string
+	Album      string
+	Successful int
+	Failed     int
+	SavedTo    string
+	Error      string
+	NewTracks  int
+	Replaced   []string
+}
+
+var report = &runReport{started: time.Now()}
+
+func (r *runReport) add(albumURL string, result *AlbumResult, err error) {
+	entry := reportEntry{URL: albumURL}
+	switch {
+	case err != nil:
+		entry.Error = err.Error()
+	case result == nil || result.Skipped || result.Unchanged:
+		return
+	default:
+		entry.Album = result.Album.Name
+		entry.Successful = result.Successful
+		entry.Failed = result.Failed
+		entry.SavedTo = result.SavedTo
+		entry.NewTracks = result.NewTracks
+		entry.Replaced = result.Replaced
+	}
+
+	r.mu.Lock()
+	r.entries = append(r.entries, entry)
+	r.mu.Unlock()
+}
+
+func (r *runReport) body() (string, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var done, failed []reportEntry
+	for _, e := range r.entries {
+		if e.Error != "" || e.Failed > 0 {
+			failed = append(failed, e)
+		} else {
+			done = append(done, e)
+		}
+	}
+
+	subject := fmt.Sprintf("%s: %d album(s) downloaded", programName, len(done))
+	if len(failed) > 0 {
+		subject += fmt.Sprintf(", %d with failures", len(failed))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Run started %s, took %v.\n", r.started.Format("2006-01-02 15:04"), time.Since(r.started).Round(time.Second))
+	if len(done) > 0 {
+		b.WriteString("\nDownloaded:\n")
+		for _, e := range done {
+			fmt.Fprintf(&b, "  %s (%d tracks)\n    %s\n", e.Album, e.Successful, e.SavedTo)
+			if e.NewTracks > 0 {
+				fmt.Fprintf(&b, "    %d new track(s)\n", e.NewTracks)
+			}
+			for _, name := range e.Replaced {
+				fmt.Fprintf(&b, "    Replaced: %s\n", name)
+			}
+		}
+	}
+	if len(failed) > 0 {
+		b.WriteString("\nFailures:\n")
+		for _, e := range failed {
+			if e.Error != "" {
+				fmt.Fprintf(&b, "  %s\n    %s\n", e.URL, e.Error)
+			} else {
+				fmt.Fprintf(&b, "  %s: %d of %d tracks failed\n    %s\n", e.Album, e.Failed, e.Failed+e.Successful, e.SavedTo)
+			}
+		}
+	}
+	return subject, b.String()
+}
+
+// sendEmailReport mails the report if anything was downloaded or failed.
+func sendEmailReport() error {
+	report.mu.Lock()
+	empty := len(report.entries) == 0
+	report.mu.Unlock()
+	if empty {
+		return nil
+	}
+
+	cfg, err := appConfig()
+	if err != nil {
+		return err
+	}
+	s := cfg.SMTP
+	if s.Host == "" || s.From == "" || len(s.To) == 0 {
+		return fmt.Errorf("--email-report needs smtp host, from and to in the config file")
+	}
+	if s.Port == 0 {
+		s.Port = 587
+	}
+
+	subject, body := report.body()
+	msg := "From: " + s.From + "\r\n" +
+		"To: " + strings.Join(s.To, ", ") + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n\r\n" +
+		strings.ReplaceAll(body, "\n", "\r\n")
+
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	if s.Port != 465 {
+		// SendMail upgrades to STARTTLS when the server offers it
+		return smtp.SendMail(addr, auth, s.From, s.To, []byte(msg))
+	}
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: s.Host})
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	for _, to := range s.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// scheduleWindow is the time of day downloads may run in, e.g. 01:00-07:00.
+// A window whose end is before its start spans midnight.
+type scheduleWindow struct {
+	start, end time.Duration // since midnight
+}
+
+// downloadSchedule is set by --schedule, nil to download any time.
+var downloadSchedule *scheduleWindow
+
+var errOutsideSchedule = errors.New("outside the download window")
+
+func parseSchedule(s string) (*scheduleWindow, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, fmt.Errorf("expected HH:MM-HH:MM, got %s", s)
+	}
+	start, err := parseTimeOfDay(from)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseTimeOfDay(to)
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, fmt.Errorf("empty download window: %s", s)
+	}
+	return &scheduleWindow{start: start, end: end}, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day: %s", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func sinceMidnight(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
+
+func (w *scheduleWindow) contains(t time.Time) bool {
+	now := sinceMidnight(t)
+	if w.start < w.end {
+		return now >= w.start && now < w.end
+	}
+	return now >= w.start || now < w.end
+}
+
+// untilOpen returns how long until the window opens next, 0 when it is open.
+func (w *scheduleWindow) untilOpen(t time.Time) time.Duration {
+	if w.contains(t) {
+		return 0
+	}
+	wait := w.start - sinceMidnight(t)
+	if wait < 0 {
+		wait += 24 * time.Hour
+	}
+	return wait
+}
+
+// waitForSchedule blocks until downloads are allowed.
+func waitForSchedule() {
+	if downloadSchedule == nil {
+		return
+	}
+	if wait := downloadSchedule.untilOpen(time.Now()); wait > 0 {
+		logf("  Outside the download window, waiting %v\n", wait.Round(time.Minute))
+		time.Sleep(wait)
+	}
+}
+
+// scheduledReader stops a transfer when the download window closes.
+type scheduledReader struct {
+	r io.Reader
+}
+
+func (r *scheduledReader) Read(b []byte) (int, error) {
+	if !downloadSchedule.contains(time.Now()) {
+		return 0, errOutsideSchedule
+	}
+	return r.r.Read(b)
+}
+
+// scheduled wraps r so it stops at the end of the download window, if one
+// is set.
+func scheduled(r io.Reader) io.Reader {
+	if downloadSchedule == nil {
+		return r
+	}
+	return &scheduledReader{r: r}
+}
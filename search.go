@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type searchResult struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+const searchURL = "https://downloads.khinsider.com/search?search="
+
+// ParseSearchResults returns the albums listed on a search page.
+func ParseSearchResults(pageURL string) ([]searchResult, error) {
+	doc, err := fetchHTML(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	base, _ := url.Parse(pageURL)
+
+	var results []searchResult
+	seen := make(map[string]bool)
+	doc.Find(selectors().AlbumLinks).Each(func(i int, s *selection) {
+		name := strings.TrimSpace(s.Text())
+		href, _ := s.Attr("href")
+		ref, err := url.Parse(href)
+		if name == "" || err != nil {
+			return
+		}
+		albumURL := normalizeAlbumURL(base.ResolveReference(ref).String())
+		if classifyURL(albumURL) == albumPage && !seen[albumURL] {
+			seen[albumURL] = true
+			results = append(results, searchResult{Name: name, URL: albumURL})
+		}
+	})
+	return results, nil
+}
+
+// albumSearch is a search from the command line.
+type albumSearch struct {
+	query   string
+	filter  catalogFilter
+	offline bool
+	asJSON  bool
+}
+
+func parseSearchArgs(args []string) (*albumSearch, error) {
+	search := &albumSearch{}
+	var words []string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--offline":
+			search.offline = true
+		case args[i] == "--json":
+			search.asJSON = true
+		case args[i] == "--platform" && i+1 < len(args):
+			search.filter.platform = args[i+1]
+			i++
+		case args[i] == "--year" && i+1 < len(args):
+			if err := search.filter.parseYears(args[i+1]); err != nil {
+				return nil, err
+			}
+			i++
+		default:
+			words = append(words, args[i])
+		}
+	}
+	search.query = strings.Join(words, " ")
+	if strings.TrimSpace(search.query) == "" {
+		return nil, fmt.Errorf("usage: khinsider_downloader search [--offline] [--platform <name>] [--year <year[-year]>] [--json] <query>")
+	}
+	return search, nil
+}
+
+// runSearch searches khinsider, or with --offline the local album index.
+// An offline search falls back to the site when there is no index or it is
+// out of date.
+func runSearch(args []string) error {
+	search, err := parseSearchArgs(args)
+	if err != nil {
+		return err
+	}
+
+	if search.offline {
+		c, err := loadCatalog()
+		switch {
+		case err != nil:
+			logf("Searching online, %v\n", err)
+		case c.stale():
+			logf("The album index is from %s, searching online (run 'khinsider_downloader index build' to update it)\n", c.Built.Format("2006-01-02"))
+		default:
+			return search.printOffline(c)
+		}
+	}
+
+	if search.filter.active() {
+		logf("Note: --platform and --year only apply to the offline search\n")
+	}
+	pageURL := searchURL + url.QueryEscape(search.query)
+	if search.asJSON {
+		results, err := ParseSearchResults(pageURL)
+		if err != nil {
+			return err
+		}
+		return printJSON(results)
+	}
+	return printSearchResults(pageURL)
+}
+
+func (s *albumSearch) printOffline(c *catalog) error {
+	start := time.Now()
+	results := c.search(s.query, s.filter)
+	if s.asJSON {
+		return printJSON(results)
+	}
+	fmt.Printf("%d albums found in the local index (%.1f ms)\n", len(results), float64(time.Since(start).Microseconds())/1000)
+	printCatalogAlbums(results)
+	return nil
+}
+
+func printSearchResults(pageURL string) error {
+	results, err := ParseSearchResults(pageURL)
+	if err != nil {
+		logf("Error searching: %v\n", err)
+		return err
+	}
+
+	u, _ := url.Parse(pageURL)
+	logf("Search results for %q: %d albums\n", u.Query().Get("search"), len(results))
+	for _, r := range results {
+		logf("  %s\n    %s\n", r.Name, r.URL)
+	}
+	if len(results) > 0 {
+		logln("\nPass one of the album URLs to download it.")
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"sync"
+)
+
+// The CSS selectors pages are read with. The defaults are built in, and
+// "selectors" in the config file overrides single ones, so a change to the
+// site's markup can be worked around before a new release.
+//
+//go:embed selectors.json
+var defaultSelectorsJSON []byte
+
+type siteSelectors struct {
+	AlbumTitle    string `json:"album_title"`
+	AlbumInfo     string `json:"album_info"` // the paragraph with platforms, year, ...
+	AlbumText     string `json:"album_text"` // where the rating and download count are
+	AlbumImages   string `json:"album_images"`
+	SongTable     string `json:"song_table"`
+	SongHeader    string `json:"song_header"` // in the song table
+	SongRows      string `json:"song_rows"`   // in the song table
+	SongLink      string `json:"song_link"`   // in a row
+	SongCells     string `json:"song_cells"`  // in a row, the second one is the length
+	DownloadLinks string `json:"download_links"`
+	AlbumLinks    string `json:"album_links"` // on search and favorites pages
+	BrowseRows    string `json:"browse_rows"`
+	BrowseLinks   string `json:"browse_links"`
+
+	// zophar.net music pages
+	ZopharTitle    string `json:"zophar_title"`
+	ZopharInfo     string `json:"zophar_info"` // "Label: value" paragraphs
+	ZopharCover    string `json:"zophar_cover"`
+	ZopharRows     string `json:"zophar_rows"`
+	ZopharName     string `json:"zophar_name"`     // in a row
+	ZopharLength   string `json:"zophar_length"`   // in a row
+	ZopharDownload string `json:"zophar_download"` // in a row
+}
+
+var (
+	selectorsOnce   sync.Once
+	loadedSelectors siteSelectors
+)
+
+func selectors() *siteSelectors {
+	selectorsOnce.Do(func() {
+		if err := json.Unmarshal(defaultSelectorsJSON, &loadedSelectors); err != nil {
+			panic(err)
+		}
+		config, err := appConfig()
+		if err != nil || len(config.Selectors) == 0 {
+			return
+		}
+		var set map[string]string
+		if err := json.Unmarshal(config.Selectors, &set); err != nil {
+			logf("Ignoring the selectors in the config file: %v\n", err)
+			return
+		}
+		// One that doesn't parse would find nothing, or stop the lite build
+		for name, selector := range set {
+			if err := checkSelector(selector); err != nil {
+				logf("Ignoring the selector %s in the config file: %v\n", name, err)
+				delete(set, name)
+			}
+		}
+		valid, _ := json.Marshal(set)
+		json.Unmarshal(valid, &loadedSelectors)
+	})
+	return &loadedSelectors
+}
+
+// songTableChanged tells if the song table selectors were overridden, which
+// the streaming parser of --low-memory doesn't follow.
+func (s *siteSelectors) songTableChanged() bool {
+	var defaults siteSelectors
+	json.Unmarshal(defaultSelectorsJSON, &defaults)
+	return s.SongTable != defaults.SongTable || s.SongHeader != defaults.SongHeader ||
+		s.SongRows != defaults.SongRows || s.SongLink != defaults.SongLink || s.SongCells != defaults.SongCells
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const releasesURL = "https://api.github.com/repos/nalsai/khinsider_downloader/releases/latest"
+
+type githubRelease struct {
+	TagName string        `json:"tag_name"`
+	Assets  []githubAsset `json:"assets"`
+}
+
+type githubAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+func runSelfUpdate(args []string) error {
+	checkOnly := false
+	force := false
+	for _, arg := range args {
+		switch arg {
+		case "--check":
+			checkOnly = true
+		case "--force":
+			force = true
+		}
+	}
+
+	release, err := latestRelease()
+	if err != nil {
+		return fmt.Errorf("checking for updates: %v", err)
+	}
+
+	fmt.Printf("Current version: %s\n", version)
+	fmt.Printf("Latest version: %s\n", release.TagName)
+
+	if !force && version != "dev" && compareVersions(release.TagName, version) <= 0 {
+		fmt.Println("Already up to date")
+		return nil
+	}
+	if checkOnly {
+		return nil
+	}
+	if !force && version == "dev" {
+		return fmt.Errorf("this is a development build, use --force to replace it with %s", release.TagName)
+	}
+
+	asset := findReleaseAsset(release.Assets, runtime.GOOS, runtime.GOARCH)
+	if asset == nil {
+		return fmt.Errorf("no release asset for %s/%s", runtime.GOOS, runtime.GOARCH)
+	}
+
+	checksums := findChecksumAsset(release.Assets)
+	if checksums == nil {
+		return fmt.Errorf("release %s has no checksum file, refusing to update", release.TagName)
+	}
+
+	fmt.Printf("Downloading %s...\n", asset.Name)
+	data, err := fetchReleaseFile(asset.URL)
+	if err != nil {
+		return err
+	}
+
+	sums, err := fetchReleaseFile(checksums.URL)
+	if err != nil {
+		return err
+	}
+	if err := verifyChecksum(data, asset.Name, sums); err != nil {
+		return err
+	}
+
+	binary, err := extractBinary(asset.Name, data)
+	if err != nil {
+		return err
+	}
+
+	if err := replaceExecutable(binary); err != nil {
+		return err
+	}
+	fmt.Printf("Updated to %s\n", release.TagName)
+	return nil
+}
+
+func latestRelease() (*githubRelease, error) {
+	data, err := fetchReleaseFile(releasesURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var release githubRelease
+	if err := json.Unmarshal(data, &release); err != nil {
+		return nil, err
+	}
+	return &release, nil
+}
+
+func fetchReleaseFile(fileURL string) ([]byte, error) {
+	client := &http.Client{
+		Transport: httpTransport(),
+		Timeout:   5 * time.Minute,
+	}
+
+	req, err := http.NewRequest("GET", fileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", programName+"/"+version)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("status code: %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// findReleaseAsset picks the build for a platform, e.g.
+// khinsider_downloader_linux_amd64.tar.gz. The platform has to be a whole
+// part of the name, so arm doesn't pick the arm64 build.
+func findReleaseAsset(assets []githubAsset, goos, goarch string) *githubAsset {
+	platform := regexp.MustCompile(`(^|[_.-])` + regexp.QuoteMeta(goos) + `[_-]` + regexp.QuoteMeta(goarch) + `($|[_.-])`)
+	for i, a := range assets {
+		name := strings.ToLower(a.Name)
+		if platform.MatchString(name) && !isChecksumAsset(name) {
+			return &assets[i]
+		}
+	}
+	return nil
+}
+
+func findChecksumAsset(assets []githubAsset) *githubAsset {
+	for i, a := range assets {
+		if isChecksumAsset(strings.ToLower(a.Name)) {
+			return &assets[i]
+		}
+	}
+	return nil
+}
+
+func isChecksumAsset(name string) bool {
+	return strings.Contains(name, "checksums") || strings.Contains(name, "sha256sums")
+}
+
+// verifyChecksum checks data against a sha256sum style checksum file.
+func verifyChecksum(data []byte, name string, sums []byte) error {
+	sum := sha256.Sum256(data)
+	actual := hex.EncodeToString(sum[:])
+
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || strings.TrimPrefix(fields[1], "*") != name {
+			continue
+		}
+		if !strings.EqualFold(fields[0], actual) {
+			return fmt.Errorf("checksum mismatch for %s", name)
+		}
+		return nil
+	}
+	return fmt.Errorf("no checksum for %s", name)
+}
+
+// extractBinary returns the executable from a release asset, which is
+// either the bare binary or a zip/tar.gz containing it.
+func extractBinary(name string, data []byte) ([]byte, error) {
+	isBinary := func(path string) bool {
+		base := strings.TrimSuffix(filepath.Base(path), ".exe")
+		return base == programName
+	}
+
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range zr.File {
+			if isBinary(f.Name) {
+				rc, err := f.Open()
+				if err != nil {
+					return nil, err
+				}
+				defer rc.Close()
+				return io.ReadAll(rc)
+			}
+		}
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		tr := tar.NewReader(gz)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if header.Typeflag == tar.TypeReg && isBinary(header.Name) {
+				return io.ReadAll(tr)
+			}
+		}
+	default:
+		return data, nil
+	}
+	return nil, fmt.Errorf("%s does not contain %s", name, programName)
+}
+
+// replaceExecutable swaps the running binary for the new one. The old one
+// is moved aside first, which also works on Windows.
+func replaceExecutable(binary []byte) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return err
+	}
+
+	newPath := exe + ".new"
+	oldPath := exe + ".old"
+	if err := os.WriteFile(newPath, binary, 0755); err != nil {
+		return err
+	}
+
+	os.Remove(oldPath)
+	if err := os.Rename(exe, oldPath); err != nil {
+		os.Remove(newPath)
+		return err
+	}
+	if err := os.Rename(newPath, exe); err != nil {
+		os.Rename(oldPath, exe)
+		return err
+	}
+
+	// A running executable can't be deleted on Windows
+	if runtime.GOOS != "windows" {
+		os.Remove(oldPath)
+	}
+	return nil
+}
+
+// compareVersions compares two vX.Y.Z versions numerically.
+func compareVersions(a, b string) int {
+	pa := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	pb := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var na, nb int
+		if i < len(pa) {
+			na, _ = strconv.Atoi(strings.SplitN(pa[i], "-", 2)[0])
+		}
+		if i < len(pb) {
+			nb, _ = strconv.Atoi(strings.SplitN(pb[i], "-", 2)[0])
+		}
+		if na != nb {
+			if na < nb {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
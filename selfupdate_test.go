@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestFindReleaseAsset(t *testing.T) {
+	assets := []githubAsset{
+		{Name: "checksums.txt"},
+		{Name: "khinsider_downloader_linux_arm64.tar.gz"},
+		{Name: "khinsider_downloader_linux_arm.tar.gz"},
+		{Name: "khinsider_downloader_linux_amd64.tar.gz"},
+		{Name: "khinsider_downloader_windows_amd64.zip"},
+	}
+	tests := []struct {
+		goos, goarch, want string
+	}{
+		{"linux", "arm", "khinsider_downloader_linux_arm.tar.gz"},
+		{"linux", "arm64", "khinsider_downloader_linux_arm64.tar.gz"},
+		{"linux", "amd64", "khinsider_downloader_linux_amd64.tar.gz"},
+		{"windows", "amd64", "khinsider_downloader_windows_amd64.zip"},
+		{"darwin", "arm64", ""},
+		{"linux", "386", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if asset := findReleaseAsset(assets, tt.goos, tt.goarch); asset != nil {
+			got = asset.Name
+		}
+		if got != tt.want {
+			t.Errorf("findReleaseAsset(%s/%s) = %q, want %q", tt.goos, tt.goarch, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type queueItem struct {
+	ID         int       `json:"id"`
+	URL        string    `json:"url"`
+	Status     string    `json:"status"`   // queued, downloading, paused, completed, skipped, failed
+	Priority   string    `json:"priority"` // low, normal or high
+	Album      string    `json:"album,omitempty"`
+	Successful int       `json:"successful"`
+	Failed     int       `json:"failed"`
+	Error      string    `json:"error,omitempty"`
+	Code       string    `json:"code,omitempty"` // failure class, see errorClasses
+	Added      time.Time `json:"added"`
+	Finished   time.Time `json:"finished,omitzero"`
+	Pinned     time.Time `json:"pinned,omitzero"` // prioritized, goes before all others
+	SavedTo    string    `json:"saved_to,omitempty"`
+	User       string    `json:"user,omitempty"`
+
+	pause  *pauseSwitch
+	chatID int64 // Telegram chat that queued it
+}
+
+// server downloads the albums added to its queue one after another.
+type server struct {
+	opts *Options
+
+	mu       sync.Mutex
+	items    []*queueItem
+	nextID   int
+	wake     chan struct{}
+	started  time.Time
+	stopping bool           // no new downloads are started
+	active   sync.WaitGroup // the download in progress
+
+	bot    *telegramBot
+	events *eventHub
+	users  []serverUser
+	auth   serverAuth
+}
+
+func runServer(args []string) error {
+	args, err := withEnvArgs("serve", args)
+	if err != nil {
+		return err
+	}
+	listen := ":8080"
+	telegramToken := ""
+	var telegramChats []int64
+	var auth serverAuth
+	var tlsCert, tlsKey string
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--listen" && i+1 < len(args):
+			listen = args[i+1]
+			i++
+		case args[i] == "--telegram-token" && i+1 < len(args):
+			telegramToken = args[i+1]
+			i++
+		case args[i] == "--telegram-chat" && i+1 < len(args):
+			ids, err := parseChatIDs(args[i+1])
+			if err != nil {
+				return err
+			}
+			telegramChats = append(telegramChats, ids...)
+			i++
+		case args[i] == "--token" && i+1 < len(args):
+			token, err := resolveSecret(args[i+1])
+			if err != nil {
+				return err
+			}
+			auth.tokens = append(auth.tokens, token)
+			i++
+		case args[i] == "--basic-auth" && i+1 < len(args):
+			if err := auth.parseBasic(args[i+1]); err != nil {
+				return err
+			}
+			i++
+		case args[i] == "--tls-cert" && i+1 < len(args):
+			tlsCert = args[i+1]
+			i++
+		case args[i] == "--tls-key" && i+1 < len(args):
+			tlsKey = args[i+1]
+			i++
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+
+	opts, _, err := parseOptions(rest)
+	if err != nil {
+		return err
+	}
+	if (tlsCert == "") != (tlsKey == "") {
+		return fmt.Errorf("--tls-cert and --tls-key have to be used together")
+	}
+
+	s := &server{opts: opts, wake: make(chan struct{}, 1), events: newEventHub(), auth: auth}
+	addNotifier(s.events)
+	config, err := appConfig()
+	if err != nil {
+		return err
+	}
+	if err := s.setUsers(config.Users); err != nil {
+		return err
+	}
+	if telegramToken != "" {
+		if telegramToken, err = resolveSecret(telegramToken); err != nil {
+			return err
+		}
+		s.bot = newTelegramBot(telegramToken, telegramChats, s)
+		go s.bot.run()
+	}
+	go s.worker()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/queue", s.requireAuth(s.handleListQueue))
+	mux.HandleFunc("POST /api/queue", s.requireAuth(s.handleAddQueue))
+	mux.HandleFunc("POST /api/queue/{id}/pause", s.requireAuth(s.handlePauseItem))
+	mux.HandleFunc("POST /api/queue/{id}/resume", s.requireAuth(s.handleResumeItem))
+	mux.HandleFunc("POST /api/queue/{id}/prioritize", s.requireAuth(s.handlePrioritizeItem))
+	mux.HandleFunc("POST /api/queue/{id}/priority", s.requireAuth(s.handleSetPriority))
+	mux.HandleFunc("DELETE /api/queue/{id}", s.requireAuth(s.handleRemoveItem))
+	mux.HandleFunc("POST /api/pause", s.requireAdmin(s.handlePause))
+	mux.HandleFunc("POST /api/resume", s.requireAdmin(s.handlePause))
+	mux.HandleFunc("GET /api/events", s.requireAuth(s.events.handleEvents))
+	mux.HandleFunc("GET /api/status", s.requireAuth(s.handleStatus))
+	mux.HandleFunc("GET /feed.rss", s.requireAuth(s.handleFeed))
+	mux.HandleFunc("GET /feed.atom", s.requireAuth(s.handleFeed))
+	mux.HandleFunc("GET /metrics", s.requireAdmin(metrics.ServeHTTP))
+	addPprofRoutes(mux, s.requireAdmin)
+	mux.HandleFunc("POST /khinsider.v1.Downloader/{method}", s.requireAuth(s.handleGRPC))
+
+	// gRPC needs HTTP/2, also without TLS
+	srv := &http.Server{Addr: listen, Handler: mux, Protocols: new(http.Protocols)}
+	srv.Protocols.SetHTTP1(true)
+	srv.Protocols.SetHTTP2(true)
+	srv.Protocols.SetUnencryptedHTTP2(true)
+	return s.serve(srv, tlsCert, tlsKey)
+}
+
+func (s *server) add(albumURL string, priority int, chatID int64, user *serverUser) *queueItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	item := &queueItem{ID: s.nextID, URL: albumURL, Status: "queued", Priority: priorityNames[priority], Added: time.Now(), pause: &pauseSwitch{}, chatID: chatID}
+	if user != nil {
+		item.User = user.Name
+	}
+	s.items = append(s.items, item)
+	metrics.queueDepth.Add(1)
+	s.notify()
+	return item
+}
+
+// notify wakes the worker up if it is waiting for work.
+func (s *server) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *server) next() *queueItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopping {
+		return nil
+	}
+	// The album prioritized last goes first, then the highest priority
+	// wins, the first in the queue among equals
+	var next *queueItem
+	now := time.Now()
+	for _, item := range s.items {
+		if item.Status != "queued" {
+			continue
+		}
+		switch {
+		case next == nil:
+			next = item
+		case !item.Pinned.Equal(next.Pinned):
+			if item.Pinned.After(next.Pinned) {
+				next = item
+			}
+		case item.effectivePriority(now) > next.effectivePriority(now):
+			next = item
+		}
+	}
+	if next != nil {
+		next.Status = "downloading"
+		metrics.queueDepth.Add(-1)
+		s.active.Add(1)
+	}
+	return next
+}
+
+func (s *server) worker() {
+	for {
+		item := s.next()
+		if item == nil {
+			<-s.wake
+			continue
+		}
+
+		logf("\n=== Queue item %d: %s ===\n", item.ID, item.URL)
+		if s.bot != nil {
+			s.bot.itemStarted(item)
+		}
+		opts := *s.opts
+		opts.Pause = item.pause
+		var result *AlbumResult
+		// Each item is a run of its own for --max-failures, the failure
+		// alert and the caps; a new month's budget lets items download again
+		policy.reset()
+		caps.reset()
+		alert.reset()
+		s.events.setOwner(item.User)
+		caps.check()
+		err := policy.err()
+		if err == nil {
+			err = s.applyUser(item, &opts)
+		}
+		if err == nil {
+			result, err = func() (*AlbumResult, error) {
+				defer recoverCrash(item.URL)
+				return downloadAlbum(item.URL, &opts)
+			}()
+		}
+		if err == nil {
+			err = policy.err()
+		}
+
+		s.mu.Lock()
+		if errors.Is(err, errPaused) {
+			item.Status = "paused"
+		} else if err != nil {
+			item.Status = "failed"
+			item.Error = err.Error()
+			item.Code = errorCode(err)
+			if result != nil {
+				// Stopped part way through
+				item.Album = result.Album.Name
+				item.Successful = result.Successful
+				item.Failed = result.Failed
+				item.SavedTo = result.SavedTo
+			}
+		} else if result.Skipped {
+			item.Status = "skipped"
+			item.Error = result.SkipReason
+		} else {
+			item.Status = "completed"
+			item.Album = result.Album.Name
+			item.Successful = result.Successful
+			item.Failed = result.Failed
+			item.SavedTo = result.SavedTo
+		}
+		item.Finished = time.Now()
+		finished := *item
+		s.mu.Unlock()
+		s.events.setOwner("")
+		s.active.Done()
+
+		if s.bot != nil {
+			s.bot.itemFinished(&finished)
+		}
+	}
+}
+
+// user looks up a user by name.
+func (s *server) user(name string) *serverUser {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.users {
+		if s.users[i].Name == name {
+			return &s.users[i]
+		}
+	}
+	return nil
+}
+
+// statusText lists the queue in a few lines of text.
+func (s *server) statusText() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.items) == 0 {
+		return "The queue is empty."
+	}
+	var b strings.Builder
+	if queuePause.isPaused() {
+		b.WriteString("All transfers are paused.\n")
+	}
+	for _, item := range s.items {
+		name := item.Album
+		if name == "" {
+			name = item.URL
+		}
+		fmt.Fprintf(&b, "#%d %s: %s\n", item.ID, item.Status, name)
+	}
+	return b.String()
+}
+
+func (s *server) handleListQueue(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, http.StatusOK, s.queueFor(requestUser(r)))
+}
+
+// queueFor lists the items the user sees, with s.mu held.
+func (s *server) queueFor(user *serverUser) []*queueItem {
+	items := []*queueItem{}
+	for _, item := range s.items {
+		if item.visibleTo(user) {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (s *server) handleAddQueue(w http.ResponseWriter, r *http.Request) {
+	// Accept both form posts and JSON bodies
+	albumURL, priorityName := r.FormValue("url"), r.FormValue("priority")
+	if albumURL == "" && strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var body struct {
+			URL      string `json:"url"`
+			Priority string `json:"priority"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		albumURL, priorityName = body.URL, body.Priority
+	}
+	priority, err := parsePriority(priorityName)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if albumURL == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing url"})
+		return
+	}
+
+	user := requestUser(r)
+	over, err := s.overQuota(user)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if over {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": user.quotaError().Error()})
+		return
+	}
+
+	item := s.add(albumURL, priority, 0, user)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, http.StatusCreated, item)
+}
+
+// overQuota reports whether the user can't queue more albums.
+func (s *server) overQuota(user *serverUser) (bool, error) {
+	if user == nil {
+		return false, nil
+	}
+	return user.overQuota(s.opts.OutputDir)
+}
+
+func (s *server) findItem(w http.ResponseWriter, r *http.Request) *queueItem {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err == nil {
+		if item := s.itemByID(id, requestUser(r)); item != nil {
+			return item
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"error": "no such queue item"})
+	return nil
+}
+
+// itemByID returns the user's queue item with the id, or nil, with s.mu
+// held.
+func (s *server) itemByID(id int, user *serverUser) *queueItem {
+	for _, item := range s.items {
+		if item.ID == id && item.visibleTo(user) {
+			return item
+		}
+	}
+	return nil
+}
+
+// handlePauseItem pauses a queued or downloading album. A running transfer
+// stops right away and keeps its partial file.
+func (s *server) handlePauseItem(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item := s.findItem(w, r)
+	if item == nil {
+		return
+	}
+	if err := s.pauseItem(item); err != nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, item)
+}
+
+// pauseItem pauses an item, with s.mu held. Only queued and downloading
+// ones can be paused.
+func (s *server) pauseItem(item *queueItem) error {
+	switch item.Status {
+	case "queued":
+		item.Status = "paused"
+		metrics.queueDepth.Add(-1)
+	case "downloading":
+		// The worker marks it paused once the transfer has stopped
+	default:
+		return errors.New("item is " + item.Status)
+	}
+	item.pause.set(true)
+	return nil
+}
+
+func (s *server) handleResumeItem(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item := s.findItem(w, r)
+	if item == nil {
+		return
+	}
+	s.resumeItem(item)
+	writeJSON(w, http.StatusOK, item)
+}
+
+// resumeItem queues a paused item again, with s.mu held.
+func (s *server) resumeItem(item *queueItem) {
+	item.pause.set(false)
+	if item.Status == "paused" {
+		item.Status = "queued"
+		metrics.queueDepth.Add(1)
+		s.notify()
+	}
+}
+
+// handlePrioritizeItem makes a waiting album the next one to download,
+// ahead of any priority; a paused one is resumed. Prioritizing another album
+// afterwards puts that one in front of it.
+func (s *server) handlePrioritizeItem(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item := s.findItem(w, r)
+	if item == nil {
+		return
+	}
+	if item.Status != "queued" && item.Status != "paused" {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "item is " + item.Status})
+		return
+	}
+	item.Priority = priorityNames[priorityHigh]
+	item.Pinned = time.Now()
+	s.resumeItem(item)
+	writeJSON(w, http.StatusOK, item)
+}
+
+// handleSetPriority changes the priority of an album, with a "priority"
+// form value or JSON body.
+func (s *server) handleSetPriority(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("priority")
+	if name == "" && strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var body struct {
+			Priority string `json:"priority"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		name = body.Priority
+	}
+	priority, err := parsePriority(name)
+	if err != nil || name == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "priority must be low, normal or high"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item := s.findItem(w, r)
+	if item == nil {
+		return
+	}
+	item.Priority = priorityNames[priority]
+	item.Pinned = time.Time{} // the new priority counts, not an earlier prioritize
+	writeJSON(w, http.StatusOK, item)
+}
+
+// handleRemoveItem takes an album off the queue, or a finished one off the
+// list. A running download has to be paused first.
+func (s *server) handleRemoveItem(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item := s.findItem(w, r)
+	if item == nil {
+		return
+	}
+	if err := s.removeItem(item); err != nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, item)
+}
+
+// removeItem takes an item off the list, with s.mu held.
+func (s *server) removeItem(item *queueItem) error {
+	switch item.Status {
+	case "downloading":
+		return errors.New("item is downloading, pause it first")
+	case "queued":
+		metrics.queueDepth.Add(-1)
+	}
+	item.pause.set(true)
+	s.items = slices.DeleteFunc(s.items, func(i *queueItem) bool { return i == item })
+	return nil
+}
+
+// handlePause pauses or resumes all transfers.
+func (s *server) handlePause(w http.ResponseWriter, r *http.Request) {
+	paused := strings.HasSuffix(r.URL.Path, "/pause")
+	queuePause.set(paused)
+	writeJSON(w, http.StatusOK, map[string]bool{"paused": paused})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
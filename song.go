@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// downloadSong downloads a single track from its song page. The album page
+// is read too so the track lands in the album's folder with the same name,
+// tags, file times and cover art it would get from a full album download;
+// both go through the steps in track.go.
+func downloadSong(songURL string, opts *Options) error {
+	song := &Song{
+		SongLink:      songURL,
+		DownloadLinks: make(map[string]string),
+		Sizes:         make(map[string]int),
+	}
+
+	fail := func(err error) error {
+		logf("%s\n", colorize("failed", "Error: "+err.Error()))
+		emitProgress(progressEvent{Event: "track_failed", Track: songURL, Index: 1, Total: 1, Error: err.Error(), Code: errorCode(err)})
+		recordFailure()
+		return err
+	}
+
+	downloadDir := opts.OutputDir
+	track := 0
+	album, err := songAlbum(songURL)
+	if err != nil {
+		logf("Error reading the album page, saving without album info: %v\n", err)
+	} else {
+		downloadDir = filepath.Join(opts.OutputDir, sanitizeFilename(album.Name))
+		logf("Album: %s\n", album.Name)
+		for i, s := range album.Songs {
+			if sameSongLink(s.SongLink, songURL) {
+				track = i + 1
+				song.Name = s.Name
+				song.LengthSeconds = s.LengthSeconds
+				song.Disc = s.Disc
+			}
+		}
+	}
+	albumName := ""
+	if album != nil {
+		albumName = album.Name
+	}
+
+	if err := ParseDownloadLinks(song); err != nil {
+		return fail(fmt.Errorf("getting download links: %v", err))
+	}
+
+	downloadURL, note, skip := newFormatChooser(opts).choose(song)
+	if skip {
+		logln(colorize("skipped", fmt.Sprintf("Skipping (no %s)", strings.ToUpper(opts.Format))))
+		emitProgress(progressEvent{Event: "track_skipped", Album: albumName, Track: song.Name, Index: 1, Total: 1})
+		return nil
+	}
+	if note != "" {
+		logf("%s\n", colorize("fallback", note))
+	}
+	if downloadURL == "" {
+		return fail(fmt.Errorf("no download link found on %s", songURL))
+	}
+
+	if song.Name == "" {
+		// Named after the file without the album's track list
+		if u, err := url.Parse(downloadURL); err == nil {
+			name := sanitizeFilename(path.Base(u.Path))
+			song.Name = strings.TrimSuffix(name, path.Ext(name))
+		}
+	}
+	filename, err := trackFilename(album, song, track, downloadURL, opts)
+	if err != nil {
+		return fail(err)
+	}
+	song.Filename = filename
+	song.Fallback = missingFormat(song, opts)
+	filePath := filepath.Join(downloadDir, filename)
+	logf("Song: %s\n", song.Name)
+
+	os.MkdirAll(downloadDir, 0755)
+	unlock, err := lockAlbumDir(downloadDir)
+	if err != nil {
+		logf("Error: %v\n", err)
+		return err
+	}
+	defer unlock()
+
+	if opts.SavePage && album != nil {
+		if err := os.WriteFile(filepath.Join(downloadDir, "album.html"), album.page, 0644); err != nil {
+			logf("Error saving the album page: %v\n", err)
+		}
+	}
+	if opts.SaveSongPages && track > 0 {
+		saveSongPage(song, track, downloadDir)
+	}
+
+	_, statErr := os.Stat(filePath)
+	if _, ok := readExtracted(downloadDir).present(downloadDir, filename); ok || statErr == nil {
+		logln(colorize("skipped", "File already exists, skipping download"))
+		return nil
+	}
+
+	emitProgress(progressEvent{Event: "track_started", Album: albumName, Track: song.Name, Index: 1, Total: 1})
+	err = fetchTrack(downloadURL, filePath, albumName, song.Name, 1, 1, opts)
+	if errors.Is(err, errPaused) {
+		logln("Paused, the partial file will be resumed")
+		return err
+	}
+	if err != nil {
+		return fail(fmt.Errorf("downloading: %v", err))
+	}
+
+	logln(colorize("done", "Downloaded: "+filePath))
+	emitProgress(progressEvent{Event: "track_completed", Album: albumName, Track: song.Name, Index: 1, Total: 1, File: filePath})
+	metrics.completed.Add(1)
+
+	names := newTrackNames()
+	names.claim(filename, track)
+	finishTrack(album, song, track, filePath, downloadURL, names, opts)
+
+	// Only the cover, the rest of the scans belong to the full album
+	if album != nil && opts.Images && len(album.AlbumImages) > 0 {
+		downloadAlbumImages(album, []string{album.frontCover()}, downloadDir, opts)
+	}
+	return nil
+}
+
+// songAlbum parses the album page a song page belongs to.
+func songAlbum(songURL string) (*Album, error) {
+	u, err := url.Parse(songURL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Dir(u.Path)
+	u.RawPath = ""
+	return ParseAlbumPage(u.String())
+}
+
+// sameSongLink compares song URLs regardless of how they are escaped.
+func sameSongLink(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	return errA == nil && errB == nil && ua.Path == ub.Path
+}
+
+// saveSongPage keeps a copy of the song page in the album's pages folder.
+func saveSongPage(song *Song, track int, downloadDir string) {
+	dir := filepath.Join(downloadDir, "pages")
+	os.MkdirAll(dir, 0755)
+	name := fmt.Sprintf("%03d - %s.html", track, sanitizeFilename(song.Name))
+	if err := os.WriteFile(filepath.Join(dir, name), song.page, 0644); err != nil {
+		logf("  Error saving the song page: %v\n", err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// stallError is a download that received no data for the stall timeout.
+// It is a timeout like the net package's, so it's retried and may switch
+// to a mirror.
+type stallError struct {
+	timeout time.Duration
+}
+
+func (e *stallError) Error() string   { return fmt.Sprintf("no data received for %v", e.timeout) }
+func (e *stallError) Timeout() bool   { return true }
+func (e *stallError) Temporary() bool { return true }
+
+// stallWatch aborts a download whose connection goes quiet. Instead of a
+// limit on the whole transfer, which cut off large FLACs on slow links, only
+// the wait for the response and for each read is limited, so a big file
+// takes as long as it needs while a stalled connection still gives up.
+type stallWatch struct {
+	timeout time.Duration
+	cancel  context.CancelFunc
+	stalled atomic.Bool
+}
+
+// newStallWatch returns the context to send the request with and the watch
+// cancelling it.
+func newStallWatch(timeout time.Duration) (context.Context, *stallWatch) {
+	ctx, cancel := context.WithCancel(context.Background())
+	return ctx, &stallWatch{timeout: timeout, cancel: cancel}
+}
+
+// arm starts the timer for one wait and returns the function ending it.
+func (w *stallWatch) arm() func() {
+	if w.timeout <= 0 {
+		return func() {}
+	}
+	t := time.AfterFunc(w.timeout, func() {
+		w.stalled.Store(true)
+		w.cancel()
+	})
+	return func() { t.Stop() }
+}
+
+// check replaces the cancellation error of a stalled download.
+func (w *stallWatch) check(err error) error {
+	if err != nil && w.stalled.Load() {
+		return &stallError{timeout: w.timeout}
+	}
+	return err
+}
+
+func (w *stallWatch) close() {
+	w.cancel()
+}
+
+// reader limits each read from r to the timeout. Time spent outside of
+// reads, e.g. throttled or paused, doesn't count.
+func (w *stallWatch) reader(r io.Reader) io.Reader {
+	return &stallReader{r: r, watch: w}
+}
+
+type stallReader struct {
+	r     io.Reader
+	watch *stallWatch
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	done := s.watch.arm()
+	n, err := s.r.Read(p)
+	done()
+	return n, s.watch.check(err)
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// albumStats sums up the transfers of one album run. Runs can be appended
+// to a stats file as JSON lines with --stats-file.
+type albumStats struct {
+	Album     string                 `json:"album"`
+	URL       string                 `json:"url"`
+	Run       time.Time              `json:"run,omitzero"` // when the program was started, to group albums by run
+	Time      time.Time              `json:"time"`
+	Tracks    int                    `json:"tracks"`
+	Failed    int                    `json:"failed"`
+	Bytes     int64                  `json:"bytes"`
+	Elapsed   float64                `json:"elapsed_seconds"`
+	PeakSpeed float64                `json:"peak_speed"` // bytes per second
+	Formats   map[string]formatStats `json:"formats"`
+}
+
+type formatStats struct {
+	Tracks int   `json:"tracks"`
+	Bytes  int64 `json:"bytes"`
+}
+
+func newAlbumStats(album *Album) *albumStats {
+	return &albumStats{
+		Album:   album.Name,
+		URL:     album.AlbumLink,
+		Run:     report.started,
+		Time:    time.Now(),
+		Formats: make(map[string]formatStats),
+	}
+}
+
+// addTrack records a downloaded file and how long it took.
+func (s *albumStats) addTrack(path string, took time.Duration) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	format := strings.ToUpper(strings.TrimPrefix(filepath.Ext(path), "."))
+	f := s.Formats[format]
+	f.Tracks++
+	f.Bytes += info.Size()
+	s.Formats[format] = f
+
+	s.Tracks++
+	s.Bytes += info.Size()
+	if seconds := took.Seconds(); seconds > 0 {
+		s.PeakSpeed = max(s.PeakSpeed, float64(info.Size())/seconds)
+	}
+}
+
+func (s *albumStats) finish(failed int) {
+	s.Failed = failed
+	s.Elapsed = time.Since(s.Time).Seconds()
+}
+
+func (s *albumStats) averageSpeed() float64 {
+	if s.Elapsed == 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Elapsed
+}
+
+func (s *albumStats) print() {
+	if s.Tracks == 0 {
+		return
+	}
+	logf("Downloaded: %s in %v\n", formatBytes(s.Bytes), time.Duration(s.Elapsed*float64(time.Second)).Round(time.Second))
+	logf("Speed: %s/s average, %s/s peak\n", formatBytes(int64(s.averageSpeed())), formatBytes(int64(s.PeakSpeed)))
+
+	formats := make([]string, 0, len(s.Formats))
+	for format := range s.Formats {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	for _, format := range formats {
+		f := s.Formats[format]
+		logf("  %s: %d file(s), %s\n", format, f.Tracks, formatBytes(f.Bytes))
+	}
+}
+
+// statsFilePath is --stats-file, or stats.jsonl in the state directory where
+// every run is recorded by default.
+func statsFilePath(opts *Options) string {
+	if opts.StatsFile != "" {
+		return opts.StatsFile
+	}
+	return statePath("stats.jsonl", "")
+}
+
+// appendStats adds the run to the cumulative stats file.
+func appendStats(path string, s *albumStats) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		os.MkdirAll(dir, 0755)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// statsSummary is what the stats command prints, over all recorded album
+// runs or those since --since.
+type statsSummary struct {
+	Runs         int                    `json:"runs"`
+	Albums       int                    `json:"albums"`
+	Tracks       int                    `json:"tracks"`
+	Failed       int                    `json:"failed"`
+	Bytes        int64                  `json:"bytes"`
+	Elapsed      float64                `json:"elapsed_seconds"`
+	AverageSpeed float64                `json:"average_speed"` // bytes per second
+	PeakSpeed    float64                `json:"peak_speed"`
+	Formats      map[string]formatStats `json:"formats"`
+	Hours        [24]int64              `json:"bytes_by_hour"` // local time the albums were started
+	Months       map[string]int64       `json:"bytes_by_month"`
+	RecentRuns   []runStats             `json:"recent_runs"`
+}
+
+type runStats struct {
+	Started time.Time `json:"started"`
+	Albums  int       `json:"albums"`
+	Tracks  int       `json:"tracks"`
+	Failed  int       `json:"failed"`
+	Bytes   int64     `json:"bytes"`
+}
+
+// readStats reads the album runs of a stats file, skipping broken lines.
+func readStats(path string) ([]albumStats, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var all []albumStats
+	for _, line := range strings.Split(string(data), "\n") {
+		var s albumStats
+		if strings.TrimSpace(line) == "" || json.Unmarshal([]byte(line), &s) != nil {
+			continue
+		}
+		all = append(all, s)
+	}
+	return all, nil
+}
+
+func summarizeStats(all []albumStats, since time.Time) *statsSummary {
+	sum := &statsSummary{Formats: make(map[string]formatStats), Months: make(map[string]int64)}
+	runs := make(map[time.Time]*runStats)
+	for _, s := range all {
+		if s.Time.Before(since) {
+			continue
+		}
+		sum.Albums++
+		sum.Tracks += s.Tracks
+		sum.Failed += s.Failed
+		sum.Bytes += s.Bytes
+		sum.Elapsed += s.Elapsed
+		sum.PeakSpeed = max(sum.PeakSpeed, s.PeakSpeed)
+		for format, f := range s.Formats {
+			total := sum.Formats[format]
+			total.Tracks += f.Tracks
+			total.Bytes += f.Bytes
+			sum.Formats[format] = total
+		}
+		local := s.Time.Local()
+		sum.Hours[local.Hour()] += s.Bytes
+		sum.Months[local.Format("2006-01")] += s.Bytes
+
+		// Older lines have no run, each album counts as one
+		started := s.Run
+		if started.IsZero() {
+			started = s.Time
+		}
+		run := runs[started]
+		if run == nil {
+			run = &runStats{Started: started}
+			runs[started] = run
+		}
+		run.Albums++
+		run.Tracks += s.Tracks
+		run.Failed += s.Failed
+		run.Bytes += s.Bytes
+	}
+	if sum.Elapsed > 0 {
+		sum.AverageSpeed = float64(sum.Bytes) / sum.Elapsed
+	}
+
+	sum.Runs = len(runs)
+	for _, run := range runs {
+		sum.RecentRuns = append(sum.RecentRuns, *run)
+	}
+	sort.Slice(sum.RecentRuns, func(i, j int) bool { return sum.RecentRuns[i].Started.After(sum.RecentRuns[j].Started) })
+	if len(sum.RecentRuns) > 10 {
+		sum.RecentRuns = sum.RecentRuns[:10]
+	}
+	return sum
+}
+
+// busiestHours returns up to n hours of the day with the most downloaded,
+// busiest first.
+func (s *statsSummary) busiestHours(n int) []int {
+	var hours []int
+	for hour, bytes := range s.Hours {
+		if bytes > 0 {
+			hours = append(hours, hour)
+		}
+	}
+	sort.SliceStable(hours, func(i, j int) bool { return s.Hours[hours[i]] > s.Hours[hours[j]] })
+	if len(hours) > n {
+		hours = hours[:n]
+	}
+	return hours
+}
+
+func (s *statsSummary) print() {
+	fmt.Printf("Runs: %d, albums: %d, tracks: %d, failed: %d\n", s.Runs, s.Albums, s.Tracks, s.Failed)
+	fmt.Printf("Downloaded: %s in %v\n", formatBytes(s.Bytes), time.Duration(s.Elapsed*float64(time.Second)).Round(time.Second))
+	fmt.Printf("Speed: %s/s average, %s/s peak\n", formatBytes(int64(s.AverageSpeed)), formatBytes(int64(s.PeakSpeed)))
+	if s.Tracks+s.Failed > 0 {
+		fmt.Printf("Failure rate: %.1f%%\n", float64(s.Failed)*100/float64(s.Tracks+s.Failed))
+	}
+
+	formats := make([]string, 0, len(s.Formats))
+	for format := range s.Formats {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	if len(formats) > 0 {
+		fmt.Println("\nFormats:")
+	}
+	for _, format := range formats {
+		f := s.Formats[format]
+		fmt.Printf("  %-6s %d file(s), %s\n", format, f.Tracks, formatBytes(f.Bytes))
+	}
+
+	if hours := s.busiestHours(3); len(hours) > 0 {
+		fmt.Println("\nBusiest hours:")
+		for _, hour := range hours {
+			fmt.Printf("  %02d:00-%02d:00  %s\n", hour, (hour+1)%24, formatBytes(s.Hours[hour]))
+		}
+	}
+
+	months := make([]string, 0, len(s.Months))
+	for month := range s.Months {
+		months = append(months, month)
+	}
+	sort.Strings(months)
+	if len(months) > 12 {
+		months = months[len(months)-12:]
+	}
+	if len(months) > 0 {
+		fmt.Println("\nBy month:")
+	}
+	for _, month := range months {
+		fmt.Printf("  %s  %s\n", month, formatBytes(s.Months[month]))
+	}
+
+	if len(s.RecentRuns) > 0 {
+		fmt.Println("\nRecent runs:")
+	}
+	for _, run := range s.RecentRuns {
+		line := fmt.Sprintf("  %s  %d album(s), %d track(s), %s", run.Started.Local().Format("2006-01-02 15:04"), run.Albums, run.Tracks, formatBytes(run.Bytes))
+		if run.Failed > 0 {
+			line += fmt.Sprintf(", %d failed", run.Failed)
+		}
+		fmt.Println(line)
+	}
+}
+
+// runStatsCommand prints what the stats file recorded over time.
+func runStatsCommand(args []string) error {
+	args, err := withEnvArgs("stats", args)
+	if err != nil {
+		return err
+	}
+	opts := &Options{}
+	asJSON := false
+	var since time.Time
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--stats-file" && i+1 < len(args):
+			opts.StatsFile = args[i+1]
+			i++
+		case args[i] == "--since" && i+1 < len(args):
+			if since, err = parseSince(args[i+1]); err != nil {
+				return err
+			}
+			i++
+		case args[i] == "--json":
+			asJSON = true
+		default:
+			return fmt.Errorf("usage: khinsider_downloader stats [--stats-file <file>] [--since <date|duration>] [--json]")
+		}
+	}
+
+	path := statsFilePath(opts)
+	all, err := readStats(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("nothing recorded in %s yet", path)
+	}
+	if err != nil {
+		return err
+	}
+
+	summary := summarizeStats(all, since)
+	if asJSON {
+		return printJSON(summary)
+	}
+	summary.print()
+	return nil
+}
+
+// parseSince accepts a date (2024-01-31) or a duration back from now
+// (720h, or 30d in days).
+func parseSince(value string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return time.Now().AddDate(0, 0, -n), nil
+		}
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid --since: %s (use a date like 2024-01-31 or a duration like 30d)", value)
+	}
+	return time.Now().Add(-d), nil
+}
@@ -0,0 +1,563 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// TagMapping maps album metadata to tag values. Tag values are templates
+// (see expandTemplate) that may reference {album}, {year}, {platform},
+// {platforms}, {type}, {catalog} and {publisher}.
+type TagMapping struct {
+	Tags      map[string]string `json:"tags"`      // tag name -> template
+	Platforms map[string]string `json:"platforms"` // khinsider platform -> tag value
+}
+
+func defaultTagMapping() *TagMapping {
+	return &TagMapping{
+		Tags: map[string]string{
+			"GENRE":    "Soundtrack",
+			"GROUPING": "{platform}",
+			"MEDIA":    "{platform}",
+		},
+		Platforms: map[string]string{},
+	}
+}
+
+func loadTagMapping(path string) (*TagMapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	mapping := defaultTagMapping()
+	var override TagMapping
+	if err := json.Unmarshal(data, &override); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+
+	// Entries in the file replace the defaults, an empty value removes a tag
+	for tag, value := range override.Tags {
+		tag = strings.ToUpper(tag)
+		if err := checkTemplate(value); err != nil {
+			return nil, fmt.Errorf("%s: tag %s: %v", path, tag, err)
+		}
+		if value == "" {
+			delete(mapping.Tags, tag)
+		} else {
+			mapping.Tags[tag] = value
+		}
+	}
+	for platform, value := range override.Platforms {
+		mapping.Platforms[platform] = value
+	}
+
+	return mapping, nil
+}
+
+func (m *TagMapping) TagsFor(album *Album) map[string]string {
+	platforms := make([]string, 0, len(album.Platforms))
+	for _, p := range album.Platforms {
+		if mapped, ok := m.Platforms[p]; ok {
+			p = mapped
+		}
+		if p != "" {
+			platforms = append(platforms, p)
+		}
+	}
+
+	fields := albumFields(album)
+	fields["platform"] = ""
+	if len(platforms) > 0 {
+		fields["platform"] = platforms[0]
+	}
+	fields["platforms"] = strings.Join(platforms, "; ")
+
+	tags := make(map[string]string)
+	for tag, template := range m.Tags {
+		value, err := expandTemplate(template, fields)
+		if value = strings.TrimSpace(value); err == nil && value != "" {
+			tags[tag] = value
+		}
+	}
+	return tags
+}
+
+// writeTags sets the given tags on an audio file, keeping all other tags.
+func writeTags(path string, tags map[string]string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".flac":
+		return writeFLACTags(path, tags)
+	case ".mp3":
+		return writeID3Tags(path, tags)
+	default:
+		return fmt.Errorf("tagging %s files is not supported", filepath.Ext(path))
+	}
+}
+
+// replaceFile writes a new version of path through a temporary file,
+// keeping the original modification time.
+func replaceFile(path string, write func(w io.Writer) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tagtmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	err = write(out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Chtimes(path, info.ModTime(), info.ModTime())
+}
+
+// FLAC (Vorbis comments)
+
+const flacBlockVorbisComment = 4
+
+type flacBlock struct {
+	Type byte
+	Data []byte
+}
+
+func writeFLACTags(path string, tags map[string]string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil || string(magic) != "fLaC" {
+		return fmt.Errorf("not a FLAC file")
+	}
+
+	// Read all metadata blocks
+	var blocks []flacBlock
+	for {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(f, header); err != nil {
+			return fmt.Errorf("reading metadata: %v", err)
+		}
+
+		length := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		data := make([]byte, length)
+		if _, err := io.ReadFull(f, data); err != nil {
+			return fmt.Errorf("reading metadata: %v", err)
+		}
+
+		blocks = append(blocks, flacBlock{Type: header[0] & 0x7f, Data: data})
+		if header[0]&0x80 != 0 {
+			break
+		}
+	}
+
+	vendor := "khinsider_downloader"
+	var comments []string
+	var kept []flacBlock
+	for _, b := range blocks {
+		if b.Type == flacBlockVorbisComment {
+			vendor, comments, err = parseVorbisComment(b.Data)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		kept = append(kept, b)
+	}
+
+	// Drop the comments we are replacing
+	var merged []string
+	for _, c := range comments {
+		key, _, _ := strings.Cut(c, "=")
+		if _, replaced := tags[strings.ToUpper(key)]; !replaced {
+			merged = append(merged, c)
+		}
+	}
+	for _, key := range sortedKeys(tags) {
+		merged = append(merged, key+"="+tags[key])
+	}
+
+	if len(kept) == 0 {
+		return fmt.Errorf("missing STREAMINFO block")
+	}
+
+	// STREAMINFO must stay first, the comment block goes right after it
+	vorbis := flacBlock{Type: flacBlockVorbisComment, Data: buildVorbisComment(vendor, merged)}
+	blocks = append([]flacBlock{kept[0], vorbis}, kept[1:]...)
+
+	return replaceFile(path, func(w io.Writer) error {
+		if _, err := w.Write(magic); err != nil {
+			return err
+		}
+		for i, b := range blocks {
+			if len(b.Data) >= 1<<24 {
+				return fmt.Errorf("metadata block too large")
+			}
+			header := []byte{b.Type, byte(len(b.Data) >> 16), byte(len(b.Data) >> 8), byte(len(b.Data))}
+			if i == len(blocks)-1 {
+				header[0] |= 0x80
+			}
+			if _, err := w.Write(header); err != nil {
+				return err
+			}
+			if _, err := w.Write(b.Data); err != nil {
+				return err
+			}
+		}
+		// The file offset is right after the metadata, copy the audio frames
+		_, err := io.Copy(w, f)
+		return err
+	})
+}
+
+func parseVorbisComment(data []byte) (string, []string, error) {
+	r := bytes.NewReader(data)
+	readString := func() (string, error) {
+		var length uint32
+		if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+			return "", err
+		}
+		if int64(length) > int64(r.Len()) {
+			return "", io.ErrUnexpectedEOF
+		}
+		buf := make([]byte, length)
+		_, err := io.ReadFull(r, buf)
+		return string(buf), err
+	}
+
+	vendor, err := readString()
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid vorbis comment: %v", err)
+	}
+
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return "", nil, fmt.Errorf("invalid vorbis comment: %v", err)
+	}
+
+	comments := make([]string, 0)
+	for i := uint32(0); i < count; i++ {
+		c, err := readString()
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid vorbis comment: %v", err)
+		}
+		comments = append(comments, c)
+	}
+	return vendor, comments, nil
+}
+
+func buildVorbisComment(vendor string, comments []string) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(len(vendor)))
+	buf.WriteString(vendor)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(comments)))
+	for _, c := range comments {
+		binary.Write(&buf, binary.LittleEndian, uint32(len(c)))
+		buf.WriteString(c)
+	}
+	return buf.Bytes()
+}
+
+// MP3 (ID3v2.3 / ID3v2.4)
+
+type id3Frame struct {
+	ID    string
+	Flags [2]byte
+	Data  []byte
+}
+
+// Tag names that have a dedicated ID3 frame, anything else becomes TXXX
+var id3FrameIDs = map[string]string{
+	"TITLE":       "TIT2",
+	"ALBUM":       "TALB",
+	"ARTIST":      "TPE1",
+	"ALBUMARTIST": "TPE2",
+	"GENRE":       "TCON",
+	"GROUPING":    "TIT1",
+	"MEDIA":       "TMED",
+	"TRACKNUMBER": "TRCK",
+	"DISCNUMBER":  "TPOS",
+	"PUBLISHER":   "TPUB",
+	"COMMENT":     "COMM",
+}
+
+func writeID3Tags(path string, tags map[string]string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	version := byte(3)
+	var frames []id3Frame
+	audioOffset := int64(0)
+
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(f, header); err == nil && string(header[:3]) == "ID3" {
+		version = header[3]
+		flags := header[5]
+		size := int64(syncsafe(header[6:10]))
+		audioOffset = 10 + size
+		if flags&0x10 != 0 {
+			audioOffset += 10 // footer
+		}
+
+		if version != 3 && version != 4 {
+			return fmt.Errorf("unsupported ID3v2.%d tag", version)
+		}
+		if flags&0x80 != 0 {
+			return fmt.Errorf("unsynchronised ID3 tags are not supported")
+		}
+
+		body := make([]byte, size)
+		if _, err := io.ReadFull(f, body); err != nil {
+			return fmt.Errorf("reading ID3 tag: %v", err)
+		}
+		if flags&0x40 != 0 {
+			body = skipID3ExtendedHeader(body, version)
+		}
+		frames = parseID3Frames(body, version)
+	}
+
+	// Drop the frames we are replacing
+	replaced := make(map[string]bool)
+	for key := range tags {
+		replaced[id3FrameKey(key)] = true
+	}
+	var merged []id3Frame
+	for _, fr := range frames {
+		if !replaced[fr.key()] {
+			merged = append(merged, fr)
+		}
+	}
+	for _, key := range sortedKeys(tags) {
+		merged = append(merged, newID3Frame(key, tags[key], version))
+	}
+
+	var body bytes.Buffer
+	for _, fr := range merged {
+		body.WriteString(fr.ID)
+		size := make([]byte, 4)
+		if version == 4 {
+			putSyncsafe(size, len(fr.Data))
+		} else {
+			binary.BigEndian.PutUint32(size, uint32(len(fr.Data)))
+		}
+		body.Write(size)
+		body.Write(fr.Flags[:])
+		body.Write(fr.Data)
+	}
+	body.Write(make([]byte, 1024)) // padding
+
+	if _, err := f.Seek(audioOffset, io.SeekStart); err != nil {
+		return err
+	}
+
+	return replaceFile(path, func(w io.Writer) error {
+		newHeader := []byte{'I', 'D', '3', version, 0, 0, 0, 0, 0, 0}
+		putSyncsafe(newHeader[6:10], body.Len())
+		if _, err := w.Write(newHeader); err != nil {
+			return err
+		}
+		if _, err := w.Write(body.Bytes()); err != nil {
+			return err
+		}
+		_, err := io.Copy(w, f)
+		return err
+	})
+}
+
+func parseID3Frames(body []byte, version byte) []id3Frame {
+	var frames []id3Frame
+	for len(body) >= 10 && body[0] != 0 {
+		var size int
+		if version == 4 {
+			size = syncsafe(body[4:8])
+		} else {
+			size = int(binary.BigEndian.Uint32(body[4:8]))
+		}
+		if size < 0 || 10+size > len(body) {
+			break
+		}
+
+		fr := id3Frame{ID: string(body[:4]), Data: body[10 : 10+size]}
+		copy(fr.Flags[:], body[8:10])
+		frames = append(frames, fr)
+		body = body[10+size:]
+	}
+	return frames
+}
+
+func skipID3ExtendedHeader(body []byte, version byte) []byte {
+	if len(body) < 4 {
+		return nil
+	}
+	size := int(binary.BigEndian.Uint32(body[:4])) + 4 // v2.3 excludes the size field
+	if version == 4 {
+		size = syncsafe(body[:4])
+	}
+	if size > len(body) {
+		return nil
+	}
+	return body[size:]
+}
+
+func id3FrameKey(tag string) string {
+	if id, ok := id3FrameIDs[strings.ToUpper(tag)]; ok {
+		return id
+	}
+	return "TXXX:" + strings.ToUpper(tag)
+}
+
+func (fr id3Frame) key() string {
+	if fr.ID != "TXXX" || len(fr.Data) < 1 {
+		return fr.ID
+	}
+	desc, _ := splitID3Text(fr.Data[0], fr.Data[1:])
+	return "TXXX:" + strings.ToUpper(desc)
+}
+
+func newID3Frame(tag, value string, version byte) id3Frame {
+	id := id3FrameKey(tag)
+
+	// v2.4 supports UTF-8, v2.3 needs UTF-16 for anything non-latin
+	encoding := byte(1)
+	if version == 4 {
+		encoding = 3
+	}
+
+	var data bytes.Buffer
+	data.WriteByte(encoding)
+	switch {
+	case id == "COMM":
+		data.WriteString("eng")
+		data.Write(encodeID3Text("", encoding, true))
+		data.Write(encodeID3Text(value, encoding, false))
+	case strings.HasPrefix(id, "TXXX:"):
+		id = "TXXX"
+		data.Write(encodeID3Text(strings.ToUpper(tag), encoding, true))
+		data.Write(encodeID3Text(value, encoding, false))
+	default:
+		data.Write(encodeID3Text(value, encoding, false))
+	}
+
+	return id3Frame{ID: id, Data: data.Bytes()}
+}
+
+func encodeID3Text(s string, encoding byte, terminate bool) []byte {
+	var buf bytes.Buffer
+	if encoding == 1 {
+		buf.Write([]byte{0xff, 0xfe})
+		for _, u := range utf16.Encode([]rune(s)) {
+			buf.WriteByte(byte(u))
+			buf.WriteByte(byte(u >> 8))
+		}
+		if terminate {
+			buf.Write([]byte{0, 0})
+		}
+		return buf.Bytes()
+	}
+
+	buf.WriteString(s)
+	if terminate {
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+// splitID3Text decodes the first null-terminated string in data and returns
+// it together with the remaining bytes.
+func splitID3Text(encoding byte, data []byte) (string, []byte) {
+	if encoding == 1 || encoding == 2 {
+		for i := 0; i+1 < len(data); i += 2 {
+			if data[i] == 0 && data[i+1] == 0 {
+				return decodeUTF16(data[:i], encoding == 2), data[i+2:]
+			}
+		}
+		return decodeUTF16(data, encoding == 2), nil
+	}
+
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return decodeID3String(data[:i], encoding), data[i+1:]
+	}
+	return decodeID3String(data, encoding), nil
+}
+
+func decodeUTF16(b []byte, bigEndian bool) string {
+	if len(b) >= 2 && b[0] == 0xfe && b[1] == 0xff {
+		bigEndian = true
+		b = b[2:]
+	} else if len(b) >= 2 && b[0] == 0xff && b[1] == 0xfe {
+		bigEndian = false
+		b = b[2:]
+	}
+
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		if bigEndian {
+			units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+		} else {
+			units = append(units, uint16(b[i+1])<<8|uint16(b[i]))
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+func decodeID3String(b []byte, encoding byte) string {
+	if encoding == 3 {
+		return string(b)
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+func putSyncsafe(b []byte, n int) {
+	b[0] = byte(n>>21) & 0x7f
+	b[1] = byte(n>>14) & 0x7f
+	b[2] = byte(n>>7) & 0x7f
+	b[3] = byte(n) & 0x7f
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const telegramAPI = "https://api.telegram.org/bot"
+
+// telegramBot queues the album URLs sent to it and reports back on them.
+// Only chats listed with --telegram-chat are served.
+type telegramBot struct {
+	token   string
+	allowed map[int64]bool
+	server  *server
+	client  *http.Client
+}
+
+type telegramUpdate struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Text string `json:"text"`
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+	} `json:"message"`
+}
+
+func newTelegramBot(token string, chats []int64, s *server) *telegramBot {
+	b := &telegramBot{
+		token:   token,
+		allowed: make(map[int64]bool),
+		server:  s,
+		client:  &http.Client{Transport: httpTransport(), Timeout: 90 * time.Second},
+	}
+	for _, id := range chats {
+		b.allowed[id] = true
+	}
+	return b
+}
+
+func (b *telegramBot) call(method string, params url.Values, result any) error {
+	resp, err := b.client.PostForm(telegramAPI+b.token+"/"+method, params)
+	if err != nil {
+		// Don't log the token that's part of the URL
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return err
+	}
+	if !body.OK {
+		return fmt.Errorf("telegram %s: %s", method, body.Description)
+	}
+	if result != nil {
+		return json.Unmarshal(body.Result, result)
+	}
+	return nil
+}
+
+func (b *telegramBot) send(chatID int64, text string) {
+	params := url.Values{"chat_id": {strconv.FormatInt(chatID, 10)}, "text": {text}}
+	if err := b.call("sendMessage", params, nil); err != nil {
+		logf("Error sending Telegram message: %v\n", err)
+	}
+}
+
+// run long-polls for messages until the process exits.
+func (b *telegramBot) run() {
+	var offset int64
+	for {
+		var updates []telegramUpdate
+		params := url.Values{"timeout": {"60"}, "offset": {strconv.FormatInt(offset, 10)}}
+		if err := b.call("getUpdates", params, &updates); err != nil {
+			logf("Error polling Telegram: %v\n", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			if u.Message != nil {
+				b.handle(u.Message.Chat.ID, u.Message.Text)
+			}
+		}
+	}
+}
+
+func (b *telegramBot) handle(chatID int64, text string) {
+	if !b.allowed[chatID] {
+		logf("Ignoring Telegram message from chat %d, allow it with --telegram-chat %d\n", chatID, chatID)
+		return
+	}
+
+	switch strings.Fields(text + " ")[0] {
+	case "/start", "/help":
+		b.send(chatID, "Send me album URLs to download them. /status lists the queue.")
+		return
+	case "/status":
+		b.send(chatID, b.server.statusText())
+		return
+	}
+
+	queued := 0
+	for _, word := range strings.Fields(text) {
+		if strings.HasPrefix(word, "http") || strings.HasPrefix(word, "khinsider:") {
+			item := b.server.add(word, priorityNormal, chatID, nil)
+			b.send(chatID, fmt.Sprintf("Queued #%d: %s", item.ID, word))
+			queued++
+		}
+	}
+	if queued == 0 {
+		b.send(chatID, "No album URL found in that message.")
+	}
+}
+
+// itemStarted and itemFinished report on albums queued from Telegram.
+func (b *telegramBot) itemStarted(item *queueItem) {
+	if item.chatID != 0 {
+		b.send(item.chatID, fmt.Sprintf("Downloading #%d: %s", item.ID, item.URL))
+	}
+}
+
+func (b *telegramBot) itemFinished(item *queueItem) {
+	if item.chatID == 0 {
+		return
+	}
+	switch item.Status {
+	case "completed":
+		text := fmt.Sprintf("Done #%d: %s\n%d tracks downloaded", item.ID, item.Album, item.Successful)
+		if item.Failed > 0 {
+			text += fmt.Sprintf(", %d failed", item.Failed)
+		}
+		b.send(item.chatID, text)
+	case "paused":
+		b.send(item.chatID, fmt.Sprintf("Paused #%d", item.ID))
+	default:
+		b.send(item.chatID, fmt.Sprintf("#%d %s: %s", item.ID, item.Status, item.Error))
+	}
+}
+
+func parseChatIDs(s string) ([]int64, error) {
+	var ids []int64
+	for _, part := range strings.Split(s, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chat id: %s", part)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
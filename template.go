@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// expandTemplate fills in a naming template, as used by --library-layout,
+// --track-name and the tag mapping. {field} is replaced by the field's
+// value, which can be piped through functions: {title|upper},
+// {title|replace:':',' -'} or {album|truncate:60}. A segment in <...> is
+// left out when a field in it is empty, e.g. "<Disc {disc} - >", so missing
+// values don't leave separators behind; < and > can't be part of file names
+// anyway. Unknown fields are kept as they are.
+func expandTemplate(tmpl string, fields map[string]string) (string, error) {
+	out, _, err := expandSegment(tmpl, fields)
+	return out, err
+}
+
+// albumFields are the album's values for templates.
+func albumFields(album *Album) map[string]string {
+	platform := ""
+	if len(album.Platforms) > 0 {
+		platform = album.Platforms[0]
+	}
+	return map[string]string{
+		"album":     album.Name,
+		"year":      album.Year,
+		"platform":  platform,
+		"platforms": strings.Join(album.Platforms, "; "),
+		"type":      album.AlbumType,
+		"catalog":   album.CatalogNumber,
+		"publisher": album.Publisher,
+	}
+}
+
+// trackFields adds a track's values for --track-name to the album's:
+// {track}, {disc}, {title}, {format} and {original}, the name of the file
+// on the site without its extension. {track} is empty for a song whose
+// album couldn't be read.
+func trackFields(album *Album, song *Song, track int, original string) map[string]string {
+	fields := albumFields(album)
+	fields["track"] = ""
+	if track > 0 {
+		fields["track"] = strconv.Itoa(track)
+	}
+	fields["disc"] = song.Disc
+	fields["title"] = song.Name
+	fields["format"] = strings.ToUpper(strings.TrimPrefix(filepath.Ext(original), "."))
+	fields["original"] = strings.TrimSuffix(original, filepath.Ext(original))
+	return fields
+}
+
+// checkTemplate reports syntax errors and unknown functions in a template.
+func checkTemplate(tmpl string) error {
+	_, err := expandTemplate(tmpl, map[string]string{})
+	return err
+}
+
+// expandSegment expands tmpl and reports whether every field in it had a
+// value.
+func expandSegment(tmpl string, fields map[string]string) (string, bool, error) {
+	var b strings.Builder
+	complete := true
+	for i := 0; i < len(tmpl); {
+		switch tmpl[i] {
+		case '<':
+			end := matchingBracket(tmpl, i, '<', '>')
+			if end < 0 {
+				return "", false, fmt.Errorf("unclosed < in %q", tmpl)
+			}
+			inner, ok, err := expandSegment(tmpl[i+1:end], fields)
+			if err != nil {
+				return "", false, err
+			}
+			if ok {
+				b.WriteString(inner)
+			}
+			i = end + 1
+		case '{':
+			end := matchingBracket(tmpl, i, '{', '}')
+			if end < 0 {
+				return "", false, fmt.Errorf("unclosed { in %q", tmpl)
+			}
+			value, known, err := expandField(tmpl[i+1:end], fields)
+			if err != nil {
+				return "", false, err
+			}
+			if !known {
+				value = tmpl[i : end+1]
+			} else if value == "" {
+				complete = false
+			}
+			b.WriteString(value)
+			i = end + 1
+		default:
+			b.WriteByte(tmpl[i])
+			i++
+		}
+	}
+	return b.String(), complete, nil
+}
+
+// matchingBracket returns the index of the bracket closing the one at
+// start, or -1. Quotes only matter in function arguments, and a field in a
+// segment is skipped as a whole.
+func matchingBracket(s string, start int, open, close byte) int {
+	depth := 0
+	quoted := false
+	for i := start; i < len(s); i++ {
+		switch c := s[i]; {
+		case open == '{' && c == '\'':
+			quoted = !quoted
+		case quoted:
+		case open == '<' && c == '{':
+			end := matchingBracket(s, i, '{', '}')
+			if end < 0 {
+				return -1
+			}
+			i = end
+		case c == open:
+			depth++
+		case c == close:
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// expandField evaluates "name|func:arg,...|..." and reports whether the
+// field is known.
+func expandField(expr string, fields map[string]string) (string, bool, error) {
+	parts := splitUnquoted(expr, '|')
+	name := strings.TrimSpace(parts[0])
+	value, known := fields[name]
+	for _, call := range parts[1:] {
+		fn, rawArgs, _ := strings.Cut(call, ":")
+		var args []string
+		if rawArgs != "" {
+			for _, arg := range splitUnquoted(rawArgs, ',') {
+				args = append(args, unquote(arg))
+			}
+		}
+		var err error
+		if value, err = applyTemplateFunc(strings.TrimSpace(fn), value, args); err != nil {
+			return "", false, fmt.Errorf("{%s}: %v", expr, err)
+		}
+	}
+	return value, known, nil
+}
+
+// templateFuncs lists the functions for the error message.
+const templateFuncs = "upper, lower, title, trim, replace:'from','to', truncate:n, pad:n, default:'text'"
+
+func applyTemplateFunc(fn, value string, args []string) (string, error) {
+	want := func(n int) error {
+		if len(args) != n {
+			return fmt.Errorf("%s takes %d argument(s)", fn, n)
+		}
+		return nil
+	}
+	switch fn {
+	case "upper":
+		return strings.ToUpper(value), want(0)
+	case "lower":
+		return strings.ToLower(value), want(0)
+	case "title":
+		return titleCase(value), want(0)
+	case "trim":
+		return strings.TrimSpace(value), want(0)
+	case "replace":
+		if err := want(2); err != nil {
+			return "", err
+		}
+		return strings.ReplaceAll(value, args[0], args[1]), nil
+	case "default":
+		if err := want(1); err != nil {
+			return "", err
+		}
+		if value == "" {
+			value = args[0]
+		}
+		return value, nil
+	case "truncate", "pad":
+		if err := want(1); err != nil {
+			return "", err
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return "", fmt.Errorf("%s needs a positive number, got %q", fn, args[0])
+		}
+		if fn == "pad" {
+			if value == "" {
+				return value, nil
+			}
+			return strings.Repeat("0", max(n-utf8.RuneCountInString(value), 0)) + value, nil
+		}
+		if runes := []rune(value); len(runes) > n {
+			value = strings.TrimRight(string(runes[:n]), " ")
+		}
+		return value, nil
+	}
+	return "", fmt.Errorf("unknown function %q, expected one of %s", fn, templateFuncs)
+}
+
+// splitUnquoted splits s at sep outside of single quotes.
+func splitUnquoted(s string, sep byte) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\'':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// titleCase capitalizes the first letter of every word.
+func titleCase(s string) string {
+	runes := []rune(s)
+	for i, r := range runes {
+		if i == 0 || unicode.IsSpace(runes[i-1]) {
+			runes[i] = unicode.ToUpper(r)
+		}
+	}
+	return string(runes)
+}
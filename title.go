@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// titleEnabled shows the progress in the terminal window title, turned off
+// with --no-title.
+var titleEnabled = true
+
+var (
+	titleMu     sync.Mutex
+	titlePushed bool
+)
+
+// titleTerminal returns the terminal to send title escapes to, if any.
+func titleTerminal() *os.File {
+	if !titleEnabled || os.Getenv("TERM") == "dumb" {
+		return nil
+	}
+	for _, f := range []*os.File{os.Stderr, os.Stdout} {
+		if isTerminal(f) {
+			return f
+		}
+	}
+	return nil
+}
+
+// setTitle sets the window title, saving the previous one the first time.
+func setTitle(format string, a ...any) {
+	f := titleTerminal()
+	if f == nil {
+		return
+	}
+	titleMu.Lock()
+	defer titleMu.Unlock()
+
+	if !titlePushed {
+		fmt.Fprint(f, "\033[22;0t") // push the current title
+		titlePushed = true
+	}
+	fmt.Fprintf(f, "\033]0;%s\007", fmt.Sprintf(format, a...))
+}
+
+// restoreTitle puts back the title from before the first setTitle.
+func restoreTitle() {
+	f := titleTerminal()
+	if f == nil {
+		return
+	}
+	titleMu.Lock()
+	defer titleMu.Unlock()
+
+	if titlePushed {
+		fmt.Fprint(f, "\033[23;0t")
+		titlePushed = false
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// defaultTorProxy is the SOCKS port of a local Tor daemon.
+const defaultTorProxy = "127.0.0.1:9050"
+
+// torMode is set by --tor.
+var torMode bool
+
+// enableTor sends all requests through Tor's SOCKS proxy, with host names
+// resolved by Tor, and gives requests the extra time Tor circuits need.
+func enableTor(proxyAddr string) {
+	torMode = true
+	httpTuning.proxy = &url.URL{Scheme: "socks5h", Host: proxyAddr}
+	httpTuning.connectTimeout = max(httpTuning.connectTimeout, 90*time.Second)
+	httpTuning.pageTimeout = max(httpTuning.pageTimeout, 2*time.Minute)
+	httpTuning.stallTimeout = max(httpTuning.stallTimeout, 5*time.Minute)
+}
+
+// checkTorConflicts refuses the features that would give away what is
+// downloaded, or from where, outside of Tor.
+func checkTorConflicts(opts *Options) error {
+	if !torMode {
+		return nil
+	}
+	for flag, used := range map[string]bool{
+		"--clipboard":     opts.Clipboard,
+		"--email-report":  opts.EmailReport,
+		"--alert-webhook": alert.webhook != "",
+	} {
+		if used {
+			return fmt.Errorf("%s can't be used with --tor", flag)
+		}
+	}
+
+	notifiersMu.Lock()
+	defer notifiersMu.Unlock()
+	for _, n := range notifiers {
+		switch n.(type) {
+		case webhookNotifier, discordNotifier:
+			return fmt.Errorf("webhook and Discord notifications can't be used with --tor")
+		case desktopNotifier:
+			return fmt.Errorf("desktop notifications can't be used with --tor")
+		}
+	}
+	return nil
+}
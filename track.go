@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The steps of downloading a track that album downloads and single songs
+// share, so a song URL gives the same file as its album would.
+
+// trackFilename is the name a track is saved as: the file name on the site,
+// or --track-name filled in. track is its number in the album, 0 when the
+// album is unknown.
+func trackFilename(album *Album, song *Song, track int, downloadURL string, opts *Options) (string, error) {
+	parsedURL, err := url.Parse(downloadURL)
+	if err != nil {
+		return "", newClassError(errParse, err.Error())
+	}
+	name := sanitizeFilename(filepath.Base(parsedURL.Path))
+	if name == "" || name == "/" || name == "." {
+		// Fallback to generated name if we can't get original
+		ext := filepath.Ext(downloadURL)
+		if ext == "" {
+			ext = "." + opts.Format
+		}
+		name = fmt.Sprintf("%03d - %s%s", track, sanitizeFilename(song.Name), ext)
+	}
+
+	// Named by --track-name instead of as on the site
+	if opts.TrackName != "" {
+		if album == nil {
+			album = &Album{}
+		}
+		expanded, _ := expandTemplate(opts.TrackName, trackFields(album, song, track, name))
+		if expanded = strings.TrimSpace(expanded); expanded != "" {
+			name = sanitizeFilename(expanded + filepath.Ext(name))
+		}
+	}
+	return name, nil
+}
+
+// fetchTrack downloads a track, reporting its progress as number index of
+// total. albumName is empty for songs without their album.
+func fetchTrack(downloadURL, filePath, albumName, trackName string, index, total int, opts *Options) error {
+	metrics.started.Add(1)
+	return downloadFile(downloadURL, filePath, 3, func(read, size int64) {
+		ev := progressEvent{Event: "track_progress", Album: albumName, Track: trackName, Index: index, Total: total, Bytes: read, Size: size}
+		if size > 0 {
+			ev.Percent = float64(read) * 100 / float64(size)
+			if albumName != "" {
+				setTitle("[%d/%d] %.0f%% – %s", index, total, ev.Percent, albumName)
+			}
+		}
+		emitProgress(ev)
+	}, opts.Pause)
+}
+
+// finishTrack post-processes a downloaded track: an archive is replaced by
+// the audio files in it, then the files are tagged and get their provenance
+// and file times. It returns the files the track ended up as. album is nil
+// for songs without their album.
+func finishTrack(album *Album, song *Song, track int, filePath, downloadURL string, names *trackNames, opts *Options) []string {
+	downloadDir := filepath.Dir(filePath)
+	downloadedFiles := []string{filePath}
+	if kind := archiveKind(filePath); kind != "" {
+		// The files in it get names like any other track's, only the
+		// archive's own name is free for one of them
+		own := filepath.Base(filePath)
+		extracted, err := extractTrackArchive(filePath, kind, func(name string) string {
+			if strings.EqualFold(name, own) {
+				own = ""
+				return name
+			}
+			name, _ = names.claim(name, track)
+			return name
+		})
+		if err != nil {
+			logf("  Error extracting %s archive: %v\n", kind, err)
+		} else if len(extracted) == 0 {
+			logf("  No audio files found in %s archive\n", kind)
+		} else {
+			logf("  Extracted %d file(s) from %s archive\n", len(extracted), kind)
+			if err := recordExtracted(downloadDir, filepath.Base(filePath), extracted); err != nil {
+				logf("  Error recording the extracted files: %v\n", err)
+			}
+			song.Filename = extracted[0]
+			downloadedFiles = downloadedFiles[:0]
+			for _, name := range extracted {
+				downloadedFiles = append(downloadedFiles, filepath.Join(downloadDir, name))
+			}
+		}
+	}
+
+	prov := newProvenance(album, song, downloadURL)
+	for _, path := range downloadedFiles {
+		if err := writeTags(path, trackTags(album, prov, opts)); err != nil {
+			logf("  Error writing tags: %v\n", err)
+		}
+		if err := writeProvenanceSidecar(path, prov, opts); err != nil {
+			logf("  Error writing the provenance sidecar: %v\n", err)
+		}
+	}
+
+	if opts.MtimeFromYear && album != nil {
+		if releaseTime, ok := albumReleaseTime(album); ok {
+			for _, path := range downloadedFiles {
+				os.Chtimes(path, releaseTime, releaseTime)
+			}
+		}
+	}
+	return downloadedFiles
+}
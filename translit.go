@@ -0,0 +1,137 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+var latinASCII = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'Æ': "AE", 'œ': "oe", 'Œ': "OE", 'ø': "o", 'Ø': "O",
+	'ł': "l", 'Ł': "L", 'đ': "d", 'Đ': "D", 'ð': "d", 'Ð': "D", 'þ': "th", 'Þ': "Th",
+	'‘': "'", '’': "'", '′': "'", '“': `"`, '”': `"`, '″': `"`,
+	'–': "-", '—': "-", '―': "-", '…': "...", '〜': "~", '～': "~",
+	'・': " ", '　': " ", '、': ",", '。': ".",
+	'「': "[", '」': "]", '『': "[", '』': "]", '【': "[", '】': "]",
+}
+
+// Base letters of accented Latin characters, grouped by letter
+var latinAccents = map[string]string{
+	"A": "ÀÁÂÃÄÅĀĂĄ", "a": "àáâãäåāăą",
+	"C": "ÇĆĈĊČ", "c": "çćĉċč",
+	"D": "Ď", "d": "ď",
+	"E": "ÈÉÊËĒĔĖĘĚ", "e": "èéêëēĕėęě",
+	"G": "ĜĞĠĢ", "g": "ĝğġģ",
+	"H": "ĤĦ", "h": "ĥħ",
+	"I": "ÌÍÎÏĨĪĬĮİ", "i": "ìíîïĩīĭįı",
+	"J": "Ĵ", "j": "ĵ",
+	"K": "Ķ", "k": "ķ",
+	"L": "ĹĻĽĿ", "l": "ĺļľŀ",
+	"N": "ÑŃŅŇ", "n": "ñńņň",
+	"O": "ÒÓÔÕÖŌŎŐ", "o": "òóôõöōŏő",
+	"R": "ŔŖŘ", "r": "ŕŗř",
+	"S": "ŚŜŞŠ", "s": "śŝşš",
+	"T": "ŢŤŦ", "t": "ţťŧ",
+	"U": "ÙÚÛÜŨŪŬŮŰŲ", "u": "ùúûüũūŭůűų",
+	"W": "Ŵ", "w": "ŵ",
+	"Y": "ÝŶŸ", "y": "ýÿŷ",
+	"Z": "ŹŻŽ", "z": "źżž",
+}
+
+// Hiragana syllables, katakana is looked up through its hiragana twin
+var kanaRomaji = map[rune]string{
+	'あ': "a", 'い': "i", 'う': "u", 'え': "e", 'お': "o",
+	'か': "ka", 'き': "ki", 'く': "ku", 'け': "ke", 'こ': "ko",
+	'が': "ga", 'ぎ': "gi", 'ぐ': "gu", 'げ': "ge", 'ご': "go",
+	'さ': "sa", 'し': "shi", 'す': "su", 'せ': "se", 'そ': "so",
+	'ざ': "za", 'じ': "ji", 'ず': "zu", 'ぜ': "ze", 'ぞ': "zo",
+	'た': "ta", 'ち': "chi", 'つ': "tsu", 'て': "te", 'と': "to",
+	'だ': "da", 'ぢ': "ji", 'づ': "zu", 'で': "de", 'ど': "do",
+	'な': "na", 'に': "ni", 'ぬ': "nu", 'ね': "ne", 'の': "no",
+	'は': "ha", 'ひ': "hi", 'ふ': "fu", 'へ': "he", 'ほ': "ho",
+	'ば': "ba", 'び': "bi", 'ぶ': "bu", 'べ': "be", 'ぼ': "bo",
+	'ぱ': "pa", 'ぴ': "pi", 'ぷ': "pu", 'ぺ': "pe", 'ぽ': "po",
+	'ま': "ma", 'み': "mi", 'む': "mu", 'め': "me", 'も': "mo",
+	'や': "ya", 'ゆ': "yu", 'よ': "yo",
+	'ら': "ra", 'り': "ri", 'る': "ru", 'れ': "re", 'ろ': "ro",
+	'わ': "wa", 'ゐ': "i", 'ゑ': "e", 'を': "o", 'ん': "n", 'ゔ': "vu",
+	'ゎ': "wa",
+}
+
+var smallYa = map[rune]string{'ゃ': "a", 'ゅ': "u", 'ょ': "o"}
+
+var smallVowels = map[rune]string{'ぁ': "a", 'ぃ': "i", 'ぅ': "u", 'ぇ': "e", 'ぉ': "o"}
+
+func init() {
+	for base, accented := range latinAccents {
+		for _, r := range accented {
+			latinASCII[r] = base
+		}
+	}
+}
+
+// toASCII transliterates accented letters, kana and full-width characters.
+// Anything without an ASCII approximation, like kanji, is dropped.
+func toASCII(s string) string {
+	var b strings.Builder
+	doubleNext := false // after a small tsu
+	for _, r := range s {
+		if r >= 'ァ' && r <= 'ヶ' {
+			r -= 'ァ' - 'ぁ' // katakana to hiragana
+		}
+
+		switch {
+		case r < unicode.MaxASCII:
+			b.WriteRune(r)
+		case r >= '！' && r <= '～':
+			b.WriteRune(r - 0xFEE0) // full-width ASCII
+		case r == 'っ':
+			doubleNext = true
+			continue
+		case r == 'ー':
+			// Long vowel mark repeats the previous vowel
+			if out := b.String(); out != "" && isVowel(out[len(out)-1]) {
+				b.WriteByte(out[len(out)-1])
+			}
+		case smallYa[r] != "":
+			// きゃ -> kya, しゃ -> sha
+			out := b.String()
+			if strings.HasSuffix(out, "i") {
+				out = strings.TrimSuffix(out, "i")
+				if !strings.HasSuffix(out, "sh") && !strings.HasSuffix(out, "ch") && !strings.HasSuffix(out, "j") {
+					out += "y"
+				}
+				b.Reset()
+				b.WriteString(out)
+				b.WriteString(smallYa[r])
+			} else {
+				b.WriteString("y" + smallYa[r])
+			}
+		case smallVowels[r] != "":
+			// ファ -> fa, ティ -> ti
+			out := b.String()
+			if n := len(out); n >= 2 && isVowel(out[n-1]) && !isVowel(out[n-2]) {
+				out = out[:n-1]
+			}
+			b.Reset()
+			b.WriteString(out + smallVowels[r])
+		case kanaRomaji[r] != "":
+			romaji := kanaRomaji[r]
+			if doubleNext && !isVowel(romaji[0]) {
+				if strings.HasPrefix(romaji, "ch") {
+					b.WriteByte('t')
+				} else {
+					b.WriteByte(romaji[0])
+				}
+			}
+			b.WriteString(romaji)
+		case latinASCII[r] != "":
+			b.WriteString(latinASCII[r])
+		}
+		doubleNext = false
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func isVowel(c byte) bool {
+	return strings.IndexByte("aeiou", c) >= 0
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// Connection settings for all requests, set by the --http-* and
+// --connect-timeout options before the first request.
+var httpTuning = struct {
+	idleConns      int           // idle connections kept per host
+	idleTimeout    time.Duration // how long an idle connection is kept
+	connectTimeout time.Duration // dialing and the TLS handshake
+	noHTTP2        bool
+	noKeepAlive    bool
+	network        string // "tcp4" or "tcp6" to use only one IP version
+	dnsServer      string // host:port of the DNS server to ask instead of the system's
+	rootCAs        *x509.CertPool
+	insecureTLS    bool
+	proxy          *url.URL // instead of the environment's proxy
+	pageTimeout    time.Duration
+	stallTimeout   time.Duration // longest wait for data from a download
+}{
+	idleConns:      16,
+	idleTimeout:    90 * time.Second,
+	connectTimeout: 30 * time.Second,
+	pageTimeout:    30 * time.Second,
+	stallTimeout:   60 * time.Second,
+}
+
+var (
+	transportOnce   sync.Once
+	sharedTransport *http.Transport
+
+	clientsOnce sync.Once
+	pageClient  *http.Client // pages and small requests
+	fileClient  *http.Client // track downloads
+)
+
+// httpTransport returns the transport shared by all requests, so the
+// connections (and TLS sessions) to the site are reused between the pages
+// and tracks of an album instead of opening new ones every time.
+func httpTransport() *http.Transport {
+	transportOnce.Do(func() {
+		dialer := &net.Dialer{Timeout: httpTuning.connectTimeout, KeepAlive: 30 * time.Second}
+		if httpTuning.dnsServer != "" {
+			dialer.Resolver = customResolver(httpTuning.dnsServer)
+		}
+		proxy := http.ProxyFromEnvironment
+		if httpTuning.proxy != nil {
+			proxy = http.ProxyURL(httpTuning.proxy)
+		}
+		sharedTransport = &http.Transport{
+			Proxy: proxy,
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				if httpTuning.network != "" && network == "tcp" {
+					network = httpTuning.network
+				}
+				return dialer.DialContext(ctx, network, addr)
+			},
+			ForceAttemptHTTP2:     !httpTuning.noHTTP2,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   httpTuning.idleConns,
+			IdleConnTimeout:       httpTuning.idleTimeout,
+			TLSHandshakeTimeout:   httpTuning.connectTimeout,
+			ExpectContinueTimeout: time.Second,
+			DisableKeepAlives:     httpTuning.noKeepAlive,
+		}
+		if httpTuning.rootCAs != nil || httpTuning.insecureTLS {
+			sharedTransport.TLSClientConfig = &tls.Config{
+				RootCAs:            httpTuning.rootCAs,
+				InsecureSkipVerify: httpTuning.insecureTLS,
+			}
+		}
+		if httpTuning.noHTTP2 {
+			// A non-nil empty map turns HTTP/2 off
+			sharedTransport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		}
+	})
+	return sharedTransport
+}
+
+// addCACert trusts the certificates in a PEM file on top of the system's,
+// e.g. for a company proxy that inspects HTTPS.
+func addCACert(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if httpTuning.rootCAs == nil {
+		if httpTuning.rootCAs, err = x509.SystemCertPool(); err != nil {
+			httpTuning.rootCAs = x509.NewCertPool()
+		}
+	}
+	if !httpTuning.rootCAs.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no PEM certificates in %s", path)
+	}
+	return nil
+}
+
+// customResolver looks names up with the given DNS server instead of the
+// system's, for ISPs whose DNS sends the site's CDN somewhere slow.
+func customResolver(server string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{Timeout: 5 * time.Second}
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// siteClient returns the client for pages, with the login cookies.
+func siteClient() *http.Client {
+	initClients()
+	return pageClient
+}
+
+// downloadClient returns the client for track downloads. It has no overall
+// timeout, the downloader gives up on a stalled transfer instead.
+func downloadClient() *http.Client {
+	initClients()
+	return fileClient
+}
+
+func initClients() {
+	clientsOnce.Do(func() {
+		pageClient = &http.Client{Transport: httpTransport(), Timeout: httpTuning.pageTimeout, Jar: cookieJar()}
+		fileClient = &http.Client{Transport: httpTransport(), Jar: cookieJar()}
+	})
+}
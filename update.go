@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// readAlbumMetadata reads the album.json of an earlier download, nil if
+// there is none.
+func readAlbumMetadata(dir string) *albumMetadata {
+	data, err := os.ReadFile(filepath.Join(dir, metadataFilename))
+	if err != nil {
+		return nil
+	}
+	var meta albumMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		logf("Ignoring %s: %v\n", filepath.Join(dir, metadataFilename), err)
+		return nil
+	}
+	return &meta
+}
+
+type trackChange int
+
+const (
+	trackUnchanged trackChange = iota
+	trackNew                   // not in the earlier download
+	trackReplaced              // its size in the track list changed, a new rip
+)
+
+// compareTrack tells how a track differs from the earlier download, with
+// the old and new size for replaced tracks.
+func (m *albumMetadata) compareTrack(song *Song, filename string) (trackChange, string) {
+	for _, prev := range m.Tracks {
+		if prev.URL != song.SongLink {
+			continue
+		}
+		format := strings.ToUpper(strings.TrimPrefix(filepath.Ext(filename), "."))
+		now := song.Sizes[format]
+		if prev.SizeKB > 0 && now > 0 && now != prev.SizeKB {
+			return trackReplaced, fmt.Sprintf("%s instead of %s", formatBytes(int64(now)*1024), formatBytes(int64(prev.SizeKB)*1024))
+		}
+		return trackUnchanged, ""
+	}
+	return trackNew, ""
+}
+
+// listedSizeKB is the track list's size of the format a track was saved in.
+func listedSizeKB(song *Song) int {
+	if song.Filename == "" {
+		return 0
+	}
+	return song.Sizes[strings.ToUpper(strings.TrimPrefix(filepath.Ext(song.Filename), "."))]
+}
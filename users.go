@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+)
+
+// serverUser is a member of the household sharing the server, configured in
+// the "users" list of the config file.
+type serverUser struct {
+	Name    string  `json:"name"`
+	Token   string  `json:"token"`
+	Dir     string  `json:"dir"`      // download folder below the output directory, the name by default
+	QuotaGB float64 `json:"quota_gb"` // stop queueing once the folder is this big, 0 for no limit
+}
+
+// downloadDir returns the user's folder below the server's output directory.
+func (u *serverUser) downloadDir(outputDir string) string {
+	dir := u.Dir
+	if dir == "" {
+		dir = sanitizeFilename(u.Name)
+	}
+	if filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(outputDir, dir)
+}
+
+// overQuota reports whether the user's folder has reached the quota.
+func (u *serverUser) overQuota(outputDir string) (bool, error) {
+	if u.QuotaGB <= 0 {
+		return false, nil
+	}
+	used, err := dirSize(u.downloadDir(outputDir))
+	if err != nil {
+		return false, err
+	}
+	return float64(used) >= u.QuotaGB*(1<<30), nil
+}
+
+func (u *serverUser) quotaError() error {
+	return fmt.Errorf("%s has used up the quota of %g GB", u.Name, u.QuotaGB)
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir && errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// setUsers replaces the users, on start and when the config is reloaded.
+func (s *server) setUsers(users []serverUser) error {
+	for _, user := range users {
+		if user.Name == "" || user.Token == "" {
+			return fmt.Errorf("every server user needs a name and a token")
+		}
+	}
+	s.mu.Lock()
+	s.users = users
+	s.mu.Unlock()
+	return nil
+}
+
+// applyUser points the download at the folder of the user who queued it,
+// failing once the user's quota is used up.
+func (s *server) applyUser(item *queueItem, opts *Options) error {
+	user := s.user(item.User)
+	if user == nil {
+		return nil
+	}
+	opts.OutputDir = user.downloadDir(s.opts.OutputDir)
+	over, err := user.overQuota(s.opts.OutputDir)
+	if err != nil {
+		return err
+	}
+	if over {
+		return user.quotaError()
+	}
+	return nil
+}
+
+type userKey struct{}
+
+// requestUser returns the user a request was made by, nil when the server
+// has no users configured.
+func requestUser(r *http.Request) *serverUser {
+	user, _ := r.Context().Value(userKey{}).(*serverUser)
+	return user
+}
+
+// visibleTo reports whether a queue item belongs to the user.
+func (item *queueItem) visibleTo(user *serverUser) bool {
+	return user == nil || item.User == user.Name
+}
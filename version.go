@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+type versionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+func init() {
+	// Fill in what go install/go build know when no ldflags were given
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	if version == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if commit == "" {
+				commit = setting.Value
+			}
+		case "vcs.time":
+			if buildDate == "" {
+				buildDate = setting.Value
+			}
+		}
+	}
+}
+
+func currentVersion() versionInfo {
+	return versionInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+}
+
+func runVersion(args []string) error {
+	info := currentVersion()
+
+	if len(args) > 0 && args[0] == "--json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	}
+
+	fmt.Printf("%s %s\n", programName, info.Version)
+	if info.Commit != "" {
+		fmt.Printf("Commit: %s\n", info.Commit)
+	}
+	if info.BuildDate != "" {
+		fmt.Printf("Built: %s\n", info.BuildDate)
+	}
+	fmt.Printf("Go: %s (%s)\n", info.GoVersion, info.Platform)
+	return nil
+}
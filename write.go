@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+// writeBufferSize is how much of a track is collected before it's written,
+// set by --write-buffer. Fewer, larger writes are much faster on spinning
+// disks and network shares.
+var writeBufferSize = 1 << 20
+
+// preallocate is turned off by --no-preallocate, for filesystems where
+// reserving the space is slow.
+var preallocate = true
+
+// copyToFile writes r to out through a buffer of writeBufferSize. What was
+// read is written even when reading fails, so a partial file can be
+// resumed from its size.
+func copyToFile(out *os.File, r io.Reader) (int64, error) {
+	// Hide the file's ReadFrom, which would skip the buffer
+	w := bufio.NewWriterSize(struct{ io.Writer }{out}, writeBufferSize)
+	n, err := w.ReadFrom(r)
+	if flushErr := w.Flush(); err == nil {
+		err = flushErr
+	}
+	return n, err
+}
+
+// preallocateFile reserves the space of the whole download before writing
+// it, so copy-on-write filesystems and network shares don't fragment large
+// files. Filesystems that can't do it are left alone.
+func preallocateFile(out *os.File, size int64) {
+	if !preallocate || size <= 0 {
+		return
+	}
+	allocateFile(out, size)
+}
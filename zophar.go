@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const zopharDomain = "zophar.net"
+
+// zopharProvider reads the music pages of Zophar's Domain, e.g.
+// https://www.zophar.net/music/nintendo-nes-nsf/super-mario-bros. Tracks
+// have no pages of their own there; the track list links the files.
+type zopharProvider struct{}
+
+func (zopharProvider) Name() string { return "Zophar's Domain" }
+
+func (zopharProvider) Hosts() []string { return []string{zopharDomain} }
+
+func (zopharProvider) ResolveAlbum(input string) (string, error) {
+	input = strings.TrimSpace(input)
+	if !strings.Contains(input, "://") {
+		input = "https://" + input
+	}
+	u, err := url.Parse(input)
+	if err != nil {
+		return "", err
+	}
+	// /music/<platform>/<album>
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) != 3 || parts[0] != "music" {
+		return "", newClassError(errInvalidInput, fmt.Sprintf("%s is not a Zophar's Domain music page, expected https://www.%s/music/<platform>/<album>", input, zopharDomain))
+	}
+	u.Scheme = "https"
+	u.RawQuery, u.Fragment = "", ""
+	return u.String(), nil
+}
+
+var yearPattern = regexp.MustCompile(`\b(19|20)\d\d\b`)
+
+func (zopharProvider) ListTracks(albumURL string) (*Album, error) {
+	body, err := fetchPage(albumURL)
+	if err != nil {
+		return nil, err
+	}
+	base, err := url.Parse(albumURL)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := newDocument(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	sel := selectors()
+
+	album := &Album{
+		Name:        strings.TrimSpace(doc.Find(sel.ZopharTitle).First().Text()),
+		AlbumLink:   albumURL,
+		AlbumImages: make([]string, 0),
+		Songs:       make([]*Song, 0),
+		page:        body,
+	}
+	doc.Find(sel.ZopharInfo).Each(func(i int, s *selection) {
+		label, value, ok := strings.Cut(s.Text(), ":")
+		if !ok {
+			return
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(label)) {
+		case "console", "platform":
+			album.Platforms = append(album.Platforms, value)
+		case "release date":
+			album.Year = yearPattern.FindString(value)
+		case "publisher":
+			album.Publisher = value
+		}
+	})
+	if src, ok := doc.Find(sel.ZopharCover).First().Attr("src"); ok {
+		if ref, err := url.Parse(src); err == nil {
+			album.AlbumImages = append(album.AlbumImages, base.ResolveReference(ref).String())
+		}
+	}
+
+	classifyImages(album, nil)
+
+	doc.Find(sel.ZopharRows).Each(func(i int, row *selection) {
+		href, ok := row.Find(sel.ZopharDownload).First().Attr("href")
+		if !ok {
+			return
+		}
+		ref, err := url.Parse(href)
+		if err != nil {
+			return
+		}
+		fileURL := base.ResolveReference(ref).String()
+		song := &Song{
+			Name:          strings.TrimSpace(row.Find(sel.ZopharName).Text()),
+			SongLink:      fileURL,
+			LengthSeconds: convertToSeconds(strings.TrimSpace(row.Find(sel.ZopharLength).Text())),
+			DownloadLinks: make(map[string]string),
+			Sizes:         make(map[string]int),
+		}
+		addFileLink(song, fileURL)
+		for _, format := range sortedKeys(song.DownloadLinks) {
+			if !contains(album.Formats, format) {
+				album.Formats = append(album.Formats, format)
+			}
+		}
+		album.Songs = append(album.Songs, song)
+	})
+	if len(album.Songs) == 0 {
+		dumpDebugHTML(albumURL, body, []string{sel.ZopharTitle, sel.ZopharRows, sel.ZopharRows + " " + sel.ZopharDownload})
+	}
+	return album, nil
+}
+
+// ResolveDownload has nothing to fetch, the track list already links the
+// file.
+func (zopharProvider) ResolveDownload(song *Song) error {
+	if len(song.DownloadLinks) == 0 {
+		addFileLink(song, song.SongLink)
+	}
+	return nil
+}